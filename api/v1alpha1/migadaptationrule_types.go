@@ -0,0 +1,63 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// MIGAdaptationRuleSpec defines the resources to apply to a pod when it is recreated
+type MIGAdaptationRuleSpec struct {
+	// PodName is the name of the pod, or its generate name if it has one
+	PodName string `json:"podName"`
+
+	// Containers maps container names, init containers included, to the resources to apply
+	// +optional
+	Containers map[string]corev1.ResourceRequirements `json:"containers,omitempty"`
+
+	// Pod is the bare pod deleted by the controller, kept to be recreated as it has no owner to do it
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:EmbeddedResource
+	// +optional
+	Pod *runtime.RawExtension `json:"pod,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MIGAdaptationRule is the Schema for the migadaptationrules API, it is created by the
+// controller and applied by the webhook, so every replica sees the same rules
+type MIGAdaptationRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MIGAdaptationRuleSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MIGAdaptationRuleList contains a list of MIGAdaptationRule
+type MIGAdaptationRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MIGAdaptationRule `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MIGAdaptationRule{}, &MIGAdaptationRuleList{})
+}
@@ -17,31 +17,180 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
 // NVidiaMIGAdapterSpec defines the desired state of NVidiaMIGAdapter
 type NVidiaMIGAdapterSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Namespaces limits the adaptation to pods in these namespaces, all namespaces if empty
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// NamespaceSelector limits the adaptation to pods in namespaces with matching labels, all namespaces if empty
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// PodSelector limits the adaptation to pods with matching labels, all pods if empty.
+	// A single pod opts out with the annotation adapter.gpu.turbonomic.ibm.com/policy: never,
+	// or only allows one way with upsize-only or restore-only
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// AllowedProfiles lists the MIG profiles, i.e. 2g.10gb, a pod can be sized up to, all profiles if empty
+	// +optional
+	AllowedProfiles []string `json:"allowedProfiles,omitempty"`
+
+	// MaxUpsizeRatio bounds the compute and memory of the MIG profile a pod is sized up to, as a multiple
+	// of its original MIG profile, i.e. 2 sizes up 1g.5gb to 2g.10gb at most and 1.5 sizes up 2g.10gb
+	// to 3g.15gb at most, unbounded if 0, else at least 1.
+	// A pod overrides it with the annotation adapter.gpu.turbonomic.ibm.com/max-upsize-ratio
+	// +optional
+	MaxUpsizeRatio *resource.Quantity `json:"maxUpsizeRatio,omitempty"`
+
+	// EnableRestore restarts adapted pods with their original MIG request once it becomes available
+	// +kubebuilder:default=true
+	// +optional
+	EnableRestore *bool `json:"enableRestore,omitempty"`
+
+	// RestoreMinRuntime is how long an adapted pod runs before it is restored
+	// +kubebuilder:default="5m"
+	// +optional
+	RestoreMinRuntime *metav1.Duration `json:"restoreMinRuntime,omitempty"`
+
+	// RestoreCooldown is how long after a restore neither the pod nor another pod of its workload is restored again
+	// +kubebuilder:default="10m"
+	// +optional
+	RestoreCooldown *metav1.Duration `json:"restoreCooldown,omitempty"`
+
+	// MaxRestoreCycles is how many times the pods of a workload are restored before it is pinned to its adapted
+	// MIG profile with the annotation adapter.gpu.turbonomic.ibm.com/pinned, never pinned if 0
+	// +kubebuilder:default=3
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxRestoreCycles *int32 `json:"maxRestoreCycles,omitempty"`
 
-	// Foo is an example field of NVidiaMIGAdapter. Edit nvidiamigadapter_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// RestorePolicy decides when adapted pods are restored, always if not set.
+	// A pod overrides its mode with the annotation adapter.gpu.turbonomic.ibm.com/restore-policy
+	// +optional
+	RestorePolicy *RestorePolicy `json:"restorePolicy,omitempty"`
+
+	// EnableRepartition relabels a free GPU with the MIG config a pending pod needs
+	// +kubebuilder:default=true
+	// +optional
+	EnableRepartition *bool `json:"enableRepartition,omitempty"`
+
+	// FullGPUFallback maps whole GPUs, nvidia.com/gpu of non-MIG nodes, to MIG profiles, disabled if not set
+	// +optional
+	FullGPUFallback *FullGPUFallback `json:"fullGPUFallback,omitempty"`
+
+	// DryRun only records what the adapter would do as events, metrics and in the status,
+	// without restarting pods, relabeling nodes or patching pods
+	// +optional
+	DryRun bool `json:"dryRun,omitempty"`
 }
 
-// NVidiaMIGAdapterStatus defines the observed state of NVidiaMIGAdapter
+// FullGPUFallback lets whole GPUs and the largest MIG profiles stand in for each other
+type FullGPUFallback struct {
+	// Profiles lists the MIG profiles, i.e. 7g.40gb, a whole GPU is equivalent to
+	// +kubebuilder:validation:MinItems=1
+	Profiles []string `json:"profiles"`
+
+	// MIGToGPU lets a pod waiting on one of the profiles be given a whole GPU
+	// +optional
+	MIGToGPU bool `json:"migToGPU,omitempty"`
+
+	// GPUToMIG lets a pod waiting on a whole GPU be given one of the profiles
+	// +optional
+	GPUToMIG bool `json:"gpuToMIG,omitempty"`
+}
+
+// RestorePolicy decides when adapted pods are restored to their original MIG profile
+type RestorePolicy struct {
+	// Mode is always, never, checkpoint-safe for pods annotated with adapter.gpu.turbonomic.ibm.com/checkpoint-safe: "true",
+	// maintenance-window within the MaintenanceWindows, or when-pending while another pod waits on the MIG profile
+	// an upsized pod would give back
+	// +kubebuilder:validation:Enum=always;never;checkpoint-safe;maintenance-window;when-pending
+	// +kubebuilder:default=always
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// MaintenanceWindows lists when pods in maintenance-window mode are restored
+	// +kubebuilder:validation:MaxItems=16
+	// +optional
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+}
+
+// MaintenanceWindow opens on a cron schedule for a while
+// +kubebuilder:validation:XValidation:rule="duration(self.duration) > duration('0s') && duration(self.duration) <= duration('168h')",message="duration must be more than 0s and up to 168h"
+type MaintenanceWindow struct {
+	// Schedule is when the window opens in cron format, i.e. "0 2 * * 6" on Saturdays at 2am UTC,
+	// each field a comma separated list of *, n or n-m, optionally stepped by /s. only the number of fields is
+	// checked by the API server, the adapter parses the rest and keeps its previous policy if it cannot
+	// +kubebuilder:validation:MaxLength=100
+	// +kubebuilder:validation:XValidation:rule="self.split(' ').filter(f, f != '').size() == 5",message="schedule must have 5 fields"
+	Schedule string `json:"schedule"`
+
+	// Duration is how long the window stays open, up to a week
+	Duration metav1.Duration `json:"duration"`
+}
+
+// DryRunStatus summarizes the decisions taken in dry-run mode
+type DryRunStatus struct {
+	// Upsizes is the number of times a pending pod would have been restarted with a larger MIG profile
+	Upsizes int64 `json:"upsizes,omitempty"`
+
+	// Downsizes is the number of times a pending pod would have been restarted with a smaller MIG profile
+	Downsizes int64 `json:"downsizes,omitempty"`
+
+	// Restores is the number of times an adapted pod would have been restarted with its original MIG profile
+	Restores int64 `json:"restores,omitempty"`
+
+	// Repartitions is the number of times a node would have been relabeled with another MIG config
+	Repartitions int64 `json:"repartitions,omitempty"`
+
+	// Evictions is the number of times an upsized pod would have been restarted with its original MIG profile
+	// for a pending pod of higher priority
+	Evictions int64 `json:"evictions,omitempty"`
+
+	// LastDecisions lists the latest decisions, the most recent first
+	// +optional
+	LastDecisions []string `json:"lastDecisions,omitempty"`
+}
+
+// NVidiaMIGAdapterStatus defines the observed state of NVidiaMIGAdapter, the pods are counted
+// since the resource became active, across restarts and leader changes but for the last minute of a leader
 type NVidiaMIGAdapterStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Active tells if the policy of this resource is in effect, only the oldest NVidiaMIGAdapter is
+	// +optional
+	Active bool `json:"active,omitempty"`
+
+	// AdaptedPods is the number of pending pods restarted with a larger MIG profile
+	AdaptedPods int64 `json:"adaptedPods,omitempty"`
+
+	// DownsizedPods is the number of pending pods restarted with a smaller MIG profile they opted in for
+	DownsizedPods int64 `json:"downsizedPods,omitempty"`
+
+	// RestoredPods is the number of adapted pods restarted with their original MIG profile
+	RestoredPods int64 `json:"restoredPods,omitempty"`
+
+	// RepartitionedPods is the number of pending pods a GPU has been repartitioned for
+	RepartitionedPods int64 `json:"repartitionedPods,omitempty"`
+
+	// EvictedPods is the number of upsized pods restarted with their original MIG profile for a pending pod of higher priority
+	EvictedPods int64 `json:"evictedPods,omitempty"`
+
+	// DryRun summarizes what the adapter would have done in dry-run mode
+	// +optional
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
 
-// NVidiaMIGAdapter is the Schema for the nvidiamigadapters API
+// NVidiaMIGAdapter is the Schema for the nvidiamigadapters API, the policy of the adapter is
+// cluster-wide and taken from the oldest resource
 type NVidiaMIGAdapter struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
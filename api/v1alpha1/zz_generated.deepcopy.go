@@ -21,16 +21,159 @@ limitations under the License.
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DryRunStatus) DeepCopyInto(out *DryRunStatus) {
+	*out = *in
+	if in.LastDecisions != nil {
+		in, out := &in.LastDecisions, &out.LastDecisions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DryRunStatus.
+func (in *DryRunStatus) DeepCopy() *DryRunStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(DryRunStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FullGPUFallback) DeepCopyInto(out *FullGPUFallback) {
+	*out = *in
+	if in.Profiles != nil {
+		in, out := &in.Profiles, &out.Profiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FullGPUFallback.
+func (in *FullGPUFallback) DeepCopy() *FullGPUFallback {
+	if in == nil {
+		return nil
+	}
+	out := new(FullGPUFallback)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MIGAdaptationRule) DeepCopyInto(out *MIGAdaptationRule) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MIGAdaptationRule.
+func (in *MIGAdaptationRule) DeepCopy() *MIGAdaptationRule {
+	if in == nil {
+		return nil
+	}
+	out := new(MIGAdaptationRule)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MIGAdaptationRule) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MIGAdaptationRuleList) DeepCopyInto(out *MIGAdaptationRuleList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]MIGAdaptationRule, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MIGAdaptationRuleList.
+func (in *MIGAdaptationRuleList) DeepCopy() *MIGAdaptationRuleList {
+	if in == nil {
+		return nil
+	}
+	out := new(MIGAdaptationRuleList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *MIGAdaptationRuleList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MIGAdaptationRuleSpec) DeepCopyInto(out *MIGAdaptationRuleSpec) {
+	*out = *in
+	if in.Containers != nil {
+		in, out := &in.Containers, &out.Containers
+		*out = make(map[string]v1.ResourceRequirements, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.Pod != nil {
+		in, out := &in.Pod, &out.Pod
+		*out = new(runtime.RawExtension)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MIGAdaptationRuleSpec.
+func (in *MIGAdaptationRuleSpec) DeepCopy() *MIGAdaptationRuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MIGAdaptationRuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MaintenanceWindow) DeepCopyInto(out *MaintenanceWindow) {
+	*out = *in
+	out.Duration = in.Duration
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MaintenanceWindow.
+func (in *MaintenanceWindow) DeepCopy() *MaintenanceWindow {
+	if in == nil {
+		return nil
+	}
+	out := new(MaintenanceWindow)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVidiaMIGAdapter) DeepCopyInto(out *NVidiaMIGAdapter) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVidiaMIGAdapter.
@@ -86,6 +229,66 @@ func (in *NVidiaMIGAdapterList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVidiaMIGAdapterSpec) DeepCopyInto(out *NVidiaMIGAdapterSpec) {
 	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowedProfiles != nil {
+		in, out := &in.AllowedProfiles, &out.AllowedProfiles
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxUpsizeRatio != nil {
+		in, out := &in.MaxUpsizeRatio, &out.MaxUpsizeRatio
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.EnableRestore != nil {
+		in, out := &in.EnableRestore, &out.EnableRestore
+		*out = new(bool)
+		**out = **in
+	}
+	if in.RestoreMinRuntime != nil {
+		in, out := &in.RestoreMinRuntime, &out.RestoreMinRuntime
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RestoreCooldown != nil {
+		in, out := &in.RestoreCooldown, &out.RestoreCooldown
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxRestoreCycles != nil {
+		in, out := &in.MaxRestoreCycles, &out.MaxRestoreCycles
+		*out = new(int32)
+		**out = **in
+	}
+	if in.RestorePolicy != nil {
+		in, out := &in.RestorePolicy, &out.RestorePolicy
+		*out = new(RestorePolicy)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.EnableRepartition != nil {
+		in, out := &in.EnableRepartition, &out.EnableRepartition
+		*out = new(bool)
+		**out = **in
+	}
+	if in.FullGPUFallback != nil {
+		in, out := &in.FullGPUFallback, &out.FullGPUFallback
+		*out = new(FullGPUFallback)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVidiaMIGAdapterSpec.
@@ -101,6 +304,11 @@ func (in *NVidiaMIGAdapterSpec) DeepCopy() *NVidiaMIGAdapterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NVidiaMIGAdapterStatus) DeepCopyInto(out *NVidiaMIGAdapterStatus) {
 	*out = *in
+	if in.DryRun != nil {
+		in, out := &in.DryRun, &out.DryRun
+		*out = new(DryRunStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NVidiaMIGAdapterStatus.
@@ -112,3 +320,23 @@ func (in *NVidiaMIGAdapterStatus) DeepCopy() *NVidiaMIGAdapterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestorePolicy) DeepCopyInto(out *RestorePolicy) {
+	*out = *in
+	if in.MaintenanceWindows != nil {
+		in, out := &in.MaintenanceWindows, &out.MaintenanceWindows
+		*out = make([]MaintenanceWindow, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestorePolicy.
+func (in *RestorePolicy) DeepCopy() *RestorePolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(RestorePolicy)
+	in.DeepCopyInto(out)
+	return out
+}
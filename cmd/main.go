@@ -25,12 +25,17 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
@@ -59,6 +64,9 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var gpuOperatorNamespace string
+	var ruleStore string
+	var ruleStoreNamespace string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
@@ -68,6 +76,14 @@ func main() {
 		"If set the metrics endpoint is served securely")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&gpuOperatorNamespace, "gpu-operator-namespace", gpuadapter.DEFAULT_GPU_OPERATOR_NAMESPACE,
+		"The namespace of the NVidia GPU Operator, where the mig-parted config map is")
+	flag.StringVar(&ruleStore, "rule-store", gpuadapter.RULESTORE_MEMORY,
+		"Where to keep the rules for pods to be recreated, either "+gpuadapter.RULESTORE_MEMORY+", "+
+			gpuadapter.RULESTORE_CONFIGMAP+" to survive restarts and leader changes, or "+
+			gpuadapter.RULESTORE_CRD+" to also share them with the webhooks of all replicas")
+	flag.StringVar(&ruleStoreNamespace, "rule-store-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the config maps keeping the rules, defaults to the namespace of the manager")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -98,6 +114,12 @@ func main() {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		// the mig-parted config map is read on demand, no need to cache all config maps
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				DisableFor: []client.Object{&corev1.ConfigMap{}},
+			},
+		},
 		Metrics: metricsserver.Options{
 			BindAddress:   metricsAddr,
 			SecureServing: secureMetrics,
@@ -125,11 +147,51 @@ func main() {
 	}
 
 	adapter := gpuadapter.GetAdapter(mgr.GetClient())
+	adapter.GPUOperatorNamespace = gpuOperatorNamespace
+	adapter.Namespace = os.Getenv("POD_NAMESPACE")
+	adapter.Recorder = mgr.GetEventRecorderFor(gpuadapter.EVENT_SOURCE)
+	adapter.APIReader = mgr.GetAPIReader()
+	metrics.Registry.MustRegister(adapter.NewCapacityCollector())
+
+	switch ruleStore {
+	case gpuadapter.RULESTORE_MEMORY:
+	case gpuadapter.RULESTORE_CONFIGMAP:
+		if ruleStoreNamespace == "" {
+			setupLog.Error(nil, "rule store namespace is required", "rule-store", ruleStore)
+			os.Exit(1)
+		}
+		// the rules are read on every admission, the config maps of the rule store are cached apart
+		ruleCache, err := cache.New(mgr.GetConfig(), cache.Options{
+			Scheme:               mgr.GetScheme(),
+			Mapper:               mgr.GetRESTMapper(),
+			DefaultNamespaces:    map[string]cache.Config{ruleStoreNamespace: {}},
+			DefaultLabelSelector: labels.SelectorFromSet(labels.Set{gpuadapter.LABELKEY_RULESTORE: gpuadapter.RULESTORE_CONFIGMAP}),
+		})
+		if err != nil {
+			setupLog.Error(err, "unable to create rule store cache", "rule-store", ruleStore)
+			os.Exit(1)
+		}
+		if err := mgr.Add(ruleCache); err != nil {
+			setupLog.Error(err, "unable to add rule store cache", "rule-store", ruleStore)
+			os.Exit(1)
+		}
+		adapter.SetRuleStore(gpuadapter.NewConfigMapRuleStore(mgr.GetClient(), ruleCache, ruleStoreNamespace))
+	case gpuadapter.RULESTORE_CRD:
+		adapter.SetRuleStore(gpuadapter.NewCRDRuleStore(mgr.GetClient(), mgr.GetAPIReader()))
+	default:
+		setupLog.Error(nil, "unknown rule store", "rule-store", ruleStore)
+		os.Exit(1)
+	}
+	if err := mgr.Add(adapter.NewRulePruner()); err != nil {
+		setupLog.Error(err, "unable to add rule pruner", "rule-store", ruleStore)
+		os.Exit(1)
+	}
 
 	if os.Getenv("ENABLE_CONFIG_CRD") == "true" {
 		if err = (&gpucontroller.NVidiaMIGAdapterReconciler{
-			Client: mgr.GetClient(),
-			Scheme: mgr.GetScheme(),
+			Client:  mgr.GetClient(),
+			Scheme:  mgr.GetScheme(),
+			Adapter: adapter,
 		}).SetupWithManager(mgr); err != nil {
 			setupLog.Error(err, "unable to create config crd controller", "controller", "NVidiaMIGAdapter")
 			os.Exit(1)
@@ -17,16 +17,23 @@ limitations under the License.
 package adapter
 
 import (
+	"context"
 	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 )
 
 const (
 	ADAPTER_ANNOTATION_PREFIX   = "adapter.gpu.turbonomic.ibm.com/"
 	ADAPTER_ANNOTATION_ORIGINAL = "original"
+	ADAPTER_ANNOTATION_POLICY   = "policy"
 )
 
 type PodResources map[string]corev1.ResourceRequirements
@@ -34,8 +41,38 @@ type PodResources map[string]corev1.ResourceRequirements
 type Adapter struct {
 	client.Client
 
-	m     sync.RWMutex
-	rules map[types.NamespacedName]PodResources
+	// namespace of the GPU Operator, where the mig-parted config map is
+	GPUOperatorNamespace string
+	// namespace of the adapter itself, whose pods are never adapted either, none if empty
+	Namespace string
+
+	// emits the decisions on pods and nodes, none if nil
+	Recorder record.EventRecorder
+
+	// reads the controllers of pods past the cache, the client if nil
+	APIReader client.Reader
+
+	// m serializes read-modify-write of the rules
+	m     sync.Mutex
+	rules RuleStore
+
+	pm     sync.RWMutex
+	policy Policy
+	stats  Statistics
+	// what of stats has been added to the status already
+	reported Statistics
+
+	// rm guards the migs held for restarted pods, by namespace and name of the restarted pod
+	rm           sync.Mutex
+	reservations map[types.NamespacedName]migReservation
+
+	// bm guards the pods left alone after their eviction was blocked or they were restored, by pod key,
+	// when the pods held back by the last restore check become due and when the next check is scheduled
+	bm               sync.Mutex
+	backoffs         map[types.NamespacedName]restartBackoff
+	restores         map[types.NamespacedName]time.Time
+	nextRestore      time.Time
+	scheduledRestore time.Time
 }
 
 var _adapter *Adapter
@@ -44,44 +81,96 @@ func GetAdapter(cli client.Client) *Adapter {
 
 	if _adapter == nil {
 		_adapter = &Adapter{
-			Client: cli,
+			Client:               cli,
+			GPUOperatorNamespace: DEFAULT_GPU_OPERATOR_NAMESPACE,
+			policy:               DefaultPolicy(),
 		}
 	}
 
 	if _adapter.rules == nil {
-		_adapter.rules = make(map[types.NamespacedName]PodResources)
+		_adapter.rules = NewMemoryRuleStore()
 	}
 
 	return _adapter
 }
 
+// SetRuleStore replaces the in-memory rule store, i.e. with one surviving restarts
+func (a *Adapter) SetRuleStore(store RuleStore) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	a.rules = store
+}
+
+// genPodKey matches a pod with the one recreated for it.
+// pods of a controller are matched by generate name as the recreated pod gets a new name,
+// but StatefulSet pods keep their ordinal name and bare pods are recreated by the adapter with the same name
 func (a *Adapter) genPodKey(pod *corev1.Pod) types.NamespacedName {
 	podkey := types.NamespacedName{
 		Namespace: pod.Namespace,
+		Name:      pod.Name,
 	}
 
-	if pod.GenerateName != "" {
+	owner := metav1.GetControllerOf(pod)
+	if pod.GenerateName != "" && owner != nil && owner.Kind != OWNER_KIND_STATEFULSET {
 		podkey.Name = pod.GenerateName
-	} else {
-		podkey.Name = pod.Name
 	}
 	return podkey
 }
 
-func (a *Adapter) removeResourceRulesForPod(podkey types.NamespacedName) {
+// podContainers lists the init containers and then the containers of a pod,
+// container names are unique across both so rules are kept by name for either
+func podContainers(spec *corev1.PodSpec) []*corev1.Container {
+	containers := []*corev1.Container{}
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+
+	return containers
+}
+
+func (a *Adapter) removeResourceRulesForPod(ctx context.Context, podkey types.NamespacedName) {
 	a.m.Lock()
 	defer a.m.Unlock()
 
-	delete(a.rules, podkey)
+	if err := a.rules.RemoveRules(ctx, podkey); err != nil {
+		amlog.Error(err, "remove rules", "pod", podkey.String())
+	}
 }
 
-func (a *Adapter) storeResourceRulesForContainer(podkey types.NamespacedName, container string, req corev1.ResourceList, limits corev1.ResourceList, claims []corev1.ResourceClaim) {
+// PruneRulesWithContext removes the rules and bare pods kept longer than RULESTORE_ENTRY_TTL,
+// their pods were deleted or scaled down before they were recreated
+func (a *Adapter) PruneRulesWithContext(ctx context.Context) {
+	a.m.Lock()
+	rules := a.rules
+	a.m.Unlock()
+
+	if err := rules.Prune(ctx, time.Now().Add(-RULESTORE_ENTRY_TTL)); err != nil {
+		amlog.Error(err, "prune rules")
+	}
+}
+
+// NewRulePruner returns the runnable pruning the rule store every RULESTORE_PRUNE_PERIOD, on the leader only
+func (a *Adapter) NewRulePruner() manager.Runnable {
+	return manager.RunnableFunc(func(ctx context.Context) error {
+		wait.UntilWithContext(ctx, a.PruneRulesWithContext, RULESTORE_PRUNE_PERIOD)
+		return nil
+	})
+}
+
+func (a *Adapter) storeResourceRulesForContainer(ctx context.Context, podkey types.NamespacedName, container string, req corev1.ResourceList, limits corev1.ResourceList, claims []corev1.ResourceClaim) error {
 	a.m.Lock()
 	defer a.m.Unlock()
 
-	podRes, exists := a.rules[podkey]
+	podRes, err := a.rules.GetRules(ctx, podkey)
+	if err != nil {
+		return err
+	}
 
-	if !exists {
+	if podRes == nil {
 		podRes = make(PodResources)
 	}
 
@@ -101,16 +190,24 @@ func (a *Adapter) storeResourceRulesForContainer(podkey types.NamespacedName, co
 	}
 
 	podRes[container] = containerRes
-	a.rules[podkey] = podRes
+	return a.rules.StoreRules(ctx, podkey, podRes)
 }
 
-func (a *Adapter) getResourceRulesForContainer(podkey types.NamespacedName, container string) (corev1.ResourceList, corev1.ResourceList, []corev1.ResourceClaim) {
+func (a *Adapter) getResourceRulesForPod(ctx context.Context, podkey types.NamespacedName) PodResources {
 
-	a.m.RLock()
-	defer a.m.RUnlock()
+	podRes, err := a.rules.GetRules(ctx, podkey)
+	if err != nil {
+		amlog.Error(err, "get rules", "pod", podkey.String())
+		return nil
+	}
 
-	podRes, exists := a.rules[podkey]
-	if !exists {
+	return podRes
+}
+
+func (a *Adapter) getResourceRulesForContainer(ctx context.Context, podkey types.NamespacedName, container string) (corev1.ResourceList, corev1.ResourceList, []corev1.ResourceClaim) {
+
+	podRes := a.getResourceRulesForPod(ctx, podkey)
+	if podRes == nil {
 		return nil, nil, nil
 	}
 
@@ -63,7 +63,7 @@ var _ = Describe("API for Adapter", func() {
 		It("should be stored, retrieved, deleted correctly", func() {
 			pod := _test_pod1.DeepCopy()
 			podkey := adapter.genPodKey(pod)
-			req, limit, claims := adapter.getResourceRulesForContainer(podkey, _test_container1_name)
+			req, limit, claims := adapter.getResourceRulesForContainer(ctx, podkey, _test_container1_name)
 			Expect(req).To(BeNil())
 			Expect(limit).To(BeNil())
 			Expect(claims).To(BeNil())
@@ -81,15 +81,15 @@ var _ = Describe("API for Adapter", func() {
 				},
 			}
 
-			adapter.storeResourceRulesForContainer(podkey, _test_container1_name, creq, climit, cclaims)
+			adapter.storeResourceRulesForContainer(ctx, podkey, _test_container1_name, creq, climit, cclaims)
 
-			req, limit, claims = adapter.getResourceRulesForContainer(podkey, _test_container1_name)
+			req, limit, claims = adapter.getResourceRulesForContainer(ctx, podkey, _test_container1_name)
 			Expect(req).To(BeEquivalentTo(creq))
 			Expect(limit).To(BeEquivalentTo(climit))
 			Expect(claims).To(BeEquivalentTo(cclaims))
 
-			adapter.removeResourceRulesForPod(podkey)
-			req, limit, claims = adapter.getResourceRulesForContainer(podkey, _test_container1_name)
+			adapter.removeResourceRulesForPod(ctx, podkey)
+			req, limit, claims = adapter.getResourceRulesForContainer(ctx, podkey, _test_container1_name)
 			Expect(req).To(BeNil())
 			Expect(limit).To(BeNil())
 			Expect(claims).To(BeNil())
@@ -0,0 +1,167 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotations bounding how far the migs of a pod are sized up
+	ADAPTER_ANNOTATION_MAX_PROFILE      = "max-profile"
+	ADAPTER_ANNOTATION_ALLOWED_PROFILES = "allowed-profiles"
+	ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO = "max-upsize-ratio"
+	// opts in to be sized down, no smaller than the profile
+	ADAPTER_ANNOTATION_MIN_PROFILE = "min-profile"
+
+	PROFILE_LIST_SEPARATOR = ","
+)
+
+// migBound limits the migs a container can be sized up to
+type migBound struct {
+	// largest compute and memory, unbounded if nil
+	Max *migIdentifier
+	// profiles like 2g.10gb, all profiles if empty
+	Allowed []string
+}
+
+// permits tells if the container can be sized up to mig, the current mig of the container is always permitted
+func (b *migBound) permits(mig *migIdentifier) bool {
+	if b == nil {
+		return true
+	}
+
+	if b.Max != nil && (mig.computeSlices() > b.Max.computeSlices() || mig.Memory > b.Max.Memory || mig.Full && !b.Max.Full) {
+		return false
+	}
+
+	return len(b.Allowed) == 0 || isProfileInList(mig, b.Allowed)
+}
+
+// profiles can be given either as 2g.10gb or as the full resource name nvidia.com/mig-2g.10gb
+func isProfileInList(mig *migIdentifier, profiles []string) bool {
+	name := strings.TrimPrefix(mig.String(), RESOURCE_MIG_PREFIX)
+	for _, p := range profiles {
+		if strings.TrimPrefix(strings.TrimSpace(p), RESOURCE_MIG_PREFIX) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getMIGBoundsForPod returns the bound of each container from the pod annotations and the default upsize ratio
+// of the policy, nil if the pod is unbounded. the ratio applies to the original migs of an adapted pod,
+// so that a pod sized up again is not sized up further
+func (a *Adapter) getMIGBoundsForPod(pod *corev1.Pod) map[string]*migBound {
+
+	var ceiling *migIdentifier
+	if value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_MAX_PROFILE]; exists {
+		ceiling = &migIdentifier{}
+		if err := ceiling.Parse(RESOURCE_MIG_PREFIX + strings.TrimPrefix(strings.TrimSpace(value), RESOURCE_MIG_PREFIX)); err != nil {
+			// nothing larger than the current migs rather than anything
+			aplog.Error(err, "invalid max profile, pod not sized up", "name", pod.Name, "namespace", pod.Namespace, "profile", value)
+			ceiling = &migIdentifier{}
+		}
+	}
+
+	allowed := []string{}
+	if value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ALLOWED_PROFILES]; exists {
+		allowed = strings.Split(value, PROFILE_LIST_SEPARATOR)
+	}
+
+	ratio := a.GetPolicy().MaxUpsizeRatio
+	if value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO]; exists {
+		r, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || math.IsNaN(r) || math.IsInf(r, 0) || r < 1 {
+			aplog.Info("invalid max upsize ratio, pod not sized up", "name", pod.Name, "namespace", pod.Namespace, "ratio", value)
+			r = 1
+		}
+		ratio = r
+	}
+
+	if ceiling == nil && len(allowed) == 0 && ratio <= 0 {
+		return nil
+	}
+
+	originals, err := a.getOriginalResources(pod)
+	if err != nil {
+		aplog.Error(err, "invalid original resources", "name", pod.Name, "namespace", pod.Namespace)
+	}
+
+	bounds := make(map[string]*migBound)
+	for _, c := range podContainers(&pod.Spec) {
+		bound := &migBound{
+			Max:     ceiling,
+			Allowed: allowed,
+		}
+
+		if ratio > 0 {
+			base := a.getContainerMIG(c.Resources)
+			if original, exists := originals[c.Name]; exists && a.getContainerMIG(original) != nil {
+				base = a.getContainerMIG(original)
+			}
+			// a whole GPU has nothing to be a multiple of
+			if base != nil && !base.Full {
+				capped := &migIdentifier{
+					Compute: int(math.Floor(float64(base.computeSlices()) * ratio)),
+					Memory:  base.Memory * ratio,
+				}
+				if bound.Max != nil {
+					capped.Compute = min(capped.Compute, bound.Max.computeSlices())
+					capped.Memory = min(capped.Memory, bound.Max.Memory)
+				}
+				bound.Max = capped
+			}
+		}
+
+		bounds[c.Name] = bound
+	}
+
+	return bounds
+}
+
+// getMinMIGForPod returns the profile a pod opted in to be sized down to, nil if it did not or it is invalid
+func (a *Adapter) getMinMIGForPod(pod *corev1.Pod) *migIdentifier {
+
+	value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_MIN_PROFILE]
+	if !exists {
+		return nil
+	}
+
+	floor := &migIdentifier{}
+	if err := floor.Parse(RESOURCE_MIG_PREFIX + strings.TrimPrefix(strings.TrimSpace(value), RESOURCE_MIG_PREFIX)); err != nil {
+		aplog.Error(err, "invalid min profile, pod not sized down", "name", pod.Name, "namespace", pod.Namespace, "profile", value)
+		return nil
+	}
+
+	return floor
+}
+
+// getContainerMIG returns the mig in the requests, or else in the limits, nil if none
+func (a *Adapter) getContainerMIG(res corev1.ResourceRequirements) *migIdentifier {
+	mig, _ := a.currentMIGResource(res.Requests)
+	if mig == nil {
+		mig, _ = a.currentMIGResource(res.Limits)
+	}
+
+	return mig
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Bounds for Adapter", func() {
+
+	var adapter *Adapter
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	annotate := func(pod *corev1.Pod, name, value string) {
+		if pod.Annotations == nil {
+			pod.Annotations = make(map[string]string)
+		}
+		pod.Annotations[ADAPTER_ANNOTATION_PREFIX+name] = value
+	}
+
+	Context("For a bound", func() {
+		It("should permit migs within the max and the allowed profiles", func() {
+			mig2, mig3 := &migIdentifier{}, &migIdentifier{}
+			Expect(mig2.Parse(_test_mig_Identifier_string_2_10)).To(Succeed())
+			Expect(mig3.Parse(_test_mig_Identifier_string_3_20)).To(Succeed())
+
+			var unbounded *migBound
+			Expect(unbounded.permits(mig3)).To(BeTrue())
+
+			bound := &migBound{Max: mig2}
+			Expect(bound.permits(mig2)).To(BeTrue())
+			Expect(bound.permits(mig3)).To(BeFalse())
+
+			bound = &migBound{Allowed: []string{"3g.20gb"}}
+			Expect(bound.permits(mig2)).To(BeFalse())
+			Expect(bound.permits(mig3)).To(BeTrue())
+		})
+	})
+
+	Context("For a pod without annotations", func() {
+		It("should be unbounded unless the policy has a ratio", func() {
+			pod := _test_podpending.DeepCopy()
+			Expect(adapter.getMIGBoundsForPod(pod)).To(BeNil())
+
+			policy := DefaultPolicy()
+			policy.MaxUpsizeRatio = 2
+			adapter.SetPolicy(policy)
+
+			bounds := adapter.getMIGBoundsForPod(pod)
+			Expect(bounds).To(HaveKey(_test_container1_name))
+			Expect(bounds[_test_container1_name].Max.String()).To(Equal(_test_mig_Identifier_string_2_10))
+		})
+	})
+
+	Context("For a pod with a ratio", func() {
+		It("should bound from its original migs", func() {
+			pod := _test_podpending.DeepCopy()
+			original := PodResources{
+				_test_container1_name: *pod.Spec.Containers[0].Resources.DeepCopy(),
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+			annotate(pod, ADAPTER_ANNOTATION_ORIGINAL, string(bytes))
+			annotate(pod, ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO, "2")
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+
+			bounds := adapter.getMIGBoundsForPod(pod)
+			Expect(bounds[_test_container1_name].Max.String()).To(Equal(_test_mig_Identifier_string_2_10))
+		})
+
+		It("should take the smaller of the ratio and the max profile", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO, "4")
+			annotate(pod, ADAPTER_ANNOTATION_MAX_PROFILE, "3g.20gb")
+
+			bounds := adapter.getMIGBoundsForPod(pod)
+			Expect(bounds[_test_container1_name].Max).To(Equal(&migIdentifier{Compute: 3, Memory: 20}))
+		})
+
+		It("should bound with a fractional ratio", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO, "1.5")
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+
+			bounds := adapter.getMIGBoundsForPod(pod)
+			Expect(bounds[_test_container1_name].Max).To(Equal(&migIdentifier{Compute: 3, Memory: 15}))
+		})
+
+		It("should not size up with an invalid ratio", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_MAX_UPSIZE_RATIO, "twice")
+
+			bounds := adapter.getMIGBoundsForPod(pod)
+			Expect(bounds[_test_container1_name].Max.String()).To(Equal(_test_mig_Identifier_string_1_5))
+		})
+	})
+
+	Context("For a pending pod with a max profile", func() {
+		It("should not be sized up beyond it", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_MAX_PROFILE, "1g.5gb")
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeFalse())
+			Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_podpending.Spec.Containers[0].Resources))
+		})
+
+		It("should not be sized up with an invalid one", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_MAX_PROFILE, "large")
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeFalse())
+		})
+	})
+
+	Context("For a pending pod with allowed profiles", func() {
+		It("should only be sized up to them", func() {
+			pod := _test_podpending.DeepCopy()
+			annotate(pod, ADAPTER_ANNOTATION_ALLOWED_PROFILES, "4g.20gb, nvidia.com/mig-3g.20gb")
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{
+				_test_mig_Identifier_string_3_20: _test_quantity_1,
+			}))
+		})
+	})
+})
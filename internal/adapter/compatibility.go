@@ -0,0 +1,168 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	ADAPTER_ANNOTATION_COMPATIBILITY = "compatibility"
+
+	// compute and memory both at least the requested ones, the default
+	COMPATIBILITY_BOTH = "both"
+	// memory at least the requested one whatever the compute, for inference
+	COMPATIBILITY_MEMORY_DOMINANT = "memory-dominant"
+	// compute at least the requested one whatever the memory, for training
+	COMPATIBILITY_COMPUTE_DOMINANT = "compute-dominant"
+)
+
+// migCompatibility tells which migs can stand in for the one a container asks for, and which to try first
+type migCompatibility interface {
+	// Fits tells if mig can be given to a container asking for requested
+	Fits(requested, mig *migIdentifier) bool
+	// Less orders the migs fitting, the preferred first
+	Less(m, n *migIdentifier) bool
+}
+
+type bothCompatibility struct{}
+
+func (bothCompatibility) Fits(requested, mig *migIdentifier) bool {
+	return mig.computeSlices() >= requested.computeSlices() && mig.Memory >= requested.Memory && hasAttributesFor(requested, mig)
+}
+
+func (bothCompatibility) Less(m, n *migIdentifier) bool {
+	return m.Less(n)
+}
+
+type memoryDominantCompatibility struct{}
+
+func (memoryDominantCompatibility) Fits(requested, mig *migIdentifier) bool {
+	return mig.Memory >= requested.Memory && hasAttributesFor(requested, mig)
+}
+
+func (memoryDominantCompatibility) Less(m, n *migIdentifier) bool {
+	if m.Memory != n.Memory {
+		return m.Memory < n.Memory
+	}
+	return m.Less(n)
+}
+
+type computeDominantCompatibility struct{}
+
+func (computeDominantCompatibility) Fits(requested, mig *migIdentifier) bool {
+	return mig.computeSlices() >= requested.computeSlices() && hasAttributesFor(requested, mig)
+}
+
+func (computeDominantCompatibility) Less(m, n *migIdentifier) bool {
+	return m.Less(n)
+}
+
+// hasAttributesFor tells if mig has what requested asks for beyond compute and memory: the same attributes,
+// or only added ones like +me if requested has none
+func hasAttributesFor(requested, mig *migIdentifier) bool {
+	if mig.Attributes == requested.Attributes {
+		return true
+	}
+
+	return requested.Attributes == "" && !strings.Contains(mig.Attributes, "-")
+}
+
+// migCompatibilities are the compatibilities a pod can select by annotation
+var migCompatibilities = map[string]migCompatibility{
+	COMPATIBILITY_BOTH:             bothCompatibility{},
+	COMPATIBILITY_MEMORY_DOMINANT:  memoryDominantCompatibility{},
+	COMPATIBILITY_COMPUTE_DOMINANT: computeDominantCompatibility{},
+}
+
+// getCompatibilityForPod returns the compatibility selected by the pod annotation, both if none or unknown
+func (a *Adapter) getCompatibilityForPod(pod *corev1.Pod) migCompatibility {
+
+	value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_COMPATIBILITY]
+	if !exists {
+		return migCompatibilities[COMPATIBILITY_BOTH]
+	}
+
+	compatibility, ok := migCompatibilities[value]
+	if !ok {
+		aplog.Info("unknown compatibility, both taken", "name", pod.Name, "namespace", pod.Namespace, "compatibility", value)
+		return migCompatibilities[COMPATIBILITY_BOTH]
+	}
+
+	return compatibility
+}
+
+// orderCompatibleMIGs returns the migs of order fitting requested without duplicates, requested and then the preferred first,
+// followed by the ones a whole GPU is mapped to
+func (a *Adapter) orderCompatibleMIGs(requested *migIdentifier, order OrderedmigIdentifierList, compatibility migCompatibility) []migIdentifier {
+
+	if compatibility == nil {
+		compatibility = migCompatibilities[COMPATIBILITY_BOTH]
+	}
+
+	seen := make(map[migIdentifier]bool)
+	migs := []migIdentifier{}
+	for _, n := range order {
+		if seen[n] {
+			continue
+		}
+		// whole GPUs only stand in for the migs they are mapped to, below
+		if n.Full || requested.Full {
+			if !n.Equal(requested) {
+				continue
+			}
+		} else if !compatibility.Fits(requested, &n) {
+			continue
+		}
+		seen[n] = true
+		migs = append(migs, n)
+	}
+
+	// the requested mig always comes first, a mig standing in for it is only taken if it is not left
+	sort.SliceStable(migs, func(i, j int) bool {
+		if migs[i].Equal(requested) || migs[j].Equal(requested) {
+			return migs[i].Equal(requested) && !migs[j].Equal(requested)
+		}
+		return compatibility.Less(&migs[i], &migs[j])
+	})
+
+	return append(migs, a.getFullGPUCandidates(requested, order)...)
+}
+
+// orderSmallerMIGs returns the migs of order fitting floor and no larger than current, the largest first,
+// current always comes first even if smaller than floor
+func (a *Adapter) orderSmallerMIGs(current, floor *migIdentifier, order OrderedmigIdentifierList, compatibility migCompatibility) []migIdentifier {
+
+	if compatibility == nil {
+		compatibility = migCompatibilities[COMPATIBILITY_BOTH]
+	}
+
+	migs := []migIdentifier{*current}
+	fitting := a.orderCompatibleMIGs(floor, order, compatibility)
+	for i := len(fitting) - 1; i >= 0; i-- {
+		n := fitting[i]
+		if n.Full || n.Equal(current) || !compatibility.Fits(&n, current) {
+			continue
+		}
+		migs = append(migs, n)
+	}
+
+	return migs
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("Compatibility for Adapter", func() {
+
+	adapter := &Adapter{
+		policy: DefaultPolicy(),
+	}
+
+	// the profiles of an H100 80GB
+	order := OrderedmigIdentifierList{
+		{Compute: 1, Memory: 10},
+		{Compute: 1, Memory: 20},
+		{Compute: 2, Memory: 20},
+		{Compute: 3, Memory: 40},
+		{Compute: 4, Memory: 40},
+		{Compute: 7, Memory: 80},
+	}
+	requested := &migIdentifier{Compute: 2, Memory: 20}
+
+	Context("For both compute and memory", func() {
+		It("should only take larger migs", func() {
+			Expect(adapter.orderCompatibleMIGs(requested, order, migCompatibilities[COMPATIBILITY_BOTH])).To(Equal([]migIdentifier{
+				{Compute: 2, Memory: 20},
+				{Compute: 3, Memory: 40},
+				{Compute: 4, Memory: 40},
+				{Compute: 7, Memory: 80},
+			}))
+		})
+	})
+
+	Context("For memory first", func() {
+		It("should take migs with less compute but enough memory", func() {
+			Expect(adapter.orderCompatibleMIGs(requested, order, migCompatibilities[COMPATIBILITY_MEMORY_DOMINANT])).To(Equal([]migIdentifier{
+				{Compute: 2, Memory: 20},
+				{Compute: 1, Memory: 20},
+				{Compute: 3, Memory: 40},
+				{Compute: 4, Memory: 40},
+				{Compute: 7, Memory: 80},
+			}))
+		})
+	})
+
+	Context("For compute first", func() {
+		It("should take migs with less memory but enough compute", func() {
+			withLessMemory := append(OrderedmigIdentifierList{{Compute: 2, Memory: 10}}, order...)
+			Expect(adapter.orderCompatibleMIGs(requested, withLessMemory, migCompatibilities[COMPATIBILITY_COMPUTE_DOMINANT])).To(Equal([]migIdentifier{
+				{Compute: 2, Memory: 20},
+				{Compute: 2, Memory: 10},
+				{Compute: 3, Memory: 40},
+				{Compute: 4, Memory: 40},
+				{Compute: 7, Memory: 80},
+			}))
+		})
+	})
+
+	Context("For a mig with media engines", func() {
+		It("should only be stood in for by migs with media engines too", func() {
+			withMediaEngines := &migIdentifier{Compute: 1, Memory: 10, Attributes: "+me"}
+			migs := OrderedmigIdentifierList{
+				{Compute: 1, Memory: 10},
+				{Compute: 1, Memory: 10, Attributes: "+me"},
+				{Compute: 2, Memory: 20},
+			}
+
+			Expect(adapter.orderCompatibleMIGs(withMediaEngines, migs, nil)).To(Equal([]migIdentifier{
+				{Compute: 1, Memory: 10, Attributes: "+me"},
+			}))
+			Expect(adapter.orderCompatibleMIGs(&migs[0], migs, nil)).To(Equal([]migIdentifier{
+				{Compute: 1, Memory: 10},
+				{Compute: 1, Memory: 10, Attributes: "+me"},
+				{Compute: 2, Memory: 20},
+			}))
+		})
+	})
+
+	Context("For a pod with a compatibility annotation", func() {
+		It("should be sized to the mig of its compatibility", func() {
+			for compatibility, expected := range map[string]corev1.ResourceName{
+				"":                             "nvidia.com/mig-3g.40gb",
+				COMPATIBILITY_MEMORY_DOMINANT:  "nvidia.com/mig-1g.20gb",
+				COMPATIBILITY_COMPUTE_DOMINANT: "nvidia.com/mig-3g.40gb",
+				"unknown":                      "nvidia.com/mig-3g.40gb",
+			} {
+				pod := _test_podpending.DeepCopy()
+				if compatibility != "" {
+					pod.Annotations = map[string]string{
+						ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_COMPATIBILITY: compatibility,
+					}
+				}
+				resources := PodResources{
+					_test_container1_name: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{
+							"nvidia.com/mig-2g.20gb": _test_quantity_1,
+						},
+					},
+				}
+				available := availableMIGMap{
+					"h100": availableMIGsOnNode{
+						MIGs: map[migIdentifier]resource.Quantity{
+							{Compute: 1, Memory: 20}: _test_quantity_1,
+							{Compute: 3, Memory: 40}: _test_quantity_1,
+						},
+					},
+				}
+
+				updated, _ := adapter.checkAndSizeUpMIGsForPodResources(resources, nil, nil, adapter.getCompatibilityForPod(pod), nil, available, order)
+				Expect(updated).To(Equal([]string{_test_container1_name}), compatibility)
+				Expect(resources[_test_container1_name].Requests).To(HaveKey(expected), compatibility)
+			}
+		})
+	})
+})
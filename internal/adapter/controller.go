@@ -18,8 +18,8 @@ package adapter
 
 import (
 	"context"
-	"encoding/json"
 	"strings"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -33,6 +33,14 @@ var aclog = logf.Log.WithName("adapter controller")
 
 func (a *Adapter) CheckAndRestorePodsWithContext(ctx context.Context, nodes []corev1.Node, podItems []corev1.Pod) []*corev1.Pod {
 
+	// pods held back for now are checked again once the first of them becomes due
+	next, nextWindow := time.Time{}, time.Time{}
+	defer func() { a.setNextRestoreCheck(next) }()
+
+	if !a.GetPolicy().RestoreEnabled {
+		return nil
+	}
+
 	available := a.detectAllAvailableMIGs(nodes, podItems)
 	if len(available) == 0 {
 		return nil
@@ -40,36 +48,158 @@ func (a *Adapter) CheckAndRestorePodsWithContext(ctx context.Context, nodes []co
 	order := a.buildOrderedMIGList(available)
 
 	podsToRestart := []*corev1.Pod{}
+	inflight := countRestartsInFlight(podItems)
+	now := time.Now()
+	windows := a.GetPolicy().MaintenanceWindows
+	inWindow := isInMaintenanceWindow(windows, now)
+	var pending map[migIdentifier]bool
 
-	// start with the larget mig demand for best gain
+	// start with the highest priority, and the larget mig demand for best gain
 	pods := a.filterAndSortPodsDescendingByMIG(podItems)
+	a.sortPodsByPriorityWithContext(ctx, pods)
+	// the workloads are read at most once per check
+	histories := make(map[string]restoreHistory)
 	for _, pod := range pods {
-		org, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]
-		if !exists {
+		// only adapted pods have anything to restore, others are skipped before any read
+		if _, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]; !exists {
 			continue
 		}
-
-		records := PodResources{}
-		err := json.Unmarshal([]byte(org), &records)
+		if !a.isRestoreAllowedWithContext(ctx, pod) || a.isRestartBackingOff(pod) {
+			continue
+		}
+		due, ok := a.getRestoreDueWithContext(ctx, pod, histories)
+		if !ok {
+			continue
+		}
+		if due.After(now) {
+			next = earlier(next, due)
+			continue
+		}
+		if !a.CanRestartPodWithContext(ctx, pod) {
+			continue
+		}
+		mode := a.getRestorePolicy(pod)
+		if !isRestorePermitted(pod, mode, inWindow) {
+			if mode == RESTORE_POLICY_MAINTENANCE_WINDOW {
+				if nextWindow.IsZero() {
+					nextWindow = nextMaintenanceWindow(windows, now)
+				}
+				next = earlier(next, nextWindow)
+			}
+			continue
+		}
+		// the replicas of a workload are restored one after the other
+		workload := getWorkloadKey(pod)
+		if workload != "" && inflight[workload] >= MAX_RESTORES_PER_WORKLOAD {
+			continue
+		}
+		records, err := a.getOriginalResources(pod)
 		if err != nil || len(records) == 0 {
 			continue
 		}
 
-		restart := false
-		for _, c := range pod.Spec.Containers {
-			original := records[c.Name]
-			updated := a.checkAndSizeUpMIGForContainerResource(original.Requests, original.Limits, pod.Spec.NodeSelector, available, order)
-			if a.compareMIGResources(original.Requests, c.Resources.Requests) == -1 {
+		// the original migs of all restored containers must fit at once, on top of what the pod holds now
+		resources := PodResources{}
+		for name, original := range records {
+			resources[name] = *original.DeepCopy()
+		}
+		// a pod steered to another product of single strategy nodes holds the mig of the product it is steered to
+		view, selector, _ := a.getPodSizingView(pod)
+		current, sequential := a.getContainerResources(&view.Spec)
+		demands := a.getMIGDemands(resources, sequential)
+		compatibility := a.getCompatibilityForPod(pod)
+		for i := range demands {
+			demands[i].Compatibility = compatibility
+		}
+		plan := a.planMIGsForPod(demands, selector, a.filterPlaceableNodes(a.filterNodesForPod(available, pod), pod, nodes, podItems), order)
+		if plan == nil {
+			continue
+		}
+
+		currentMIGs := make(map[string]migIdentifier)
+		for _, d := range a.getMIGDemands(current, sequential) {
+			currentMIGs[d.Container] = d.MIG
+		}
+
+		restart, direction := false, "down"
+		for _, d := range demands {
+			mig := plan.MIGs[d.Container]
+			held, ok := currentMIGs[d.Container]
+			if !ok {
+				restart = false
+				break
+			}
+			// a downsized container goes back up to its original mig, or what stands in for it
+			if held.Less(&d.MIG) {
+				if !held.Less(&mig) {
+					restart = false
+					break
+				}
+				restart, direction = true, "up"
+				continue
+			}
+			if held.Less(&mig) {
+				restart = false
+				break
+			}
+			if mig.Less(&held) {
 				restart = true
-				if updated {
-					a.storeResourceRulesForContainer(a.genPodKey(pod), c.Name, original.Requests, original.Limits, original.Claims)
+			}
+		}
+		if !restart {
+			continue
+		}
+		// only worth the restart if a pending pod waits on a mig the pod gives back
+		if mode == RESTORE_POLICY_WHEN_PENDING {
+			if pending == nil {
+				pending = a.getPendingMIGDemands(podItems)
+			}
+			wanted := false
+			for _, d := range demands {
+				mig, held := plan.MIGs[d.Container], currentMIGs[d.Container]
+				if mig.Less(&held) && pending[held] {
+					wanted = true
+					break
 				}
-				aclog.Info("controller restore", "original", records[c.Name], "updated", original)
+			}
+			if !wanted {
+				continue
 			}
 		}
 
+		a.takeMIGs(demands, plan, available)
+		if workload != "" {
+			inflight[workload]++
+		}
+		if a.IsDryRun() {
+			a.applyMIGPlan(resources, demands, plan)
+			a.recordDryRunDecision(pod, DRY_RUN_DECISION_RESTORE, EVENT_REASON_WOULD_RESTORE, "restart on node %s to size %s %s", plan.Node, direction, a.describeMIGChanges(current, resources, containerNames(demands)))
+			continue
+		}
+		for _, name := range a.applyMIGPlan(resources, demands, plan) {
+			updated := resources[name]
+			err := a.storeResourceRulesForContainer(ctx, a.genPodKey(pod), name, updated.Requests, updated.Limits, updated.Claims)
+			if err != nil {
+				aclog.Error(err, "store restore rules", "name", pod.Name, "namespace", pod.Namespace)
+				a.RecordEvent(pod, corev1.EventTypeWarning, EVENT_REASON_RESTORE_FAILED, "failed to store restore rules: %v", err)
+				a.removeResourceRulesForPod(ctx, a.genPodKey(pod))
+				// the pod is not restarted, so its migs and its slot in the workload are left to the others
+				a.releaseMIGs(demands, plan, available)
+				if workload != "" {
+					inflight[workload]--
+				}
+				restart = false
+				break
+			}
+		}
+		aclog.Info("controller restore", "original", records, "updated", resources)
+
 		if restart {
-			podsToRestart = append(podsToRestart, pod.DeepCopy())
+			a.reserveMIGs(pod, plan)
+			a.RecordEvent(pod, corev1.EventTypeNormal, EVENT_REASON_RESTORED, "restart on node %s to size %s %s", plan.Node, direction, a.describeMIGChanges(current, resources, containerNames(demands)))
+			restarted := pod.DeepCopy()
+			a.annotateRestore(restarted)
+			podsToRestart = append(podsToRestart, restarted)
 		}
 	}
 
@@ -98,6 +228,18 @@ func (a *Adapter) PodPendingForMIG(pod *corev1.Pod) corev1.ResourceName {
 				mig = corev1.ResourceName(msg[strings.Index(msg, RESOURCE_MIG_PREFIX):end])
 				break
 			}
+			// a pod selecting a mig by product waits on nvidia.com/gpu, or on no node having the product
+			if _, target := a.getSingleStrategyTarget(pod); target != nil &&
+				(strings.Contains(cond.Message, PODMESSAGE_INSUFFICIENT_PREFIX+RESOURCE_GPU) || strings.Contains(cond.Message, PODMESSAGE_NODE_SELECTOR_MISMATCH)) {
+				mig = corev1.ResourceName(target.String())
+				break
+			}
+			// a pod waiting on a whole GPU is only of interest once it can be given a mig
+			if fallback := a.GetPolicy().FullGPUFallback; fallback.isEnabled() && fallback.GPUToMIG &&
+				strings.Contains(cond.Message, PODMESSAGE_INSUFFICIENT_PREFIX+RESOURCE_GPU) {
+				mig = RESOURCE_GPU
+				break
+			}
 		}
 	}
 
@@ -106,24 +248,164 @@ func (a *Adapter) PodPendingForMIG(pod *corev1.Pod) corev1.ResourceName {
 
 func (a *Adapter) AdaptPodToGPUsWithContext(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) bool {
 
-	aclog.Info("pod pending mig", "name", pod.Name, "namespace", pod.Namespace)
+	return a.resizePodToGPUsWithContext(ctx, pod, nodes, pods, nil)
+}
+
+// DownsizePodToGPUsWithContext sizes down the migs of a pending pod opted in with a min profile,
+// to the largest ones left not smaller than its min profile
+func (a *Adapter) DownsizePodToGPUsWithContext(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) bool {
+
+	floor := a.getMinMIGForPod(pod)
+	if floor == nil {
+		return false
+	}
+
+	return a.resizePodToGPUsWithContext(ctx, pod, nodes, pods, floor)
+}
+
+// resizePodToGPUsWithContext sizes up the migs of the pod, or down to floor if given
+func (a *Adapter) resizePodToGPUsWithContext(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod, floor *migIdentifier) bool {
 
+	direction := "up"
+	if floor != nil {
+		direction = "down"
+	}
+
+	if !a.isUpsizeAllowedWithContext(ctx, pod) {
+		return false
+	}
+	if !a.CanRestartPodWithContext(ctx, pod) {
+		a.RecordEvent(pod, corev1.EventTypeWarning, EVENT_REASON_RESTART_NOT_ALLOWED, "pod cannot be restarted to size %s its migs", direction)
+		return false
+	}
+
+	aclog.Info("pod pending mig", "name", pod.Name, "namespace", pod.Namespace, "size", direction)
+
+	resize := a.planPodResize(pod, floor, nil, nodes, pods, a.detectAllAvailableMIGs(nodes, pods))
+	if resize == nil {
+		return false
+	}
+
+	return a.applyPodResizeWithContext(ctx, resize)
+}
+
+// podResize is how the migs of a pending pod are to be resized, nothing is changed until it is applied
+type podResize struct {
+	Pod *corev1.Pod
+	// sized down to no smaller than Min if set, rather than up
+	Min       *migIdentifier
+	Current   PodResources
+	Resources PodResources
+	// the containers resized, nil if the pod does not fit anywhere
+	Updated []string
+	Plan    *migPlan
+	// the mig the pod selects by product on single strategy nodes, if any
+	Target *migIdentifier
+}
+
+// getPodSizingView returns the pod as it is sized with the node selector to place it by, a pod selecting
+// a mig by product is sized like the others with the mig in place of nvidia.com/gpu, among the single
+// strategy nodes of the same GPU model
+func (a *Adapter) getPodSizingView(pod *corev1.Pod) (*corev1.Pod, map[string]string, *migIdentifier) {
+
+	_, target := a.getSingleStrategyTarget(pod)
+	if target != nil {
+		return a.getSingleStrategyView(pod, target), withoutProductSelector(pod.Spec.NodeSelector), target
+	}
+
+	return pod, pod.Spec.NodeSelector, nil
+}
+
+// planPodResize plans the migs of the pod sized up, or down to floor if given, on the nodes it can be placed on
+// and takes them from available. the nodes are those named by placeable if given, as found by getNodesForPod,
+// else they are found anew. nil is returned if there is no node to size it on
+func (a *Adapter) planPodResize(pod *corev1.Pod, floor *migIdentifier, placeable map[string]bool, nodes []corev1.Node, pods []corev1.Pod, available availableMIGMap) *podResize {
+
+	view, selector, target := a.getPodSizingView(pod)
+
+	// the filtered nodes share their migs with available, so what is taken is gone for the next pods
+	if placeable == nil {
+		placeable = a.getNodesForPod(pod, nodes, pods, available)
+	}
+	available = filterNodesByName(available, placeable)
+	if len(available) == 0 {
+		return nil
+	}
+	order := a.buildOrderedMIGList(available)
+	if len(order) == 0 {
+		return nil
+	}
+
+	// all containers are resized together or not at all
+	resize := &podResize{Pod: pod, Min: floor, Target: target}
+	resources, sequential := a.getContainerResources(&view.Spec)
+	resize.Current, _ = a.getContainerResources(&view.Spec)
+	resize.Resources = resources
+	if floor == nil {
+		resize.Updated, resize.Plan = a.checkAndSizeUpMIGsForPodResources(resources, sequential, a.getMIGBoundsForPod(view), a.getCompatibilityForPod(view), selector, available, order)
+	} else {
+		resize.Updated, resize.Plan = a.checkAndSizeDownMIGsForPodResources(resources, sequential, floor, a.getCompatibilityForPod(view), selector, available, order)
+	}
+
+	return resize
+}
+
+// applyPodResizeWithContext stores the rules of a planned resize and updates the pod in memory,
+// true is returned if the pod is to be restarted
+func (a *Adapter) applyPodResizeWithContext(ctx context.Context, resize *podResize) bool {
+
+	direction, reason, failed, decision, wouldReason := "up", EVENT_REASON_UPSIZED, EVENT_REASON_UPSIZE_FAILED, DRY_RUN_DECISION_UPSIZE, EVENT_REASON_WOULD_UPSIZE
+	if resize.Min != nil {
+		direction, reason, failed, decision, wouldReason = "down", EVENT_REASON_DOWNSIZED, EVENT_REASON_DOWNSIZE_FAILED, DRY_RUN_DECISION_DOWNSIZE, EVENT_REASON_WOULD_DOWNSIZE
+	}
+
+	pod, resources, updated := resize.Pod, resize.Resources, resize.Updated
 	restart := false
 	podkey := a.genPodKey(pod)
 
-	available, order := a.getAvailableMIGsAndOrder(nodes, pods)
-	if len(available) == 0 || len(order) == 0 {
+	if updated == nil {
+		if resize.Min == nil {
+			a.RecordEvent(pod, corev1.EventTypeWarning, failed, "no node has larger migs for all containers")
+		} else {
+			a.RecordEvent(pod, corev1.EventTypeWarning, failed, "no node has smaller migs down to %s for all containers", resize.Min.String())
+		}
+		return false
+	}
+	if len(updated) == 0 {
 		return false
 	}
+	if a.IsDryRun() {
+		a.recordDryRunDecision(pod, decision, wouldReason, "restart to size %s %s", direction, a.describeMIGChanges(resize.Current, resources, updated))
+		return true
+	}
+	for _, name := range updated {
+		res := resources[name]
+		err := a.storeResourceRulesForContainer(ctx, podkey, name, res.Requests, res.Limits, res.Claims)
+		if err != nil {
+			aclog.Error(err, "store rules", "name", pod.Name, "namespace", pod.Namespace)
+			a.RecordEvent(pod, corev1.EventTypeWarning, failed, "failed to store rules: %v", err)
+			a.removeResourceRulesForPod(ctx, podkey)
+			return false
+		}
+		restart = true
+	}
+	a.RecordEvent(pod, corev1.EventTypeNormal, reason, "restart to size %s %s", direction, a.describeMIGChanges(resize.Current, resources, updated))
+	a.reserveMIGs(pod, resize.Plan)
 
-	for _, c := range pod.Spec.Containers {
-		req := c.Resources.Requests
-		limits := c.Resources.Limits
-		claims := c.Resources.Claims
+	if resize.Target != nil {
+		rules := PodResources{}
+		for _, name := range updated {
+			rules[name] = resources[name]
+		}
+		a.applySingleStrategyRules(pod, rules)
+		return restart
+	}
 
-		if a.checkAndSizeUpMIGForContainerResource(req, limits, pod.Spec.NodeSelector, available, order) {
-			restart = true
-			a.storeResourceRulesForContainer(podkey, c.Name, req, limits, claims)
+	for _, c := range podContainers(&pod.Spec) {
+		for _, name := range updated {
+			if c.Name == name {
+				c.Resources = resources[name]
+			}
 		}
 	}
 
@@ -132,22 +414,50 @@ func (a *Adapter) AdaptPodToGPUsWithContext(ctx context.Context, pod *corev1.Pod
 
 func (a *Adapter) AdaptGPUsToPodWithContext(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) *corev1.Node {
 
+	if !a.GetPolicy().RepartitionEnabled || !a.IsPodTargetedWithContext(ctx, pod) {
+		return nil
+	}
+
+	// no mig config provides whole GPUs
 	mig := a.PodPendingForMIG(pod)
-	if mig == "" {
+	if mig == "" || mig == RESOURCE_GPU {
+		return nil
+	}
+	// single strategy nodes are only sized into, not repartitioned
+	if _, target := a.getSingleStrategyTarget(pod); target != nil {
 		return nil
 	}
 
-	profile := a.buildMIGProfileMap(nil)
+	cfg, err := a.loadMIGPartedConfigMap(ctx)
+	if err != nil {
+		aclog.Info("failed to load mig-parted config", "reason", err.Error())
+	}
+
+	configs := a.buildMIGProfileMap(cfg)[mig]
+	if len(configs) == 0 {
+		aclog.Info("no mig config provides the profile", "mig", mig)
+		a.RecordEvent(pod, corev1.EventTypeWarning, EVENT_REASON_REPARTITION_FAILED, "no mig config provides %s", mig)
+		return nil
+	}
 
-	available, _ := a.getAvailableMIGsAndOrder(nodes, pods)
+	available, _ := a.getAvailableMIGsAndOrder(nodes, pods, pod)
 
-	node := a.findAvailableNodeWithFreeGPU(pod.Spec.NodeSelector, nodes, available)
+	node, config := a.findNodeWithFreeGPUs(mig, configs, a.parseMIGPartedConfigs(cfg), pod.Spec.NodeSelector, nodes, available)
+	if node != nil && a.IsDryRun() {
+		a.recordDryRunDecision(pod, DRY_RUN_DECISION_REPARTITION, EVENT_REASON_WOULD_REPARTITION, "repartition node %s with mig config %s to provide %s", node.Name, config, mig)
+		a.RecordEvent(node, corev1.EventTypeNormal, EVENT_REASON_WOULD_REPARTITION, "dry run, would repartition with mig config %s to provide %s for pod %s/%s", config, mig, pod.Namespace, pod.Name)
+		return nil
+	}
 	if node != nil {
 		if node.Labels == nil {
 			node.Labels = make(map[string]string)
 		}
-		node.Labels[LABELKEY_MIG_CONFIG] = profile[mig]
-
+		node.Labels[LABELKEY_MIG_CONFIG] = config
+		aclog.Info("repartition node", "node", node.Name, "config", config)
+		a.RecordEvent(pod, corev1.EventTypeNormal, EVENT_REASON_REPARTITIONED, "repartition node %s with mig config %s to provide %s", node.Name, config, mig)
+		a.RecordEvent(node, corev1.EventTypeNormal, EVENT_REASON_REPARTITIONED, "repartition with mig config %s to provide %s for pod %s/%s", config, mig, pod.Namespace, pod.Name)
+	} else {
+		a.RecordEvent(pod, corev1.EventTypeWarning, EVENT_REASON_REPARTITION_FAILED, "no free GPU to provide %s", mig)
 	}
 
 	return node
@@ -56,6 +56,66 @@ var _ = Describe("API for Controller", func() {
 			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(targetMIG))
 		})
 
+		It("should size up all containers of it together", func() {
+			nodes := []corev1.Node{_test_node1}
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = _test_namespace
+			pod.Name = "podmulti"
+			sidecar := pod.Spec.Containers[0].DeepCopy()
+			sidecar.Name = _test_container2_name
+			pod.Spec.Containers = append(pod.Spec.Containers, *sidecar)
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}))
+			Expect(pod.Spec.Containers[1].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{
+				_test_mig_Identifier_string_3_20: _test_quantity_1,
+			}))
+
+			rules := adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))
+			Expect(rules).To(HaveKey(_test_container1_name))
+			Expect(rules).To(HaveKey(_test_container2_name))
+			adapter.removeResourceRulesForPod(ctx, adapter.genPodKey(pod))
+		})
+
+		It("should not size up any container if not all of them fit", func() {
+			nodes := []corev1.Node{_test_node1}
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = _test_namespace
+			pod.Name = "podtoomany"
+			for _, name := range []string{_test_container2_name, "container3"} {
+				sidecar := pod.Spec.Containers[0].DeepCopy()
+				sidecar.Name = name
+				pod.Spec.Containers = append(pod.Spec.Containers, *sidecar)
+			}
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+			for i := range pod.Spec.Containers {
+				Expect(pod.Spec.Containers[i].Resources).To(BeEquivalentTo(_test_podpending.Spec.Containers[0].Resources))
+			}
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))).To(BeNil())
+		})
+
+		It("should size up init containers along with the others", func() {
+			nodes := []corev1.Node{_test_node1}
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = _test_namespace
+			pod.Name = "podinit"
+			pod.Spec.InitContainers = []corev1.Container{
+				*pod.Spec.Containers[0].DeepCopy(),
+			}
+			pod.Spec.InitContainers[0].Name = _test_container2_name
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeTrue())
+			target := corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(BeEquivalentTo(target))
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(target))
+			adapter.removeResourceRulesForPod(ctx, adapter.genPodKey(pod))
+		})
+
 		It("should be able to restore pod when original MIG request is available", func() {
 			pod := _test_pod2.DeepCopy()
 			original := make(PodResources)
@@ -82,6 +142,67 @@ var _ = Describe("API for Controller", func() {
 			Expect(restored[0].Name).To(Equal(pod.Name))
 		})
 
+		It("should only size it down if it opted in", func() {
+			nodes := []corev1.Node{_test_node2}
+			pod := _test_podpending.DeepCopy()
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_4_20: _test_quantity_1,
+			}
+			pod.Spec.Containers[0].Resources.Limits = pod.Spec.Containers[0].Resources.Requests.DeepCopy()
+			Expect(adapter.DownsizePodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+
+			// the largest mig left comes first
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_MIN_PROFILE: "1g.5gb",
+			}
+			Expect(adapter.DownsizePodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{
+				_test_mig_Identifier_string_3_20: _test_quantity_1,
+			}))
+			adapter.removeResourceRulesForPod(ctx, adapter.genPodKey(pod))
+		})
+
+		It("should not size it down below its min profile", func() {
+			nodes := []corev1.Node{_test_node2}
+			pod := _test_podpending.DeepCopy()
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_MIN_PROFILE: "nvidia.com/mig-4g.20gb",
+			}
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				"nvidia.com/mig-7g.40gb": _test_quantity_1,
+			}
+			Expect(adapter.DownsizePodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(HaveKey(corev1.ResourceName("nvidia.com/mig-7g.40gb")))
+		})
+
+		It("should be able to restore a downsized pod when its original MIG request is available", func() {
+			pod := _test_pod2.DeepCopy()
+			original := PodResources{
+				pod.Spec.Containers[0].Name: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{
+						_test_mig_Identifier_string_3_20: _test_quantity_1,
+					},
+					Limits: corev1.ResourceList{
+						_test_mig_Identifier_string_3_20: _test_quantity_1,
+					},
+				},
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes),
+			}
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			pod.Spec.Containers[0].Resources.Limits = pod.Spec.Containers[0].Resources.Requests.DeepCopy()
+
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node1}, []corev1.Pod{*pod})
+			Expect(restored).To(HaveLen(1))
+			// recreated with its original mig, no rules needed
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))).To(BeNil())
+		})
+
 		It("should be able to identify free node with GPU when applicable", func() {
 			node1 := _test_node1.DeepCopy()
 			node2 := _test_node2.DeepCopy()
@@ -0,0 +1,295 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	DEFAULT_RESTORE_MIN_RUNTIME = 5 * time.Minute
+	DEFAULT_RESTORE_COOLDOWN    = 10 * time.Minute
+	DEFAULT_MAX_RESTORE_CYCLES  = 3
+
+	// the restores of a workload are kept on its controller, or the deployment of its replica set, or on the bare pod
+	// which is recreated with them
+	ADAPTER_ANNOTATION_RESTORE_CYCLES = "restore-cycles"
+	ADAPTER_ANNOTATION_LAST_RESTORE   = "last-restore"
+	ADAPTER_ANNOTATION_PINNED         = "pinned"
+
+	// bounds the reads and patches of the controller of a pod
+	WORKLOAD_REQUEST_TIMEOUT = 10 * time.Second
+)
+
+// workloadKinds are the controllers the restores are kept on, the adapter is allowed to read and patch them.
+// pods of other controllers only cool down in memory
+var workloadKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: OWNER_KIND_REPLICASET}:  true,
+	{Group: "apps", Kind: OWNER_KIND_STATEFULSET}: true,
+	{Group: "batch", Kind: OWNER_KIND_JOB}:        true,
+}
+
+// workloadParentKinds are the controllers of workloads the restores are kept on instead, as they replace them
+var workloadParentKinds = map[schema.GroupKind]bool{
+	{Group: "apps", Kind: OWNER_KIND_DEPLOYMENT}: true,
+}
+
+// restoreHistory is how often the pods of a workload went back to their original migs
+type restoreHistory struct {
+	Cycles      int
+	LastRestore time.Time
+	// the workload keeps its adapted migs for good
+	Pinned bool
+}
+
+func parseRestoreHistory(annotations map[string]string) restoreHistory {
+
+	h := restoreHistory{}
+	if cycles, err := strconv.Atoi(annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_CYCLES]); err == nil {
+		h.Cycles = cycles
+	}
+	if last, err := time.Parse(time.RFC3339, annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_LAST_RESTORE]); err == nil {
+		h.LastRestore = last
+	}
+	h.Pinned = annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_PINNED] == "true"
+
+	return h
+}
+
+func (h restoreHistory) annotate(annotations map[string]string) {
+	annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_CYCLES] = strconv.Itoa(h.Cycles)
+	annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_LAST_RESTORE] = h.LastRestore.UTC().Format(time.RFC3339)
+	if h.Pinned {
+		annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_PINNED] = "true"
+	}
+}
+
+// nextRestoreHistory counts one more restore, the workload is pinned once it had as many as allowed
+func (a *Adapter) nextRestoreHistory(h restoreHistory) restoreHistory {
+
+	h.Cycles++
+	h.LastRestore = time.Now()
+	if limit := a.GetPolicy().MaxRestoreCycles; limit > 0 && h.Cycles >= limit {
+		h.Pinned = true
+	}
+
+	return h
+}
+
+// getWorkloadObjectWithContext returns the metadata of the controller of the pod, or of the deployment of its
+// replica set which a rollout replaces, nil for bare pods, controllers of other kinds than workloadKinds or if it
+// cannot be read. it is read past the cache, which would start an informer for its kind and wait for it to sync
+func (a *Adapter) getWorkloadObjectWithContext(ctx context.Context, pod *corev1.Pod) *metav1.PartialObjectMetadata {
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || a.Client == nil {
+		return nil
+	}
+	gvk := schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind)
+	if !workloadKinds[gvk.GroupKind()] {
+		return nil
+	}
+
+	obj := a.getObjectMetadataWithContext(ctx, gvk, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name})
+	if obj == nil {
+		return nil
+	}
+
+	parent := metav1.GetControllerOf(obj)
+	if parent == nil {
+		return obj
+	}
+	pgvk := schema.FromAPIVersionAndKind(parent.APIVersion, parent.Kind)
+	if !workloadParentKinds[pgvk.GroupKind()] {
+		return obj
+	}
+
+	return a.getObjectMetadataWithContext(ctx, pgvk, types.NamespacedName{Namespace: pod.Namespace, Name: parent.Name})
+}
+
+// getObjectMetadataWithContext reads the metadata of the object past the cache, nil if it cannot be read
+func (a *Adapter) getObjectMetadataWithContext(ctx context.Context, gvk schema.GroupVersionKind, key types.NamespacedName) *metav1.PartialObjectMetadata {
+
+	reader := a.APIReader
+	if reader == nil {
+		reader = a.Client
+	}
+	ctx, cancel := context.WithTimeout(ctx, WORKLOAD_REQUEST_TIMEOUT)
+	defer cancel()
+
+	obj := &metav1.PartialObjectMetadata{}
+	obj.SetGroupVersionKind(gvk)
+	if err := reader.Get(ctx, key, obj); err != nil {
+		aclog.Error(err, "get workload", "name", key.Name, "namespace", key.Namespace, "kind", gvk.Kind)
+		return nil
+	}
+	// some readers drop the kind, which the patch needs
+	obj.SetGroupVersionKind(gvk)
+
+	return obj
+}
+
+// getRestoreHistoryWithContext reads the restores of the workload of the pod, or of the pod if bare.
+// the histories of the workloads already read are kept by workload key, so the replicas share one read
+func (a *Adapter) getRestoreHistoryWithContext(ctx context.Context, pod *corev1.Pod, histories map[string]restoreHistory) restoreHistory {
+
+	workload := getWorkloadKey(pod)
+	if workload == "" {
+		return parseRestoreHistory(pod.Annotations)
+	}
+	if h, read := histories[workload]; read {
+		return h
+	}
+
+	h := restoreHistory{}
+	if obj := a.getWorkloadObjectWithContext(ctx, pod); obj != nil {
+		h = parseRestoreHistory(obj.GetAnnotations())
+	}
+	histories[workload] = h
+
+	return h
+}
+
+// getRestoreDueWithContext returns when an adapted pod has run long enough to be restored, and neither it nor its
+// workload was restored too recently, false if it was restored too often to be ever again. the workload is only
+// read once the pod itself is due
+func (a *Adapter) getRestoreDueWithContext(ctx context.Context, pod *corev1.Pod, histories map[string]restoreHistory) (time.Time, bool) {
+
+	policy := a.GetPolicy()
+	due := time.Time{}
+	if pod.Status.StartTime != nil {
+		due = pod.Status.StartTime.Add(policy.RestoreMinRuntime)
+	}
+
+	a.bm.Lock()
+	last, exists := a.restores[a.genPodKey(pod)]
+	a.bm.Unlock()
+	if exists && last.Add(policy.RestoreCooldown).After(due) {
+		due = last.Add(policy.RestoreCooldown)
+	}
+	if due.After(time.Now()) {
+		return due, true
+	}
+
+	h := a.getRestoreHistoryWithContext(ctx, pod, histories)
+	if h.Pinned || policy.MaxRestoreCycles > 0 && h.Cycles >= policy.MaxRestoreCycles {
+		return time.Time{}, false
+	}
+	if !h.LastRestore.IsZero() && h.LastRestore.Add(policy.RestoreCooldown).After(due) {
+		due = h.LastRestore.Add(policy.RestoreCooldown)
+	}
+
+	return due, true
+}
+
+// setNextRestoreCheck keeps the earliest time a pod held back by the restore check becomes due, none if zero
+func (a *Adapter) setNextRestoreCheck(next time.Time) {
+	a.bm.Lock()
+	defer a.bm.Unlock()
+
+	a.nextRestore = next
+}
+
+// NextRestoreCheck schedules the check of the pods held back by the last restore check and returns how long until
+// the first of them becomes due, 0 if none is or a check is already scheduled by then
+func (a *Adapter) NextRestoreCheck() time.Duration {
+	a.bm.Lock()
+	defer a.bm.Unlock()
+
+	now := time.Now()
+	if a.nextRestore.IsZero() || a.scheduledRestore.After(now) && !a.scheduledRestore.After(a.nextRestore) {
+		return 0
+	}
+
+	// creation and start times are in seconds
+	delay := a.nextRestore.Sub(now)
+	if delay < time.Second {
+		delay = time.Second
+	}
+	a.scheduledRestore = now.Add(delay)
+
+	return delay
+}
+
+func earlier(t time.Time, u time.Time) time.Time {
+	if t.IsZero() || !u.IsZero() && u.Before(t) {
+		return u
+	}
+
+	return t
+}
+
+// annotateRestore counts the restore on a bare pod about to be recreated, its workload is counted once it is restarted
+func (a *Adapter) annotateRestore(pod *corev1.Pod) {
+
+	if metav1.GetControllerOf(pod) != nil {
+		return
+	}
+
+	h := a.nextRestoreHistory(parseRestoreHistory(pod.Annotations))
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	h.annotate(pod.Annotations)
+	if h.Pinned {
+		a.RecordEvent(pod, corev1.EventTypeNormal, EVENT_REASON_PINNED, "pinned to its adapted migs after %d restores", h.Cycles)
+	}
+}
+
+// RecordRestoreWithContext counts the restore of a pod restarted to its original migs on its workload,
+// the pod and its workload cool down before they are restored again
+func (a *Adapter) RecordRestoreWithContext(ctx context.Context, pod *corev1.Pod) {
+
+	a.bm.Lock()
+	if a.restores == nil {
+		a.restores = make(map[types.NamespacedName]time.Time)
+	}
+	a.restores[a.genPodKey(pod)] = time.Now()
+	a.bm.Unlock()
+
+	obj := a.getWorkloadObjectWithContext(ctx, pod)
+	if obj == nil {
+		return
+	}
+
+	patch := client.MergeFrom(obj.DeepCopy())
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	h := a.nextRestoreHistory(parseRestoreHistory(annotations))
+	h.annotate(annotations)
+	obj.SetAnnotations(annotations)
+
+	ctx, cancel := context.WithTimeout(ctx, WORKLOAD_REQUEST_TIMEOUT)
+	defer cancel()
+	if err := a.Patch(ctx, obj, patch); err != nil {
+		aclog.Error(err, "count restore on workload", "name", obj.GetName(), "namespace", obj.GetNamespace(), "kind", obj.Kind)
+		return
+	}
+	if h.Pinned {
+		a.RecordEvent(pod, corev1.EventTypeNormal, EVENT_REASON_PINNED, "%s %s pinned to its adapted migs after %d restores", obj.Kind, obj.GetName(), h.Cycles)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// countingReader counts the reads past the cache
+type countingReader struct {
+	client.Reader
+	reads int
+}
+
+func (r *countingReader) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	r.reads++
+	return r.Reader.Get(ctx, key, obj, opts...)
+}
+
+var _ = Describe("Restore cooldown for Adapter", func() {
+
+	var adapter *Adapter
+	var pod *corev1.Pod
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			Client: cli,
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+
+		original := PodResources{
+			_test_container1_name: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+				Limits:   corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+			},
+		}
+		bytes, err := json.Marshal(original)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod = _test_pod1.DeepCopy()
+		pod.Name = "cooldown"
+		pod.UID = "cooldown"
+		pod.Spec.NodeName = _test_node2_name
+		pod.Annotations = map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes)}
+		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+			Limits:   corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+		}
+	})
+
+	Context("For a bare adapted pod", func() {
+		It("should not be restored before it ran long enough", func() {
+			started := metav1.NewTime(time.Now())
+			pod.Status.StartTime = &started
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+			Expect(adapter.NextRestoreCheck()).To(BeNumerically("~", DEFAULT_RESTORE_MIN_RUNTIME, time.Second))
+
+			started = metav1.NewTime(time.Now().Add(-2 * DEFAULT_RESTORE_MIN_RUNTIME))
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(HaveLen(1))
+		})
+
+		It("should be recreated with its restore counted", func() {
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})
+			Expect(restored).To(HaveLen(1))
+			Expect(restored[0].Annotations).To(HaveKeyWithValue(ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_CYCLES, "1"))
+			Expect(restored[0].Annotations).To(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_LAST_RESTORE))
+			Expect(restored[0].Annotations).NotTo(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_PINNED))
+			Expect(pod.Annotations).NotTo(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_RESTORE_CYCLES))
+		})
+
+		It("should cool down after it was restored", func() {
+			restoreHistory{Cycles: 1, LastRestore: time.Now()}.annotate(pod.Annotations)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+
+			restoreHistory{Cycles: 1, LastRestore: time.Now().Add(-2 * DEFAULT_RESTORE_COOLDOWN)}.annotate(pod.Annotations)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(HaveLen(1))
+
+			adapter.RecordRestoreWithContext(ctx, pod)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+			Expect(adapter.NextRestoreCheck()).To(BeNumerically("~", DEFAULT_RESTORE_COOLDOWN, time.Second))
+		})
+
+		It("should be checked again once due, with one check scheduled at a time", func() {
+			restoreHistory{Cycles: 1, LastRestore: time.Now().Add(-DEFAULT_RESTORE_COOLDOWN + time.Minute)}.annotate(pod.Annotations)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+			Expect(adapter.NextRestoreCheck()).To(BeNumerically("~", time.Minute, time.Second))
+			Expect(adapter.NextRestoreCheck()).To(BeZero())
+
+			// sooner than the check scheduled
+			later := pod.DeepCopy()
+			later.Name = "cooldown-later"
+			started := metav1.NewTime(time.Now().Add(-DEFAULT_RESTORE_MIN_RUNTIME + 10*time.Second))
+			later.Status.StartTime = &started
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod, *later})).To(BeEmpty())
+			Expect(adapter.NextRestoreCheck()).To(BeNumerically("~", 10*time.Second, time.Second))
+
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, nil)).To(BeEmpty())
+			Expect(adapter.NextRestoreCheck()).To(BeZero())
+		})
+
+		It("should keep its adapted migs once restored too often", func() {
+			last := time.Now().Add(-2 * DEFAULT_RESTORE_COOLDOWN)
+			restoreHistory{Cycles: DEFAULT_MAX_RESTORE_CYCLES - 1, LastRestore: last}.annotate(pod.Annotations)
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})
+			Expect(restored).To(HaveLen(1))
+			Expect(restored[0].Annotations).To(HaveKeyWithValue(ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_PINNED, "true"))
+
+			restoreHistory{Cycles: DEFAULT_MAX_RESTORE_CYCLES, LastRestore: last}.annotate(pod.Annotations)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+
+			policy := DefaultPolicy()
+			policy.MaxRestoreCycles = 0
+			adapter.SetPolicy(policy)
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(HaveLen(1))
+		})
+	})
+
+	Context("For the replicas of a workload", func() {
+		var workload *appsv1.ReplicaSet
+
+		BeforeEach(func() {
+			workload = &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "cooldown", Namespace: _test_namespace},
+				Spec: appsv1.ReplicaSetSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "cooldown"}},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "cooldown"}},
+						Spec:       *pod.Spec.DeepCopy(),
+					},
+				},
+			}
+			Expect(cli.Create(ctx, workload)).To(Succeed())
+
+			controller := true
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: workload.Name, UID: workload.UID, Controller: &controller}}
+		})
+
+		AfterEach(func() {
+			Expect(cli.Delete(ctx, workload)).To(Succeed())
+		})
+
+		It("should count the restores on the workload and pin it after too many", func() {
+			for i := 1; i <= DEFAULT_MAX_RESTORE_CYCLES; i++ {
+				adapter.RecordRestoreWithContext(ctx, pod)
+			}
+
+			Expect(cli.Get(ctx, types.NamespacedName{Namespace: workload.Namespace, Name: workload.Name}, workload)).To(Succeed())
+			Expect(workload.Annotations).To(HaveKeyWithValue(ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_CYCLES, "3"))
+			Expect(workload.Annotations).To(HaveKeyWithValue(ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_PINNED, "true"))
+
+			replica := pod.DeepCopy()
+			replica.Name = "cooldown-other"
+			replica.UID = "cooldown-other"
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*replica})).To(BeEmpty())
+		})
+
+		It("should read the workload past the cache", func() {
+			reader := &countingReader{Reader: cli}
+			adapter.APIReader = reader
+			Expect(adapter.getWorkloadObjectWithContext(ctx, pod)).NotTo(BeNil())
+			Expect(reader.reads).To(Equal(1))
+		})
+
+		It("should read the workload once for all its replicas and not for pods never adapted", func() {
+			reader := &countingReader{Reader: cli}
+			adapter.APIReader = reader
+			started := metav1.NewTime(time.Now().Add(-2 * DEFAULT_RESTORE_MIN_RUNTIME))
+			pod.Status.StartTime = &started
+			replica := pod.DeepCopy()
+			replica.Name = "cooldown-other"
+			replica.UID = "cooldown-other"
+			untouched := pod.DeepCopy()
+			untouched.Name = "cooldown-untouched"
+			untouched.UID = "cooldown-untouched"
+			untouched.Annotations = nil
+
+			adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod, *replica, *untouched})
+			Expect(reader.reads).To(Equal(1))
+
+			reader.reads = 0
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*untouched})).To(BeEmpty())
+			Expect(reader.reads).To(BeZero())
+		})
+
+		It("should not read controllers of other kinds", func() {
+			reader := &countingReader{Reader: cli}
+			adapter.APIReader = reader
+			pod.OwnerReferences[0].APIVersion = "ray.io/v1"
+			pod.OwnerReferences[0].Kind = "RayCluster"
+			Expect(adapter.getWorkloadObjectWithContext(ctx, pod)).To(BeNil())
+			Expect(reader.reads).To(BeZero())
+
+			adapter.RecordRestoreWithContext(ctx, pod)
+			Expect(reader.reads).To(BeZero())
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+		})
+
+		It("should count the restores on the deployment of the workload, which outlives its rollouts", func() {
+			deployment := &appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{Name: "cooldown", Namespace: _test_namespace},
+				Spec: appsv1.DeploymentSpec{
+					Selector: workload.Spec.Selector,
+					Template: workload.Spec.Template,
+				},
+			}
+			Expect(cli.Create(ctx, deployment)).To(Succeed())
+			defer func() { Expect(cli.Delete(ctx, deployment)).To(Succeed()) }()
+
+			controller := true
+			workload.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: deployment.Name, UID: deployment.UID, Controller: &controller}}
+			Expect(cli.Update(ctx, workload)).To(Succeed())
+
+			for i := 1; i <= DEFAULT_MAX_RESTORE_CYCLES; i++ {
+				adapter.RecordRestoreWithContext(ctx, pod)
+			}
+
+			Expect(cli.Get(ctx, types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}, deployment)).To(Succeed())
+			Expect(deployment.Annotations).To(HaveKeyWithValue(ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_PINNED, "true"))
+			Expect(cli.Get(ctx, types.NamespacedName{Namespace: workload.Namespace, Name: workload.Name}, workload)).To(Succeed())
+			Expect(workload.Annotations).NotTo(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_RESTORE_CYCLES))
+
+			By("keeping it pinned for the replica set of a rollout")
+			rollout := workload.DeepCopy()
+			rollout.ObjectMeta = metav1.ObjectMeta{Name: "cooldown-rollout", Namespace: _test_namespace, OwnerReferences: workload.OwnerReferences}
+			Expect(cli.Create(ctx, rollout)).To(Succeed())
+			defer func() { Expect(cli.Delete(ctx, rollout)).To(Succeed()) }()
+
+			replica := pod.DeepCopy()
+			replica.Name = "cooldown-rollout"
+			replica.UID = "cooldown-rollout"
+			replica.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: rollout.Name, UID: rollout.UID, Controller: &controller}}
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*replica})).To(BeEmpty())
+		})
+
+		It("should cool down the workload after one of its replicas was restored", func() {
+			history := restoreHistory{Cycles: 1, LastRestore: time.Now()}
+			workload.Annotations = map[string]string{}
+			history.annotate(workload.Annotations)
+			Expect(cli.Update(ctx, workload)).To(Succeed())
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+
+			history.LastRestore = time.Now().Add(-2 * DEFAULT_RESTORE_COOLDOWN)
+			history.annotate(workload.Annotations)
+			Expect(cli.Update(ctx, workload)).To(Succeed())
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,80 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	DRY_RUN_DECISION_UPSIZE      = "upsize"
+	DRY_RUN_DECISION_DOWNSIZE    = "downsize"
+	DRY_RUN_DECISION_RESTORE     = "restore"
+	DRY_RUN_DECISION_REPARTITION = "repartition"
+	DRY_RUN_DECISION_EVICT       = "evict"
+
+	// how many of the latest decisions are kept for the status
+	DRY_RUN_DECISIONS_MAX = 10
+)
+
+func (a *Adapter) IsDryRun() bool {
+	return a.GetPolicy().DryRun
+}
+
+// recordDryRunDecision records what would have been done to the object, as an event, a metric and in the statistics
+func (a *Adapter) recordDryRunDecision(object runtime.Object, decision, reason, messageFmt string, args ...interface{}) {
+
+	message := fmt.Sprintf(messageFmt, args...)
+	a.RecordEvent(object, corev1.EventTypeNormal, reason, "dry run, would %s", message)
+	dryRunDecisionsTotal.WithLabelValues(decision).Inc()
+
+	name := ""
+	if accessor, err := meta.Accessor(object); err == nil {
+		name = accessor.GetName()
+		if accessor.GetNamespace() != "" {
+			name = accessor.GetNamespace() + "/" + name
+		}
+	}
+	aclog.Info("dry run", "decision", decision, "object", name, "message", message)
+
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	switch decision {
+	case DRY_RUN_DECISION_UPSIZE:
+		a.stats.WouldUpsize++
+	case DRY_RUN_DECISION_DOWNSIZE:
+		a.stats.WouldDownsize++
+	case DRY_RUN_DECISION_RESTORE:
+		a.stats.WouldRestore++
+	case DRY_RUN_DECISION_REPARTITION:
+		a.stats.WouldRepartition++
+	case DRY_RUN_DECISION_EVICT:
+		a.stats.WouldEvict++
+	}
+
+	// always a new slice so the statistics handed out are never changed
+	decisions := append([]string{decision + " " + name + ": " + message}, a.stats.LastDryRunDecisions...)
+	if len(decisions) > DRY_RUN_DECISIONS_MAX {
+		decisions = decisions[:DRY_RUN_DECISIONS_MAX]
+	}
+	a.stats.LastDryRunDecisions = decisions
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Dry Run", func() {
+
+	var recorder *record.FakeRecorder
+	var adapter *Adapter
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		policy := DefaultPolicy()
+		policy.DryRun = true
+		adapter = &Adapter{
+			Recorder: recorder,
+			rules:    NewMemoryRuleStore(),
+			policy:   policy,
+		}
+	})
+
+	Context("For a pending pod", func() {
+		It("should record the upsize without storing rules", func() {
+			pod := _test_podpending.DeepCopy()
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))).To(BeNil())
+
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal + " " + EVENT_REASON_WOULD_UPSIZE)))
+			stats := adapter.GetStatistics()
+			Expect(stats.WouldUpsize).To(Equal(int64(1)))
+			Expect(stats.AdaptedPods).To(BeZero())
+			Expect(stats.LastDryRunDecisions).To(HaveLen(1))
+			Expect(stats.LastDryRunDecisions[0]).To(ContainSubstring(_test_mig_Identifier_string_2_10))
+		})
+
+		It("should record the repartition without relabeling the node", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Status.Conditions[0].Message = _test_pod_status_condition_message_prefix + _test_mig_Identifier_string_4_20 + CONDITION_MESSAGE_SEPARATOR
+
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pod, []corev1.Node{_test_node2}, nil)).To(BeNil())
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal + " " + EVENT_REASON_WOULD_REPARTITION)))
+			Expect(adapter.GetStatistics().WouldRepartition).To(Equal(int64(1)))
+		})
+	})
+
+	Context("For an adapted pod", func() {
+		It("should record the restore without restarting it", func() {
+			pod := _test_pod2.DeepCopy()
+			original := PodResources{
+				_test_container1_name: *pod.Spec.Containers[0].Resources.DeepCopy(),
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes),
+			}
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, []corev1.Pod{*pod})).To(BeEmpty())
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal + " " + EVENT_REASON_WOULD_RESTORE)))
+			Expect(adapter.GetStatistics().WouldRestore).To(Equal(int64(1)))
+		})
+	})
+
+	Context("For a pod with rules", func() {
+		It("should not be patched", func() {
+			pod := _test_pod1.DeepCopy()
+			podkey := adapter.genPodKey(pod)
+			creq := corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			Expect(adapter.storeResourceRulesForContainer(ctx, podkey, _test_container1_name, creq, creq, nil)).To(Succeed())
+
+			adapter.CheckAndUpdatePodWithContext(ctx, pod)
+			Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_pod1.Spec.Containers[0].Resources))
+			Expect(pod.Annotations).To(BeNil())
+		})
+	})
+
+	Context("For many decisions", func() {
+		It("should keep only the latest ones", func() {
+			adapter.Recorder = nil
+			for i := 0; i < DRY_RUN_DECISIONS_MAX+5; i++ {
+				adapter.recordDryRunDecision(_test_pod1.DeepCopy(), DRY_RUN_DECISION_UPSIZE, EVENT_REASON_WOULD_UPSIZE, "decision %d", i)
+			}
+			stats := adapter.GetStatistics()
+			Expect(stats.LastDryRunDecisions).To(HaveLen(DRY_RUN_DECISIONS_MAX))
+			Expect(stats.LastDryRunDecisions[0]).To(HaveSuffix("decision 14"))
+		})
+	})
+})
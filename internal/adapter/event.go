@@ -0,0 +1,91 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	EVENT_SOURCE = "mig-adapter"
+
+	EVENT_REASON_UPSIZED             = "MIGUpsized"
+	EVENT_REASON_UPSIZE_FAILED       = "MIGUpsizeFailed"
+	EVENT_REASON_DOWNSIZED           = "MIGDownsized"
+	EVENT_REASON_DOWNSIZE_FAILED     = "MIGDownsizeFailed"
+	EVENT_REASON_RESTORED            = "MIGRestored"
+	EVENT_REASON_RESTORE_FAILED      = "MIGRestoreFailed"
+	EVENT_REASON_REPARTITIONED       = "MIGRepartitioned"
+	EVENT_REASON_REPARTITION_FAILED  = "MIGRepartitionFailed"
+	EVENT_REASON_RESTART_FAILED      = "MIGRestartFailed"
+	EVENT_REASON_RECREATE_FAILED     = "MIGRecreateFailed"
+	EVENT_REASON_RESTART_NOT_ALLOWED = "MIGRestartNotAllowed"
+	EVENT_REASON_RESTART_BLOCKED     = "MIGRestartBlocked"
+	EVENT_REASON_EVICTED             = "MIGEvicted"
+	EVENT_REASON_PINNED              = "MIGPinned"
+	EVENT_REASON_INVALID_POLICY      = "MIGInvalidPolicy"
+
+	// decisions only recorded in dry-run mode
+	EVENT_REASON_WOULD_UPSIZE      = "MIGWouldUpsize"
+	EVENT_REASON_WOULD_DOWNSIZE    = "MIGWouldDownsize"
+	EVENT_REASON_WOULD_RESTORE     = "MIGWouldRestore"
+	EVENT_REASON_WOULD_REPARTITION = "MIGWouldRepartition"
+	EVENT_REASON_WOULD_EVICT       = "MIGWouldEvict"
+)
+
+// RecordEvent emits an event on the object, a no-op without recorder. warnings are counted as failures
+func (a *Adapter) RecordEvent(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	if eventtype == corev1.EventTypeWarning {
+		a.RecordFailure(reason)
+	}
+
+	if a.Recorder == nil || object == nil {
+		return
+	}
+
+	a.Recorder.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// describeMIGChanges lists the mig of each container before and after, like container1: nvidia.com/mig-1g.5gb -> nvidia.com/mig-2g.10gb
+func (a *Adapter) describeMIGChanges(before, after PodResources, containers []string) string {
+
+	sorted := append([]string{}, containers...)
+	sort.Strings(sorted)
+
+	changes := []string{}
+	for _, name := range sorted {
+		from, _ := a.currentMIGResource(before[name].Requests)
+		if from == nil {
+			from, _ = a.currentMIGResource(before[name].Limits)
+		}
+		to, _ := a.currentMIGResource(after[name].Requests)
+		if to == nil {
+			to, _ = a.currentMIGResource(after[name].Limits)
+		}
+		if from == nil || to == nil {
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, from.String(), to.String()))
+	}
+
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Events", func() {
+
+	var recorder *record.FakeRecorder
+	var adapter *Adapter
+
+	BeforeEach(func() {
+		recorder = record.NewFakeRecorder(10)
+		adapter = &Adapter{
+			Recorder: recorder,
+			rules:    NewMemoryRuleStore(),
+			policy:   DefaultPolicy(),
+		}
+	})
+
+	Context("For a pod sized up", func() {
+		It("should tell the migs before and after", func() {
+			pod := _test_podpending.DeepCopy()
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+
+			Expect(recorder.Events).To(Receive(Equal(corev1.EventTypeNormal + " " + EVENT_REASON_UPSIZED + " restart to size up " +
+				_test_container1_name + ": " + _test_mig_Identifier_string_1_5 + " -> " + _test_mig_Identifier_string_2_10)))
+		})
+	})
+
+	Context("For a pod which cannot be sized up", func() {
+		It("should warn about it", func() {
+			pod := _test_podpending.DeepCopy()
+			for _, name := range []string{_test_container2_name, "container3"} {
+				sidecar := pod.Spec.Containers[0].DeepCopy()
+				sidecar.Name = name
+				pod.Spec.Containers = append(pod.Spec.Containers, *sidecar)
+			}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeFalse())
+
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeWarning + " " + EVENT_REASON_UPSIZE_FAILED)))
+		})
+	})
+
+	Context("For a node repartitioned", func() {
+		It("should tell both the pod and the node", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Status.Conditions[0].Message = _test_pod_status_condition_message_prefix + _test_mig_Identifier_string_4_20 + CONDITION_MESSAGE_SEPARATOR
+
+			node := adapter.AdaptGPUsToPodWithContext(ctx, pod, []corev1.Node{_test_node2}, nil)
+			Expect(node).NotTo(BeNil())
+
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal + " " + EVENT_REASON_REPARTITIONED + " repartition node " + _test_node2_name)))
+			Expect(recorder.Events).To(Receive(HavePrefix(corev1.EventTypeNormal + " " + EVENT_REASON_REPARTITIONED + " repartition with mig config all-4g.20gb")))
+		})
+	})
+
+	Context("Without recorder", func() {
+		It("should not emit anything", func() {
+			adapter.Recorder = nil
+			Expect(func() {
+				adapter.RecordEvent(_test_pod1.DeepCopy(), corev1.EventTypeNormal, EVENT_REASON_UPSIZED, "")
+			}).NotTo(Panic())
+		})
+	})
+})
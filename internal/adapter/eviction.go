@@ -0,0 +1,134 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// how long to wait before restarting again a pod whose eviction a PodDisruptionBudget blocked,
+	// doubled on every block up to the max
+	RESTART_BACKOFF_BASE = 10 * time.Second
+	RESTART_BACKOFF_MAX  = 5 * time.Minute
+
+	// pods of a workload restarted at once to be restored, so that its replicas are not all down together
+	MAX_RESTORES_PER_WORKLOAD = 1
+)
+
+// restartBackoff is how long a pod is left alone after its eviction was blocked
+type restartBackoff struct {
+	Blocks int
+	Until  time.Time
+}
+
+// CancelRestartWithContext undoes what was planned for a pod which could not be evicted: its rules,
+// the migs held for it and the bare pod kept to be recreated
+func (a *Adapter) CancelRestartWithContext(ctx context.Context, pod *corev1.Pod) {
+
+	podkey := a.genPodKey(pod)
+	a.removeResourceRulesForPod(ctx, podkey)
+
+	a.rm.Lock()
+	if r, exists := a.reservations[reservationKey(pod)]; exists && r.UID == pod.UID {
+		delete(a.reservations, reservationKey(pod))
+	}
+	a.rm.Unlock()
+
+	if a.isBarePod(pod) {
+		a.RemovePodForRecreationWithContext(ctx, podkey)
+	}
+}
+
+// BackOffRestartWithContext cancels the restart of a pod whose eviction a PodDisruptionBudget blocked and
+// returns how long the pod is left alone before it is tried again
+func (a *Adapter) BackOffRestartWithContext(ctx context.Context, pod *corev1.Pod) time.Duration {
+
+	a.CancelRestartWithContext(ctx, pod)
+
+	a.bm.Lock()
+	defer a.bm.Unlock()
+
+	if a.backoffs == nil {
+		a.backoffs = make(map[types.NamespacedName]restartBackoff)
+	}
+
+	podkey := a.genPodKey(pod)
+	b := a.backoffs[podkey]
+	delay := RESTART_BACKOFF_BASE
+	for i := 0; i < b.Blocks && delay < RESTART_BACKOFF_MAX; i++ {
+		delay *= 2
+	}
+	delay = min(delay, RESTART_BACKOFF_MAX)
+
+	b.Blocks++
+	b.Until = time.Now().Add(delay)
+	a.backoffs[podkey] = b
+
+	return delay
+}
+
+// ResetRestartBackoff forgets the blocked evictions of a pod once it is evicted
+func (a *Adapter) ResetRestartBackoff(pod *corev1.Pod) {
+
+	a.bm.Lock()
+	defer a.bm.Unlock()
+
+	delete(a.backoffs, a.genPodKey(pod))
+}
+
+// isRestartBackingOff tells if the pod is left alone after its eviction was blocked
+func (a *Adapter) isRestartBackingOff(pod *corev1.Pod) bool {
+
+	a.bm.Lock()
+	defer a.bm.Unlock()
+
+	b, exists := a.backoffs[a.genPodKey(pod)]
+	return exists && time.Now().Before(b.Until)
+}
+
+// getWorkloadKey names the controller of the pod, empty for bare pods which are workloads of their own
+func getWorkloadKey(pod *corev1.Pod) string {
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil {
+		return ""
+	}
+
+	return pod.Namespace + "/" + owner.Kind + "/" + owner.Name
+}
+
+// countRestartsInFlight counts the terminating pods of each workload
+func countRestartsInFlight(pods []corev1.Pod) map[string]int {
+
+	inflight := make(map[string]int)
+	for i := range pods {
+		if pods[i].DeletionTimestamp == nil {
+			continue
+		}
+		if workload := getWorkloadKey(&pods[i]); workload != "" {
+			inflight[workload]++
+		}
+	}
+
+	return inflight
+}
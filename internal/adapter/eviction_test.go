@@ -0,0 +1,164 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// failingRuleStore fails to store the rules of the first pod
+type failingRuleStore struct {
+	RuleStore
+	failed bool
+}
+
+func (s *failingRuleStore) StoreRules(ctx context.Context, podkey types.NamespacedName, rules PodResources) error {
+	if !s.failed {
+		s.failed = true
+		return errors.New("store failed")
+	}
+	return s.RuleStore.StoreRules(ctx, podkey, rules)
+}
+
+var _ = Describe("Evictions for Adapter", func() {
+
+	var adapter *Adapter
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For a pod whose eviction is blocked", func() {
+		It("should back off longer on every block up to the max", func() {
+			pod := _test_podpending.DeepCopy()
+			Expect(adapter.BackOffRestartWithContext(ctx, pod)).To(Equal(RESTART_BACKOFF_BASE))
+			Expect(adapter.BackOffRestartWithContext(ctx, pod)).To(Equal(2 * RESTART_BACKOFF_BASE))
+			Expect(adapter.BackOffRestartWithContext(ctx, pod)).To(Equal(4 * RESTART_BACKOFF_BASE))
+			for i := 0; i < 10; i++ {
+				adapter.BackOffRestartWithContext(ctx, pod)
+			}
+			Expect(adapter.BackOffRestartWithContext(ctx, pod)).To(Equal(RESTART_BACKOFF_MAX))
+			Expect(adapter.isRestartBackingOff(pod)).To(BeTrue())
+
+			adapter.ResetRestartBackoff(pod)
+			Expect(adapter.isRestartBackingOff(pod)).To(BeFalse())
+			Expect(adapter.BackOffRestartWithContext(ctx, pod)).To(Equal(RESTART_BACKOFF_BASE))
+		})
+
+		It("should undo what was planned for it and leave it alone for a while", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.UID = "blocked"
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))).NotTo(BeNil())
+			Expect(adapter.reservations).To(HaveLen(1))
+
+			original := _test_podpending.DeepCopy()
+			original.UID = pod.UID
+			adapter.BackOffRestartWithContext(ctx, pod)
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(pod))).To(BeNil())
+			Expect(adapter.reservations).To(BeEmpty())
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, original, []corev1.Node{_test_node1}, nil)).To(BeEmpty())
+
+			b := adapter.backoffs[adapter.genPodKey(pod)]
+			b.Until = time.Now().Add(-time.Second)
+			adapter.backoffs[adapter.genPodKey(pod)] = b
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, original, []corev1.Node{_test_node1}, nil)).To(HaveLen(1))
+		})
+	})
+
+	Context("For the replicas of a workload to restore", func() {
+		var replicas []corev1.Pod
+
+		BeforeEach(func() {
+			original := PodResources{
+				_test_container1_name: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+					Limits:   corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+				},
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+
+			controller := true
+			replicas = nil
+			for _, name := range []string{"replica-a", "replica-b"} {
+				pod := _test_pod1.DeepCopy()
+				pod.Name = name
+				pod.UID = types.UID(name)
+				pod.Spec.NodeName = _test_node2_name
+				pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "workload", UID: "workload", Controller: &controller}}
+				pod.Annotations = map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes)}
+				pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+					Limits:   corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+				}
+				replicas = append(replicas, *pod)
+			}
+		})
+
+		It("should restore them one at a time", func() {
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, replicas)).To(HaveLen(1))
+
+			now := metav1.Now()
+			replicas[0].DeletionTimestamp = &now
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, replicas)).To(BeEmpty())
+		})
+
+		It("should give another replica what was taken for one whose rules cannot be stored", func() {
+			// the replicas hold 3g.20gb, the 1g.5gb are taken by another pod and only one 2g.10gb is left
+			for i := range replicas {
+				replicas[i].Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{_test_mig_Identifier_string_3_20: _test_quantity_1},
+					Limits:   corev1.ResourceList{_test_mig_Identifier_string_3_20: _test_quantity_1},
+				}
+			}
+			other := _test_pod1.DeepCopy()
+			other.Name = "other"
+			other.UID = "other"
+			other.Spec.NodeName = _test_node2_name
+			other.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_2},
+				Limits:   corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_2},
+			}
+
+			store := &failingRuleStore{RuleStore: adapter.rules}
+			adapter.rules = store
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, append(replicas, *other))
+			Expect(store.failed).To(BeTrue())
+			Expect(restored).To(HaveLen(1))
+			rules := adapter.getResourceRulesForPod(ctx, adapter.genPodKey(restored[0]))
+			Expect(rules[_test_container1_name].Limits).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_2_10)))
+		})
+
+		It("should restore the replicas of other workloads", func() {
+			replicas[1].OwnerReferences[0].Name = "other"
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, replicas)).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	RESOURCE_GPU = "nvidia.com/gpu"
+)
+
+// FULL_GPU stands for a whole GPU among the migs, it is larger than any of them
+var FULL_GPU = migIdentifier{Full: true}
+
+// FullGPUFallback maps whole GPUs of non-mig nodes to the largest mig profiles, both ways are opt-in
+type FullGPUFallback struct {
+	// profiles like 7g.40gb a whole GPU is equivalent to
+	Profiles []string
+	// a pod waiting on one of the profiles can be given a whole GPU
+	MIGToGPU bool
+	// a pod waiting on a whole GPU can be given one of the profiles
+	GPUToMIG bool
+}
+
+func (f *FullGPUFallback) isEnabled() bool {
+	return f != nil && len(f.Profiles) > 0 && (f.MIGToGPU || f.GPUToMIG)
+}
+
+// isMIGResource tells if the resource is a mig, or a whole GPU once it is mapped to migs
+func (a *Adapter) isMIGResource(name corev1.ResourceName) bool {
+	if strings.Contains(name.String(), RESOURCE_MIG_PREFIX) {
+		return true
+	}
+
+	return name == RESOURCE_GPU && a.GetPolicy().FullGPUFallback.isEnabled()
+}
+
+// isFullGPUEquivalent tells if a whole GPU is equivalent to the mig
+func (a *Adapter) isFullGPUEquivalent(mig *migIdentifier) bool {
+	fallback := a.GetPolicy().FullGPUFallback
+	if !fallback.isEnabled() || mig.Full {
+		return false
+	}
+
+	return isProfileInList(mig, fallback.Profiles)
+}
+
+// getFullGPUCandidates returns the migs standing in for requested beyond the compatible ones:
+// a whole GPU after the largest migs equivalent to it, or these migs, the largest first, for a whole GPU
+func (a *Adapter) getFullGPUCandidates(requested *migIdentifier, order OrderedmigIdentifierList) []migIdentifier {
+
+	fallback := a.GetPolicy().FullGPUFallback
+	candidates := []migIdentifier{}
+
+	if !requested.Full {
+		if fallback.isEnabled() && fallback.MIGToGPU && a.isFullGPUEquivalent(requested) {
+			candidates = append(candidates, FULL_GPU)
+		}
+		return candidates
+	}
+
+	if !fallback.isEnabled() || !fallback.GPUToMIG {
+		return candidates
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		n := order[i]
+		if i < len(order)-1 && n.Equal(&order[i+1]) {
+			continue
+		}
+		if a.isFullGPUEquivalent(&n) {
+			candidates = append(candidates, n)
+		}
+	}
+
+	return candidates
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Full GPU fallback for Adapter", func() {
+
+	const mig_7_40 = RESOURCE_MIG_PREFIX + "7g.40gb"
+
+	var adapter *Adapter
+
+	gpuNode := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "gpu-node",
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				RESOURCE_GPU: _test_quantity_1,
+			},
+		},
+	}
+	migNode := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "mig-node",
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				mig_7_40: _test_quantity_1,
+			},
+		},
+	}
+
+	pendingOn := func(name corev1.ResourceName) *corev1.Pod {
+		pod := _test_podpending.DeepCopy()
+		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{name: _test_quantity_1},
+			Limits:   corev1.ResourceList{name: _test_quantity_1},
+		}
+		pod.Status.Conditions[0].Message = _test_pod_status_condition_message_prefix + name.String() + CONDITION_MESSAGE_SEPARATOR
+		return pod
+	}
+
+	BeforeEach(func() {
+		policy := DefaultPolicy()
+		policy.FullGPUFallback = &FullGPUFallback{
+			Profiles: []string{"7g.40gb"},
+			MIGToGPU: true,
+			GPUToMIG: true,
+		}
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: policy,
+		}
+	})
+
+	Context("For a whole GPU", func() {
+		It("should be larger than any mig", func() {
+			md := &migIdentifier{}
+			Expect(md.Parse(RESOURCE_GPU)).To(Succeed())
+			Expect(md.Equal(&FULL_GPU)).To(BeTrue())
+			Expect(md.String()).To(Equal(RESOURCE_GPU))
+
+			mig := &migIdentifier{}
+			Expect(mig.Parse(mig_7_40)).To(Succeed())
+			Expect(mig.Less(md)).To(BeTrue())
+			Expect(md.Less(mig)).To(BeFalse())
+		})
+
+		It("should be invisible without the fallback", func() {
+			adapter.SetPolicy(DefaultPolicy())
+			Expect(adapter.getAllocableMIGsOnNode(&gpuNode).MIGs).To(BeEmpty())
+		})
+	})
+
+	Context("For a pod waiting on the largest mig", func() {
+		It("should be given a whole GPU", func() {
+			pod := pendingOn(mig_7_40)
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{gpuNode}, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{RESOURCE_GPU: _test_quantity_1}))
+			Expect(pod.Spec.Containers[0].Resources.Limits).To(BeEquivalentTo(corev1.ResourceList{RESOURCE_GPU: _test_quantity_1}))
+		})
+
+		It("should not be given a whole GPU unless opted in", func() {
+			policy := adapter.GetPolicy()
+			policy.FullGPUFallback.MIGToGPU = false
+			adapter.SetPolicy(policy)
+
+			pod := pendingOn(mig_7_40)
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{gpuNode}, nil)).To(BeFalse())
+		})
+
+		It("should be restored once its mig is available", func() {
+			pod := _test_pod2.DeepCopy()
+			original := PodResources{
+				_test_container1_name: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{mig_7_40: _test_quantity_1},
+					Limits:   corev1.ResourceList{mig_7_40: _test_quantity_1},
+				},
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes),
+			}
+			pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{RESOURCE_GPU: _test_quantity_1},
+				Limits:   corev1.ResourceList{RESOURCE_GPU: _test_quantity_1},
+			}
+
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{migNode}, []corev1.Pod{*pod})
+			Expect(restored).To(HaveLen(1))
+		})
+	})
+
+	Context("For a pod waiting on a whole GPU", func() {
+		It("should be given the largest mig", func() {
+			pod := pendingOn(RESOURCE_GPU)
+			Expect(adapter.IsPodPendingForMIGs(pod)).To(BeTrue())
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{migNode}, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(corev1.ResourceList{mig_7_40: _test_quantity_1}))
+
+			// no mig config provides a whole GPU
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pendingOn(RESOURCE_GPU), []corev1.Node{migNode}, nil)).To(BeNil())
+		})
+
+		It("should be left alone unless opted in", func() {
+			policy := adapter.GetPolicy()
+			policy.FullGPUFallback.GPUToMIG = false
+			adapter.SetPolicy(policy)
+
+			Expect(adapter.IsPodPendingForMIGs(pendingOn(RESOURCE_GPU))).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,266 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"reflect"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// set by GPU Feature Discovery
+	LABELKEY_GPU_COUNT = "nvidia.com/gpu.count"
+	// set by the mig manager while it applies nvidia.com/mig.config
+	LABELKEY_MIG_CONFIG_STATE = "nvidia.com/mig.config.state"
+
+	MIG_CONFIG_STATE_PENDING   = "pending"
+	MIG_CONFIG_STATE_REBOOTING = "rebooting"
+	MIG_CONFIG_STATE_FAILED    = "failed"
+
+	MIG_PARTED_DEVICES_ALL = "all"
+)
+
+// gpuLayout is what one GPU is partitioned into, empty when mig is disabled on it
+type gpuLayout map[corev1.ResourceName]int
+
+func (a *Adapter) getGPUCount(node *corev1.Node) int {
+	count, err := strconv.Atoi(node.Labels[LABELKEY_GPU_COUNT])
+	if err != nil || count < 1 {
+		return 1
+	}
+
+	return count
+}
+
+// isDeviceSelected tells if the devices of a mig-parted device config, "all" or a list of indexes, include the GPU
+func (a *Adapter) isDeviceSelected(devices interface{}, index int) bool {
+	switch d := devices.(type) {
+	case nil:
+		return true
+	case string:
+		return d == MIG_PARTED_DEVICES_ALL
+	case []interface{}:
+		for _, v := range d {
+			switch i := v.(type) {
+			case float64:
+				if int(i) == index {
+					return true
+				}
+			case int:
+				if i == index {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// getGPULayouts applies the device configs of a mig config to each GPU of a node.
+// device filters are not matched against the GPU model, the configs for all models are merged
+func (a *Adapter) getGPULayouts(devices []migPartedDeviceConfig, count int) []gpuLayout {
+
+	layouts := make([]gpuLayout, count)
+	for i := range layouts {
+		layouts[i] = gpuLayout{}
+		for _, device := range devices {
+			if !device.MIGEnabled || !a.isDeviceSelected(device.Devices, i) {
+				continue
+			}
+			for profile, n := range device.MIGDevices {
+				md := &migIdentifier{}
+				if err := md.Parse(RESOURCE_MIG_PREFIX + profile); err != nil {
+					continue
+				}
+				mig := corev1.ResourceName(md.String())
+				if n > layouts[i][mig] {
+					layouts[i][mig] = n
+				}
+			}
+		}
+	}
+
+	return layouts
+}
+
+// gpuGroup is the GPUs of a node sharing a layout, with how many of them pods may run on
+type gpuGroup struct {
+	Layout gpuLayout
+	GPUs   []int
+	Busy   int
+}
+
+// groupBusyGPUs groups the GPUs of a node by layout and bounds how many GPUs of each group are in use.
+// pods only ask for a profile and not for a GPU, so each mig in use may be on another GPU with the profile,
+// i.e. one 1g.5gb in use on 8 GPUs of 7 1g.5gb each is on one of them, but which one is unknown
+func (a *Adapter) groupBusyGPUs(node *corev1.Node, layouts []gpuLayout, migsOnNode availableMIGsOnNode) []gpuGroup {
+
+	used := make(map[corev1.ResourceName]int64)
+	for mig, q := range a.getAllocableMIGsOnNode(node).MIGs {
+		left := migsOnNode.MIGs[mig]
+		if n := q.Value() - left.Value(); n > 0 {
+			used[corev1.ResourceName(mig.String())] = n
+		}
+	}
+
+	groups := []gpuGroup{}
+	for i, layout := range layouts {
+		found := false
+		for j := range groups {
+			if reflect.DeepEqual(groups[j].Layout, layout) {
+				groups[j].GPUs = append(groups[j].GPUs, i)
+				found = true
+				break
+			}
+		}
+		if !found {
+			groups = append(groups, gpuGroup{Layout: layout, GPUs: []int{i}})
+		}
+	}
+
+	for j := range groups {
+		group := &groups[j]
+		// usage of GPUs without mig is unknown
+		if len(group.Layout) == 0 {
+			group.Busy = len(group.GPUs)
+			continue
+		}
+		busy := int64(0)
+		for mig := range group.Layout {
+			busy += used[mig]
+		}
+		group.Busy = len(group.GPUs)
+		if busy < int64(group.Busy) {
+			group.Busy = int(busy)
+		}
+	}
+
+	return groups
+}
+
+// isNodeFree tells if no mig on the node is in use
+func (a *Adapter) isNodeFree(node *corev1.Node, migsOnNode availableMIGsOnNode) bool {
+	allocable := a.getAllocableMIGsOnNode(node).MIGs
+	for mig, left := range migsOnNode.MIGs {
+		q := allocable[mig]
+		if left.Cmp(q) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// findMIGConfigForFreeGPUs returns the first of the candidate configs providing the mig on a free GPU of the node,
+// while as many GPUs of each layout as may be in use keep their current partitions.
+// which GPUs of a layout host the running pods is not exposed by the cluster, the mig manager is left to refuse
+// destroying a mig in use if the GPUs changed turn out to be busy
+func (a *Adapter) findMIGConfigForFreeGPUs(mig corev1.ResourceName, candidates []string, parted map[string][]migPartedDeviceConfig, node *corev1.Node, migsOnNode availableMIGsOnNode) string {
+
+	current := node.Labels[LABELKEY_MIG_CONFIG]
+	count := a.getGPUCount(node)
+	layouts := a.getGPULayouts(parted[current], count)
+	groups := a.groupBusyGPUs(node, layouts, migsOnNode)
+
+	for _, candidate := range candidates {
+		devices, ok := parted[candidate]
+		if !ok || candidate == current {
+			continue
+		}
+
+		targets := a.getGPULayouts(devices, count)
+		valid, provided := true, false
+		for _, group := range groups {
+			changed := 0
+			for _, i := range group.GPUs {
+				if reflect.DeepEqual(targets[i], group.Layout) {
+					continue
+				}
+				changed++
+				if targets[i][mig] > 0 {
+					provided = true
+				}
+			}
+			if changed > len(group.GPUs)-group.Busy {
+				valid = false
+				break
+			}
+		}
+
+		if valid && provided {
+			return candidate
+		}
+	}
+
+	return ""
+}
+
+// findNodeWithFreeGPUs returns a node to be relabeled with the returned mig config to provide the mig.
+// nodes with a known mig config are accounted per GPU, others only if no mig on them is in use, as are those
+// the mig manager failed to apply the config of, their label no longer tells how the GPUs are partitioned
+func (a *Adapter) findNodeWithFreeGPUs(mig corev1.ResourceName, candidates []string, parted map[string][]migPartedDeviceConfig, selector map[string]string, nodes []corev1.Node, available availableMIGMap) (*corev1.Node, string) {
+
+	if len(candidates) == 0 {
+		return nil, ""
+	}
+
+	md := &migIdentifier{}
+	if err := md.Parse(mig.String()); err != nil {
+		amlog.Info("no node to repartition", "mig", mig, "reason", err.Error())
+		return nil, ""
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		if !a.matchNodeSelector(node.Labels, selector) {
+			continue
+		}
+
+		// the configs of all models are merged, only cards with the profile can provide it
+		if !a.isProfileValidOnNode(node, md) {
+			continue
+		}
+
+		// the mig manager is still applying the last config
+		state := node.Labels[LABELKEY_MIG_CONFIG_STATE]
+		if state == MIG_CONFIG_STATE_PENDING || state == MIG_CONFIG_STATE_REBOOTING {
+			continue
+		}
+
+		migsOnNode, ok := available[node.Name]
+		if !ok {
+			continue
+		}
+
+		if _, known := parted[node.Labels[LABELKEY_MIG_CONFIG]]; known && state != MIG_CONFIG_STATE_FAILED {
+			config := a.findMIGConfigForFreeGPUs(mig, candidates, parted, node, migsOnNode)
+			if config != "" {
+				return node.DeepCopy(), config
+			}
+			continue
+		}
+
+		if a.isNodeFree(node, migsOnNode) {
+			return node.DeepCopy(), candidates[0]
+		}
+	}
+
+	return nil, ""
+}
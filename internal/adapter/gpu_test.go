@@ -0,0 +1,281 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	_test_mig_parted_config_per_gpu = `
+version: v1
+mig-configs:
+  all-3g.20gb:
+    - devices: all
+      mig-enabled: true
+      mig-devices:
+        "3g.20gb": 2
+  mixed-1g-2g:
+    - devices: [0]
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 7
+    - devices: [1]
+      mig-enabled: true
+      mig-devices:
+        "2g.10gb": 3
+  mixed-1g-3g:
+    - devices: [0]
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 7
+    - devices: [1]
+      mig-enabled: true
+      mig-devices:
+        "3g.20gb": 2
+  mixed-3g-2g:
+    - devices: [0]
+      mig-enabled: true
+      mig-devices:
+        "3g.20gb": 2
+    - devices: [1]
+      mig-enabled: true
+      mig-devices:
+        "2g.10gb": 3
+`
+	_test_mig_parted_config_8_gpus = `
+version: v1
+mig-configs:
+  all-1g.5gb:
+    - devices: all
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 7
+  all-3g.20gb:
+    - devices: all
+      mig-enabled: true
+      mig-devices:
+        "3g.20gb": 2
+  half-1g-3g:
+    - devices: [0, 1, 2, 3]
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 7
+    - devices: [4, 5, 6, 7]
+      mig-enabled: true
+      mig-devices:
+        "3g.20gb": 2
+`
+)
+
+var _ = Describe("GPU Unit Test", func() {
+
+	adapter := GetAdapter(cli)
+
+	cfg := &corev1.ConfigMap{
+		Data: map[string]string{
+			"config.yaml": _test_mig_parted_config_per_gpu,
+		},
+	}
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "multigpu",
+			Labels: map[string]string{
+				LABELKEY_GPU_COUNT:  "2",
+				LABELKEY_MIG_CONFIG: "mixed-1g-2g",
+			},
+		},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				_test_mig_Identifier_string_1_5:  resource.MustParse("7"),
+				_test_mig_Identifier_string_2_10: resource.MustParse("3"),
+			},
+		},
+	}
+	pod := _test_pod1.DeepCopy()
+	pod.Spec.NodeName = node.Name
+
+	Context("For a node with several GPUs", func() {
+		It("should lay out each GPU by its device config", func() {
+			layouts := adapter.getGPULayouts(adapter.parseMIGPartedConfigs(cfg)["mixed-1g-2g"], 2)
+			Expect(layouts).To(Equal([]gpuLayout{
+				{_test_mig_Identifier_string_1_5: 7},
+				{_test_mig_Identifier_string_2_10: 3},
+			}))
+		})
+
+		It("should tell how many GPUs of each layout may be in use", func() {
+			available := adapter.detectAllAvailableMIGs([]corev1.Node{node}, []corev1.Pod{*pod})
+			layouts := adapter.getGPULayouts(adapter.parseMIGPartedConfigs(cfg)["mixed-1g-2g"], 2)
+			Expect(adapter.groupBusyGPUs(&node, layouts, available[node.Name])).To(Equal([]gpuGroup{
+				{Layout: layouts[0], GPUs: []int{0}, Busy: 1},
+				{Layout: layouts[1], GPUs: []int{1}, Busy: 0},
+			}))
+		})
+
+		It("should repartition only the free GPU", func() {
+			nodes := []corev1.Node{node}
+			available := adapter.detectAllAvailableMIGs(nodes, []corev1.Pod{*pod})
+			configs := adapter.buildMIGProfileMap(cfg)[_test_mig_Identifier_string_3_20]
+			Expect(configs).To(Equal([]string{"all-3g.20gb", "mixed-1g-3g", "mixed-3g-2g"}))
+
+			found, config := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg), nil, nodes, available)
+			Expect(found).NotTo(BeNil())
+			Expect(found.Name).To(Equal(node.Name))
+			Expect(config).To(Equal("mixed-1g-3g"))
+		})
+
+		It("should repartition only the free GPU when the busy one is not the first", func() {
+			busy := pod.DeepCopy()
+			busy.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					_test_mig_Identifier_string_2_10: _test_quantity_1,
+				},
+				Limits: corev1.ResourceList{
+					_test_mig_Identifier_string_2_10: _test_quantity_1,
+				},
+			}
+			nodes := []corev1.Node{node}
+			available := adapter.detectAllAvailableMIGs(nodes, []corev1.Pod{*busy})
+			configs := adapter.buildMIGProfileMap(cfg)[_test_mig_Identifier_string_3_20]
+
+			found, config := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg), nil, nodes, available)
+			Expect(found).NotTo(BeNil())
+			Expect(config).To(Equal("mixed-3g-2g"))
+		})
+
+		It("should not repartition while the mig manager applies the last config", func() {
+			pending := node.DeepCopy()
+			pending.Labels[LABELKEY_MIG_CONFIG_STATE] = MIG_CONFIG_STATE_PENDING
+			nodes := []corev1.Node{*pending}
+			available := adapter.detectAllAvailableMIGs(nodes, nil)
+			configs := adapter.buildMIGProfileMap(cfg)[_test_mig_Identifier_string_3_20]
+
+			found, _ := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg), nil, nodes, available)
+			Expect(found).To(BeNil())
+		})
+
+		It("should not repartition GPUs in use", func() {
+			busy := pod.DeepCopy()
+			busy.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{
+					_test_mig_Identifier_string_2_10: _test_quantity_1,
+				},
+				Limits: corev1.ResourceList{
+					_test_mig_Identifier_string_2_10: _test_quantity_1,
+				},
+			}
+			nodes := []corev1.Node{node}
+			available := adapter.detectAllAvailableMIGs(nodes, []corev1.Pod{*pod, *busy})
+			configs := adapter.buildMIGProfileMap(cfg)[_test_mig_Identifier_string_3_20]
+
+			found, _ := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg), nil, nodes, available)
+			Expect(found).To(BeNil())
+		})
+	})
+
+	Context("For a node with 8 GPUs of the same layout", func() {
+		cfg8 := &corev1.ConfigMap{
+			Data: map[string]string{
+				"config.yaml": _test_mig_parted_config_8_gpus,
+			},
+		}
+		node8 := corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "eightgpus",
+				Labels: map[string]string{
+					LABELKEY_GPU_COUNT:  "8",
+					LABELKEY_MIG_CONFIG: "all-1g.5gb",
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					_test_mig_Identifier_string_1_5: resource.MustParse("56"),
+				},
+			},
+		}
+
+		It("should repartition the GPUs when no slice is busy", func() {
+			nodes := []corev1.Node{node8}
+			available := adapter.detectAllAvailableMIGs(nodes, nil)
+			configs := adapter.buildMIGProfileMap(cfg8)[_test_mig_Identifier_string_3_20]
+			Expect(configs).To(Equal([]string{"all-3g.20gb", "half-1g-3g"}))
+
+			found, config := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg8), nil, nodes, available)
+			Expect(found).NotTo(BeNil())
+			Expect(found.Name).To(Equal(node8.Name))
+			Expect(config).To(Equal("all-3g.20gb"))
+		})
+
+		It("should repartition the idle GPUs while one slice is busy", func() {
+			busy := _test_pod1.DeepCopy()
+			busy.Spec.NodeName = node8.Name
+			nodes := []corev1.Node{node8}
+			available := adapter.detectAllAvailableMIGs(nodes, []corev1.Pod{*busy})
+			configs := adapter.buildMIGProfileMap(cfg8)[_test_mig_Identifier_string_3_20]
+
+			found, config := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg8), nil, nodes, available)
+			Expect(found).NotTo(BeNil())
+			Expect(found.Name).To(Equal(node8.Name))
+			Expect(config).To(Equal("half-1g-3g"))
+		})
+
+		It("should only repartition the whole node once free after the mig manager failed", func() {
+			failed := node8.DeepCopy()
+			failed.Labels = map[string]string{
+				LABELKEY_GPU_COUNT:        "8",
+				LABELKEY_MIG_CONFIG:       "all-1g.5gb",
+				LABELKEY_MIG_CONFIG_STATE: MIG_CONFIG_STATE_FAILED,
+			}
+			busy := _test_pod1.DeepCopy()
+			busy.Spec.NodeName = failed.Name
+			nodes := []corev1.Node{*failed}
+			configs := adapter.buildMIGProfileMap(cfg8)[_test_mig_Identifier_string_3_20]
+
+			available := adapter.detectAllAvailableMIGs(nodes, []corev1.Pod{*busy})
+			found, _ := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg8), nil, nodes, available)
+			Expect(found).To(BeNil())
+
+			available = adapter.detectAllAvailableMIGs(nodes, nil)
+			found, config := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg8), nil, nodes, available)
+			Expect(found).NotTo(BeNil())
+			Expect(config).To(Equal("all-3g.20gb"))
+		})
+
+		It("should not repartition when the busy slices may be on more GPUs than left idle", func() {
+			pods := []corev1.Pod{}
+			for i := 0; i < 5; i++ {
+				busy := _test_pod1.DeepCopy()
+				busy.Name = fmt.Sprintf("%s-%d", busy.Name, i)
+				busy.Spec.NodeName = node8.Name
+				pods = append(pods, *busy)
+			}
+			nodes := []corev1.Node{node8}
+			available := adapter.detectAllAvailableMIGs(nodes, pods)
+			configs := adapter.buildMIGProfileMap(cfg8)[_test_mig_Identifier_string_3_20]
+
+			found, _ := adapter.findNodeWithFreeGPUs(_test_mig_Identifier_string_3_20, configs, adapter.parseMIGPartedConfigs(cfg8), nil, nodes, available)
+			Expect(found).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,202 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	GPU_GENERATION_AMPERE = "ampere"
+	GPU_GENERATION_HOPPER = "hopper"
+
+	// lets a pod be given migs of GPUs of another generation than the ones providing its profiles, when true
+	ADAPTER_ANNOTATION_CROSS_GENERATION = "cross-generation"
+)
+
+// gpuModel is the profile table of a GPU model, a 3g.20gb of an A100 40GB is not a 3g.20gb of another model
+type gpuModel struct {
+	Name       string
+	Generation string
+	// all of which the nvidia.com/gpu.product label contains, case insensitive
+	Match []string
+	// the profiles of the GPU instances it can be partitioned into, compute instances of these are valid too
+	Profiles []string
+}
+
+// gpuModelCatalog is matched in order against the product label, the more specific models first
+var gpuModelCatalog = []gpuModel{
+	{
+		Name:       "GH200-96GB",
+		Generation: GPU_GENERATION_HOPPER,
+		Match:      []string{"GH200"},
+		Profiles:   []string{"1g.12gb", "1g.12gb+me", "1g.24gb", "2g.24gb", "3g.48gb", "4g.48gb", "7g.96gb"},
+	},
+	{
+		Name:       "H200-141GB",
+		Generation: GPU_GENERATION_HOPPER,
+		Match:      []string{"H200"},
+		Profiles:   []string{"1g.18gb", "1g.18gb+me", "1g.35gb", "2g.35gb", "3g.71gb", "4g.71gb", "7g.141gb"},
+	},
+	{
+		Name:       "H100-NVL-94GB",
+		Generation: GPU_GENERATION_HOPPER,
+		Match:      []string{"H100", "NVL"},
+		Profiles:   []string{"1g.12gb", "1g.12gb+me", "1g.24gb", "2g.24gb", "3g.47gb", "4g.47gb", "7g.94gb"},
+	},
+	{
+		Name:       "H100-80GB",
+		Generation: GPU_GENERATION_HOPPER,
+		Match:      []string{"H100"},
+		Profiles:   []string{"1g.10gb", "1g.10gb+me", "1g.20gb", "2g.20gb", "3g.40gb", "4g.40gb", "7g.80gb"},
+	},
+	{
+		Name:       "A100-80GB",
+		Generation: GPU_GENERATION_AMPERE,
+		Match:      []string{"A100", "80GB"},
+		Profiles:   []string{"1g.10gb", "1g.10gb+me", "1g.20gb", "2g.20gb", "3g.40gb", "4g.40gb", "7g.80gb"},
+	},
+	{
+		Name:       "A100-40GB",
+		Generation: GPU_GENERATION_AMPERE,
+		Match:      []string{"A100"},
+		Profiles:   []string{"1g.5gb", "1g.5gb+me", "1g.10gb", "2g.10gb", "3g.20gb", "4g.20gb", "7g.40gb"},
+	},
+	{
+		Name:       "A30-24GB",
+		Generation: GPU_GENERATION_AMPERE,
+		Match:      []string{"A30"},
+		Profiles:   []string{"1g.6gb", "1g.6gb+me", "2g.12gb", "2g.12gb+me", "4g.24gb"},
+	},
+}
+
+// lookupGPUModel returns the profile table of the GPU model, nil if it is not in the catalog
+func lookupGPUModel(model string) *gpuModel {
+	if model == "" {
+		return nil
+	}
+
+	product := strings.ToUpper(model)
+	for i := range gpuModelCatalog {
+		matched := true
+		for _, m := range gpuModelCatalog[i].Match {
+			if !strings.Contains(product, strings.ToUpper(m)) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &gpuModelCatalog[i]
+		}
+	}
+
+	return nil
+}
+
+// provides tells if the GPU model can be partitioned into the mig, or the GPU instance of a compute instance
+func (m *gpuModel) provides(mig *migIdentifier) bool {
+	if mig.Full {
+		return true
+	}
+
+	instance := *mig
+	instance.ComputeInstance = 0
+	return isProfileInList(&instance, m.Profiles)
+}
+
+// getGPUGenerationsForPod returns the generations of the GPU models providing the migs of all containers of the pod,
+// nil if a mig is in no model of the catalog, as the pod is then not known to run on any generation
+func (a *Adapter) getGPUGenerationsForPod(pod *corev1.Pod) map[string]bool {
+
+	var generations map[string]bool
+	for _, c := range podContainers(&pod.Spec) {
+		mig := a.getContainerMIG(c.Resources)
+		if mig == nil || mig.Full {
+			continue
+		}
+
+		providing := make(map[string]bool)
+		for i := range gpuModelCatalog {
+			if gpuModelCatalog[i].provides(mig) && (generations == nil || generations[gpuModelCatalog[i].Generation]) {
+				providing[gpuModelCatalog[i].Generation] = true
+			}
+		}
+		if len(providing) == 0 {
+			return nil
+		}
+		generations = providing
+	}
+
+	return generations
+}
+
+// isGPUModelAllowedForPod tells if the pod can be given migs of GPUs of the model, only of the generations
+// providing its profiles unless it allows crossing them. GPUs of unknown models are always allowed
+func (a *Adapter) isGPUModelAllowedForPod(pod *corev1.Pod, model string) bool {
+
+	table := lookupGPUModel(model)
+	if table == nil || pod == nil {
+		return true
+	}
+
+	if strings.EqualFold(strings.TrimSpace(pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_CROSS_GENERATION]), "true") {
+		return true
+	}
+
+	generations := a.getGPUGenerationsForPod(pod)
+	return generations == nil || generations[table.Generation]
+}
+
+// isProfileValidOnNode tells if the GPUs of the node can be partitioned into the mig, always true for unknown models
+func (a *Adapter) isProfileValidOnNode(node *corev1.Node, mig *migIdentifier) bool {
+
+	model, _ := getNodeGPUModel(node.Labels)
+	table := lookupGPUModel(model)
+	return table == nil || table.provides(mig)
+}
+
+// getNodeGPUModel returns the GPU model of a node from its product label, and if it is a single strategy node
+func getNodeGPUModel(labels map[string]string) (string, bool) {
+	if model, mig := getSingleStrategyMIG(labels); mig != nil {
+		return model, true
+	}
+
+	return labels[LABELKEY_GPU_PRODUCT], false
+}
+
+// filterNodesForPod keeps the nodes the pod can be given migs on: the single strategy nodes of its model if it
+// selects a product, as it is steered to them with node affinity, or else the mixed strategy nodes of GPU models
+// allowed for it, as it is given their migs with resource names. a nil pod keeps all mixed strategy nodes
+func (a *Adapter) filterNodesForPod(available availableMIGMap, pod *corev1.Pod) availableMIGMap {
+
+	model, target := "", (*migIdentifier)(nil)
+	if pod != nil {
+		model, target = a.getSingleStrategyTarget(pod)
+	}
+
+	filtered := make(availableMIGMap)
+	for node, migsOnNode := range available {
+		if target != nil && migsOnNode.Single && migsOnNode.Model == model ||
+			target == nil && !migsOnNode.Single && a.isGPUModelAllowedForPod(pod, migsOnNode.Model) {
+			filtered[node] = migsOnNode
+		}
+	}
+
+	return filtered
+}
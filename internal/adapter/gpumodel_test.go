@@ -0,0 +1,137 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var _ = Describe("GPU models for Adapter", func() {
+
+	const (
+		product_a100 = "NVIDIA-A100-SXM4-40GB"
+		product_h100 = "NVIDIA-H100-80GB-HBM3"
+	)
+
+	var adapter *Adapter
+
+	labeled := func(node corev1.Node, product string) corev1.Node {
+		copied := node.DeepCopy()
+		copied.Labels = map[string]string{LABELKEY_GPU_PRODUCT: product}
+		return *copied
+	}
+
+	h100Node := func() corev1.Node {
+		node := labeled(_test_node1, product_h100)
+		node.Name = "h100"
+		node.Status.Allocatable = corev1.ResourceList{
+			"nvidia.com/mig-2g.20gb": _test_quantity_1,
+		}
+		return node
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For a product label", func() {
+		It("should find the profile table of the model", func() {
+			for product, name := range map[string]string{
+				product_a100:            "A100-40GB",
+				"NVIDIA-A100-80GB-PCIe": "A100-80GB",
+				product_h100:            "H100-80GB",
+				"NVIDIA-H100-NVL":       "H100-NVL-94GB",
+				"NVIDIA-GH200-480GB":    "GH200-96GB",
+				"NVIDIA-A30":            "A30-24GB",
+			} {
+				Expect(lookupGPUModel(product)).NotTo(BeNil(), product)
+				Expect(lookupGPUModel(product).Name).To(Equal(name), product)
+			}
+			Expect(lookupGPUModel("Tesla-T4")).To(BeNil())
+			Expect(lookupGPUModel("")).To(BeNil())
+		})
+
+		It("should tell the profiles of the card", func() {
+			table := lookupGPUModel(product_a100)
+			for profile, provided := range map[string]bool{
+				_test_mig_Identifier_string_3_20: true,
+				"nvidia.com/mig-1c.3g.20gb":      true,
+				"nvidia.com/mig-1g.5gb+me":       true,
+				"nvidia.com/mig-3g.40gb":         false,
+				"nvidia.com/mig-1g.10gb+me":      false,
+			} {
+				mig := &migIdentifier{}
+				Expect(mig.Parse(profile)).To(Succeed())
+				Expect(table.provides(mig)).To(Equal(provided), profile)
+			}
+		})
+	})
+
+	Context("For a pod", func() {
+		It("should run on the generations providing its profiles", func() {
+			Expect(adapter.getGPUGenerationsForPod(_test_podpending.DeepCopy())).To(Equal(map[string]bool{GPU_GENERATION_AMPERE: true}))
+
+			pod := _test_podpending.DeepCopy()
+			pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{"nvidia.com/mig-1g.10gb": _test_quantity_1},
+			}
+			Expect(adapter.getGPUGenerationsForPod(pod)).To(Equal(map[string]bool{GPU_GENERATION_AMPERE: true, GPU_GENERATION_HOPPER: true}))
+
+			pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{"nvidia.com/mig-5g.50gb": _test_quantity_1},
+			}
+			Expect(adapter.getGPUGenerationsForPod(pod)).To(BeNil())
+		})
+
+		It("should not be sized up across generations unless it allows it", func() {
+			nodes := []corev1.Node{h100Node()}
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, _test_podpending.DeepCopy(), nodes, nil)).To(BeFalse())
+
+			pod := _test_podpending.DeepCopy()
+			pod.Annotations = map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_CROSS_GENERATION: "true"}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName("nvidia.com/mig-2g.20gb")))
+		})
+
+		It("should keep nodes of unknown models", func() {
+			available := availableMIGMap{
+				"unknown": availableMIGsOnNode{MIGs: map[migIdentifier]resource.Quantity{}},
+				"a100":    availableMIGsOnNode{Model: product_a100, MIGs: map[migIdentifier]resource.Quantity{}},
+				"h100":    availableMIGsOnNode{Model: product_h100, MIGs: map[migIdentifier]resource.Quantity{}},
+			}
+			Expect(adapter.filterNodesForPod(available, _test_podpending.DeepCopy())).To(HaveLen(2))
+			Expect(adapter.filterNodesForPod(available, _test_podpending.DeepCopy())).NotTo(HaveKey("h100"))
+		})
+	})
+
+	Context("For a node to repartition", func() {
+		It("should only be one whose card has the profile", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Status.Conditions[0].Message = _test_pod_status_condition_message_prefix + _test_mig_Identifier_string_4_20 + CONDITION_MESSAGE_SEPARATOR
+
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pod, []corev1.Node{labeled(_test_node2, product_h100)}, nil)).To(BeNil())
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pod, []corev1.Node{labeled(_test_node2, product_a100)}, nil)).NotTo(BeNil())
+		})
+	})
+})
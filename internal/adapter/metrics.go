@@ -0,0 +1,172 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	METRICS_NAMESPACE = "mig_adapter"
+
+	// how long a scrape may take to list nodes and pods from the cache
+	METRICS_COLLECT_TIMEOUT = 10 * time.Second
+)
+
+var (
+	adaptedPodsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "adapted_pods_total",
+		Help:      "Number of pending pods restarted with larger migs",
+	})
+	downsizedPodsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "downsized_pods_total",
+		Help:      "Number of pending pods restarted with smaller migs they opted in for",
+	})
+	restoredPodsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "restored_pods_total",
+		Help:      "Number of pods restarted back to their original migs",
+	})
+	evictedPodsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "evicted_pods_total",
+		Help:      "Number of upsized pods restarted back to their original migs for a pending pod of higher priority",
+	})
+	relabeledNodesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "relabeled_nodes_total",
+		Help:      "Number of nodes relabeled with another mig config for a pending pod",
+	})
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "failures_total",
+		Help:      "Number of failed adaptations by reason, the reasons are the ones of the events",
+	}, []string{"reason"})
+
+	dryRunDecisionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "dry_run_decisions_total",
+		Help:      "Number of upsize, downsize, restore, evict and repartition decisions only recorded in dry-run mode",
+	}, []string{"decision"})
+
+	pendingToAdaptedSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: METRICS_NAMESPACE,
+		Name:      "pending_to_adapted_seconds",
+		Help:      "Time from a pod becoming unschedulable to it being restarted with larger migs",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+	})
+
+	allocatableMIGsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "allocatable_migs"),
+		"Allocatable migs of each profile on each node",
+		[]string{"node", "profile"}, nil,
+	)
+	freeMIGsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(METRICS_NAMESPACE, "", "free_migs"),
+		"Migs of each profile on each node neither used by bound pods nor held for restarted pods",
+		[]string{"node", "profile"}, nil,
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		adaptedPodsTotal,
+		downsizedPodsTotal,
+		restoredPodsTotal,
+		evictedPodsTotal,
+		relabeledNodesTotal,
+		failuresTotal,
+		dryRunDecisionsTotal,
+		pendingToAdaptedSeconds,
+	)
+}
+
+// RecordFailure counts a failed adaptation, reason is one of the EVENT_REASON_ warnings
+func (a *Adapter) RecordFailure(reason string) {
+	failuresTotal.WithLabelValues(reason).Inc()
+}
+
+// RecordAdaptationLatency observes how long the pod was pending before it got adapted
+func (a *Adapter) RecordAdaptationLatency(pod *corev1.Pod) {
+
+	since := pod.CreationTimestamp.Time
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && !cond.LastTransitionTime.IsZero() {
+			since = cond.LastTransitionTime.Time
+		}
+	}
+	if since.IsZero() {
+		return
+	}
+
+	pendingToAdaptedSeconds.Observe(time.Since(since).Seconds())
+}
+
+// capacityCollector reports the allocatable and free migs at scrape time, so nodes gone are never reported
+type capacityCollector struct {
+	a *Adapter
+}
+
+// NewCapacityCollector returns the collector of mig capacity to be registered once the adapter has a client
+func (a *Adapter) NewCapacityCollector() prometheus.Collector {
+	return &capacityCollector{a: a}
+}
+
+func (c *capacityCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- allocatableMIGsDesc
+	ch <- freeMIGsDesc
+}
+
+func (c *capacityCollector) Collect(ch chan<- prometheus.Metric) {
+
+	if c.a.Client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), METRICS_COLLECT_TIMEOUT)
+	defer cancel()
+
+	nodes := &corev1.NodeList{}
+	if err := c.a.List(ctx, nodes); err != nil {
+		amlog.Error(err, "list nodes for metrics")
+		return
+	}
+	pods := &corev1.PodList{}
+	if err := c.a.List(ctx, pods); err != nil {
+		amlog.Error(err, "list pods for metrics")
+		return
+	}
+
+	// scrapes leave the reservations alone and are not logged
+	available := c.a.countAvailableMIGs(nodes.Items, pods.Items, false)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		for mig, q := range c.a.getAllocableMIGsOnNode(node).MIGs {
+			ch <- prometheus.MustNewConstMetric(allocatableMIGsDesc, prometheus.GaugeValue, q.AsApproximateFloat64(), node.Name, mig.String())
+		}
+		for mig, q := range available[node.Name].MIGs {
+			ch <- prometheus.MustNewConstMetric(freeMIGsDesc, prometheus.GaugeValue, q.AsApproximateFloat64(), node.Name, mig.String())
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Metrics", func() {
+
+	adapter := &Adapter{
+		policy: DefaultPolicy(),
+	}
+
+	Context("For adaptations", func() {
+		It("should count the pods adapted, restored and relabeled", func() {
+			adapted := testutil.ToFloat64(adaptedPodsTotal)
+			restored := testutil.ToFloat64(restoredPodsTotal)
+			relabeled := testutil.ToFloat64(relabeledNodesTotal)
+
+			adapter.RecordAdaptedPod()
+			adapter.RecordRestoredPod()
+			adapter.RecordRepartitionedPod()
+
+			Expect(testutil.ToFloat64(adaptedPodsTotal)).To(Equal(adapted + 1))
+			Expect(testutil.ToFloat64(restoredPodsTotal)).To(Equal(restored + 1))
+			Expect(testutil.ToFloat64(relabeledNodesTotal)).To(Equal(relabeled + 1))
+		})
+
+		It("should count warnings as failures by reason", func() {
+			failures := testutil.ToFloat64(failuresTotal.WithLabelValues(EVENT_REASON_UPSIZE_FAILED))
+
+			adapter.RecordEvent(_test_pod1.DeepCopy(), corev1.EventTypeWarning, EVENT_REASON_UPSIZE_FAILED, "")
+			adapter.RecordEvent(_test_pod1.DeepCopy(), corev1.EventTypeNormal, EVENT_REASON_UPSIZED, "")
+
+			Expect(testutil.ToFloat64(failuresTotal.WithLabelValues(EVENT_REASON_UPSIZE_FAILED))).To(Equal(failures + 1))
+		})
+
+		It("should observe the time since the pod became unschedulable", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Status.Conditions[0].LastTransitionTime = metav1.NewTime(time.Now().Add(-time.Minute))
+
+			before := &dto.Metric{}
+			Expect(pendingToAdaptedSeconds.Write(before)).To(Succeed())
+
+			adapter.RecordAdaptationLatency(pod)
+
+			after := &dto.Metric{}
+			Expect(pendingToAdaptedSeconds.Write(after)).To(Succeed())
+			Expect(after.GetHistogram().GetSampleCount()).To(Equal(before.GetHistogram().GetSampleCount() + 1))
+			Expect(after.GetHistogram().GetSampleSum() - before.GetHistogram().GetSampleSum()).To(BeNumerically(">=", time.Minute.Seconds()))
+		})
+	})
+
+	Context("For mig capacity", func() {
+		It("should report nothing without a client", func() {
+			Expect(testutil.CollectAndCount(adapter.NewCapacityCollector())).To(BeZero())
+		})
+	})
+})
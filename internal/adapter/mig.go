@@ -3,8 +3,9 @@ package adapter
 import (
 	"errors"
 	"fmt"
-	"reflect"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
@@ -22,47 +23,104 @@ const (
 
 var amlog = logf.Log.WithName("adapter mig")
 
-const (
-	MIG_FORMAT = RESOURCE_MIG_PREFIX + "%dg.%dgb"
-)
+// migProfilePattern matches profiles like 1g.5gb, fractional memory like 1g.4.75gb, compute instances of a
+// GPU instance like 1c.3g.20gb and attributes like 1g.10gb+me, 1g.23gb+me.all or 1g.23gb-me
+var migProfilePattern = regexp.MustCompile(`^(?:([1-9][0-9]*)c\.)?([1-9][0-9]*)g\.([0-9]+(?:\.[0-9]+)?)gb((?:[+-][a-z][a-z0-9.]*)*)$`)
 
 type migIdentifier struct {
 	Compute int
-	Memory  int
+	Memory  float64
+	// compute slices of a compute instance sharing the GPU instance of Compute slices, like 1 for 1c.3g.20gb, 0 for the whole instance
+	ComputeInstance int
+	// as they are in the profile, like +me for media engines
+	Attributes string
+	// a whole GPU, nvidia.com/gpu, rather than a mig
+	Full bool
 }
 
 func (d migIdentifier) Equal(target *migIdentifier) bool {
-	if d.Compute == target.Compute && d.Memory == target.Memory {
-		return true
+	return d == *target
+}
+
+// computeSlices is what the mig computes with, the compute instance if any or else the whole GPU instance
+func (d migIdentifier) computeSlices() int {
+	if d.ComputeInstance > 0 {
+		return d.ComputeInstance
 	}
 
-	return false
+	return d.Compute
 }
 
+// Less compares compute and then memory, then the GPU instance shared and the attributes,
+// a whole GPU is larger than any mig
 func (d migIdentifier) Less(target *migIdentifier) bool {
-	if d.Compute < target.Compute {
-		return true
+	if d.Full != target.Full {
+		return target.Full
+	}
+
+	if d.computeSlices() != target.computeSlices() {
+		return d.computeSlices() < target.computeSlices()
 	}
 
-	if d.Compute == target.Compute && d.Memory < target.Memory {
-		return true
+	if d.Memory != target.Memory {
+		return d.Memory < target.Memory
 	}
 
-	return false
+	if d.Compute != target.Compute {
+		return d.Compute < target.Compute
+	}
+
+	return d.Attributes < target.Attributes
 }
 
+// Parse reads a mig resource name, or nvidia.com/gpu as a whole GPU, and leaves m as it is on error
 func (m *migIdentifier) Parse(str string) error {
-	n, err := fmt.Sscanf(str, MIG_FORMAT, &m.Compute, &m.Memory)
+	if str == RESOURCE_GPU {
+		*m = FULL_GPU
+		return nil
+	}
+
+	if !strings.HasPrefix(str, RESOURCE_MIG_PREFIX) {
+		return fmt.Errorf("%s is no mig resource", str)
+	}
 
-	if n != 2 {
-		return fmt.Errorf("expect 2 but only scanned %d for mig", n)
+	match := migProfilePattern.FindStringSubmatch(strings.TrimPrefix(str, RESOURCE_MIG_PREFIX))
+	if match == nil {
+		return fmt.Errorf("invalid mig profile %s", str)
 	}
 
-	return err
+	parsed := migIdentifier{Attributes: match[4]}
+	var err error
+	if match[1] != "" {
+		if parsed.ComputeInstance, err = strconv.Atoi(match[1]); err != nil {
+			return fmt.Errorf("invalid compute instance of mig %s: %w", str, err)
+		}
+	}
+	if parsed.Compute, err = strconv.Atoi(match[2]); err != nil {
+		return fmt.Errorf("invalid compute of mig %s: %w", str, err)
+	}
+	if parsed.Memory, err = strconv.ParseFloat(match[3], 64); err != nil {
+		return fmt.Errorf("invalid memory of mig %s: %w", str, err)
+	}
+	if parsed.ComputeInstance > parsed.Compute {
+		return fmt.Errorf("compute instance of mig %s larger than its GPU instance", str)
+	}
+
+	*m = parsed
+	return nil
 }
 
 func (m *migIdentifier) String() string {
-	return fmt.Sprintf(MIG_FORMAT, m.Compute, m.Memory)
+	if m.Full {
+		return RESOURCE_GPU
+	}
+
+	str := RESOURCE_MIG_PREFIX
+	if m.ComputeInstance > 0 {
+		str += strconv.Itoa(m.ComputeInstance) + "c."
+	}
+
+	return str + strconv.Itoa(m.Compute) + "g." + strconv.FormatFloat(m.Memory, 'f', -1, 64) + "gb" + m.Attributes
 }
 
 type OrderedmigIdentifierList []migIdentifier
@@ -79,22 +137,16 @@ func (o OrderedmigIdentifierList) Less(i, j int) bool {
 
 func (o OrderedmigIdentifierList) Swap(i, j int) {
 
-	var tmp migIdentifier
-
-	tmp.Compute = o[i].Compute
-	tmp.Memory = o[i].Memory
-
-	o[i].Compute = o[j].Compute
-	o[i].Memory = o[j].Memory
-
-	o[j].Compute = tmp.Compute
-	o[j].Memory = tmp.Memory
-
+	o[i], o[j] = o[j], o[i]
 }
 
 type availableMIGsOnNode struct {
 	NodeLabels map[string]string
 	MIGs       map[migIdentifier]resource.Quantity
+	// GPU model from the product label, empty if unknown
+	Model string
+	// the migs of a single strategy node show up as nvidia.com/gpu
+	Single bool
 }
 
 type availableMIGMap map[string]availableMIGsOnNode
@@ -104,23 +156,29 @@ type podDescriptor struct {
 	Pod *corev1.Pod
 }
 
+// ParsePod keeps the largest mig requested by any container of the pod, init containers included
 func (m *podDescriptor) ParsePod(pod *corev1.Pod) error {
-	for _, c := range pod.Spec.Containers {
+	for _, c := range podContainers(&pod.Spec) {
 		for k := range c.Resources.Limits {
-			if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) {
-				m.Pod = pod
-				if m.md == nil {
-					m.md = &migIdentifier{}
+			// whole GPUs only come in the order of adapted pods sized up to them
+			if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) || k == RESOURCE_GPU {
+				md := &migIdentifier{}
+				if md.Parse(k.String()) != nil {
+					continue
+				}
+				if m.md == nil || m.md.Less(md) {
+					m.md = md
 				}
-				m.md.Parse(k.String())
-
-				// assuming there is only 1 container has mig req and that container only has 1 mig req
-				return nil
 			}
 		}
 	}
 
-	return errors.New("No MIG Resource In Pod " + pod.Namespace + "/" + pod.Name)
+	if m.md == nil {
+		return errors.New("No MIG Resource In Pod " + pod.Namespace + "/" + pod.Name)
+	}
+
+	m.Pod = pod
+	return nil
 }
 
 type podDescriptorList []podDescriptor
@@ -155,7 +213,7 @@ func (a *Adapter) updateMIGInResourceList(list corev1.ResourceList, mig *migIden
 	n := mig.String()
 	for k, v := range list {
 		// remove the
-		if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) && k.String() != n {
+		if a.isMIGResource(k) && k.String() != n {
 			delete(list, k)
 		} else if k.String() == n && v.Equal(q) {
 			return false
@@ -167,33 +225,16 @@ func (a *Adapter) updateMIGInResourceList(list corev1.ResourceList, mig *migIden
 	return true
 }
 
-/*
-	   -1 : <
-		0 : =
-		1 : >
-*/
-func (a *Adapter) compareMIGResources(src, dst corev1.ResourceList) int {
-	srcmd, _ := a.currentMIGResource(src)
-	dstmd, _ := a.currentMIGResource(dst)
-
-	if srcmd.Less(dstmd) {
-		return -1
-	}
-
-	if srcmd.Equal(dstmd) {
-		return 0
-	}
-
-	return 1
-}
-
 func (a *Adapter) currentMIGResource(list corev1.ResourceList) (*migIdentifier, *resource.Quantity) {
 
 	// assume only 1 mig entry in resource list
 	for k, v := range list {
-		if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) {
+		if a.isMIGResource(k) {
 			md := &migIdentifier{}
-			md.Parse(k.String())
+			if err := md.Parse(k.String()); err != nil {
+				amlog.Info("skip resource", "resource", k.String(), "reason", err.Error())
+				continue
+			}
 			return md, &v
 		}
 	}
@@ -201,47 +242,46 @@ func (a *Adapter) currentMIGResource(list corev1.ResourceList) (*migIdentifier,
 	return nil, nil
 }
 
-func (a *Adapter) findAvailableMIGResource(current *migIdentifier, quantity resource.Quantity, selector map[string]string, available availableMIGMap, order OrderedmigIdentifierList) *migIdentifier {
+// findMIGOnNode returns the preferred allowed mig compatible with the current one of the demand with enough left
+// on the node, or the largest one down to its min, and how many steps it is away from the current one
+func (a *Adapter) findMIGOnNode(d *migDemand, migs map[migIdentifier]resource.Quantity, order OrderedmigIdentifierList) (*migIdentifier, int) {
 
-	located := false
-	for _, n := range order {
-		if !located {
-			if current.Less(&n) || current.Equal(&n) {
-				located = true
-			}
+	current, quantity := &d.MIG, d.Quantity
+	steps := 0
+	candidates := a.orderCompatibleMIGs(current, order, d.Compatibility)
+	if d.Min != nil {
+		candidates = a.orderSmallerMIGs(current, d.Min, order, d.Compatibility)
+	}
+	for _, n := range candidates {
+		if !current.Equal(&n) && (!a.isProfileAllowed(&n) || !d.Bound.permits(&n)) {
+			steps++
+			continue
 		}
-		if located {
-			for node, migsOnNode := range available {
 
-				matched := true
-				for k, v := range selector {
-					if migsOnNode.NodeLabels[k] != v {
-						matched = false
-						break
-					}
-				}
-				if !matched {
-					continue
-				}
+		q := migs[n]
+		if q.Cmp(quantity) != -1 {
+			return &n, steps
+		}
+		steps++
+	}
 
-				q := migsOnNode.MIGs[n]
-				if q.Cmp(quantity) != -1 {
-					// remove the resource from available
-					q.Sub(quantity)
-					migsOnNode.MIGs[n] = q
-					available[node] = migsOnNode
+	return nil, 0
+}
 
-					return &n
-				}
-			}
+func (a *Adapter) matchNodeSelector(labels map[string]string, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
 		}
 	}
 
-	return nil
+	return true
 }
 
-func (a *Adapter) getAvailableMIGsAndOrder(nodes []corev1.Node, pods []corev1.Pod) (availableMIGMap, OrderedmigIdentifierList) {
-	available := a.detectAllAvailableMIGs(nodes, pods)
+// getAvailableMIGsAndOrder only returns the nodes the pod can be given migs on and placed on,
+// see filterNodesForPod and filterPlaceableNodes
+func (a *Adapter) getAvailableMIGsAndOrder(nodes []corev1.Node, pods []corev1.Pod, pod *corev1.Pod) (availableMIGMap, OrderedmigIdentifierList) {
+	available := a.filterPlaceableNodes(a.filterNodesForPod(a.detectAllAvailableMIGs(nodes, pods), pod), pod, nodes, pods)
 	if len(available) == 0 {
 		return nil, nil
 	}
@@ -263,9 +303,7 @@ func (a *Adapter) buildOrderedMIGList(available availableMIGMap) OrderedmigIdent
 	order := OrderedmigIdentifierList{}
 	for _, node := range available {
 		for mig := range node.MIGs {
-			md := &migIdentifier{}
-			md.Parse(mig.String())
-			order = append(order, *md)
+			order = append(order, mig)
 		}
 	}
 
@@ -274,23 +312,25 @@ func (a *Adapter) buildOrderedMIGList(available availableMIGMap) OrderedmigIdent
 	return order
 }
 
-// static map for now
-// the process of discovering and loading the map
-// 1. get clusterpolicies.nvidia.com  (cluster resource)
-// 2. from cluster policy get the configmap name from spec.migManager.config.name
-// 3. get the configmap data
-func (a *Adapter) buildMIGProfileMap(cfg *corev1.ConfigMap) map[corev1.ResourceName]string {
+// buildMIGProfileMap maps each profile to the values of nvidia.com/mig.config providing it,
+// from the mig-parted config map found by loadMIGPartedConfigMap.
+// the map of the default mig-parted configs is used when there is no config map or nothing in it
+func (a *Adapter) buildMIGProfileMap(cfg *corev1.ConfigMap) map[corev1.ResourceName][]string {
 
-	if cfg == nil {
-		return map[corev1.ResourceName]string{
-			corev1.ResourceName(RESOURCE_MIG_PREFIX + "1g.5gb"):  "all-1g.5gb",
-			corev1.ResourceName(RESOURCE_MIG_PREFIX + "2g.10gb"): "all-2g.10gb",
-			corev1.ResourceName(RESOURCE_MIG_PREFIX + "3g.20gb"): "all-3g.20gb",
-			corev1.ResourceName(RESOURCE_MIG_PREFIX + "4g.20gb"): "all-4g.20gb",
+	if cfg != nil {
+		profiles, err := a.parseMIGPartedConfigMap(cfg)
+		if err == nil {
+			return profiles
 		}
+		amlog.Info("fall back to default mig configs", "reason", err.Error())
 	}
 
-	return nil
+	return map[corev1.ResourceName][]string{
+		corev1.ResourceName(RESOURCE_MIG_PREFIX + "1g.5gb"):  {"all-1g.5gb"},
+		corev1.ResourceName(RESOURCE_MIG_PREFIX + "2g.10gb"): {"all-2g.10gb"},
+		corev1.ResourceName(RESOURCE_MIG_PREFIX + "3g.20gb"): {"all-3g.20gb"},
+		corev1.ResourceName(RESOURCE_MIG_PREFIX + "4g.20gb"): {"all-4g.20gb"},
+	}
 }
 
 func (a *Adapter) getAllocableMIGsOnNode(node *corev1.Node) availableMIGsOnNode {
@@ -299,10 +339,21 @@ func (a *Adapter) getAllocableMIGsOnNode(node *corev1.Node) availableMIGsOnNode
 		MIGs:       make(map[migIdentifier]resource.Quantity),
 	}
 
+	migsOnNode.Model, migsOnNode.Single = getNodeGPUModel(node.Labels)
+	if _, mig := getSingleStrategyMIG(node.Labels); mig != nil {
+		if q, exists := node.Status.Allocatable[RESOURCE_GPU]; exists {
+			migsOnNode.MIGs[*mig] = q.DeepCopy()
+		}
+		return migsOnNode
+	}
+
 	for k, v := range node.Status.Allocatable {
-		if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) {
+		if a.isMIGResource(k) {
 			md := &migIdentifier{}
-			md.Parse(k.String())
+			if err := md.Parse(k.String()); err != nil {
+				amlog.Info("skip allocatable", "node", node.Name, "resource", k.String(), "reason", err.Error())
+				continue
+			}
 			migsOnNode.MIGs[*md] = v.DeepCopy()
 		}
 	}
@@ -311,6 +362,16 @@ func (a *Adapter) getAllocableMIGsOnNode(node *corev1.Node) availableMIGsOnNode
 }
 
 func (a *Adapter) detectAllAvailableMIGs(nodes []corev1.Node, pods []corev1.Pod) availableMIGMap {
+	available := a.countAvailableMIGs(nodes, pods, true)
+
+	amlog.Info("detect available migs", "migs", len(available))
+
+	return available
+}
+
+// countAvailableMIGs subtracts the migs of the bound pods and of the reservations from the allocatable ones,
+// the reservations released by the pods are only dropped with release
+func (a *Adapter) countAvailableMIGs(nodes []corev1.Node, pods []corev1.Pod, release bool) availableMIGMap {
 	available := make(map[string]availableMIGsOnNode)
 
 	// Get all allocable
@@ -318,80 +379,325 @@ func (a *Adapter) detectAllAvailableMIGs(nodes []corev1.Node, pods []corev1.Pod)
 		available[node.Name] = a.getAllocableMIGsOnNode(&node)
 	}
 
+	// like the scheduler, pods hold their migs from being bound until they are done, terminating ones included
 	for _, pod := range pods {
-		if pod.Status.Phase != corev1.PodRunning {
+		if pod.Spec.NodeName == "" || isPodTerminal(&pod) {
 			continue
 		}
-		for _, c := range pod.Spec.Containers {
-			for k, v := range c.Resources.Limits {
-				if strings.Contains(k.String(), RESOURCE_MIG_PREFIX) {
-					md := &migIdentifier{}
-					md.Parse(k.String())
-					q := available[pod.Spec.NodeName].MIGs[*md]
-					q.Sub(v)
-					available[pod.Spec.NodeName].MIGs[*md] = q
-				}
-			}
+		migsOnNode, ok := available[pod.Spec.NodeName]
+		if !ok {
+			continue
+		}
+		resources, sequential := a.getContainerResources(&pod.Spec)
+		if _, mig := getSingleStrategyMIG(migsOnNode.NodeLabels); mig != nil {
+			resources = getSingleStrategyResources(resources, mig)
+		}
+		demands := a.getMIGDemands(resources, sequential)
+		for md, used := range a.getMIGUsage(demands, nil) {
+			q := migsOnNode.MIGs[md]
+			q.Sub(used)
+			migsOnNode.MIGs[md] = q
 		}
 	}
 
-	amlog.Info("detect available migs", "migs", len(available))
+	// nor are the migs given to restarted pods free until the pods recreated for them are bound
+	a.subtractReservations(available, pods, release)
 
 	return available
 }
 
-func (a *Adapter) checkAndSizeUpMIGForContainerResource(req, limits corev1.ResourceList, selector map[string]string, available availableMIGMap, order OrderedmigIdentifierList) bool {
+// migDemand is the mig one container of a pod asks for
+type migDemand struct {
+	Container string
+	// regular init containers run one by one before the others, so their migs are not held together
+	Sequential bool
+	MIG        migIdentifier
+	Quantity   resource.Quantity
+	// how far the mig can be sized up, unbounded if nil
+	Bound *migBound
+	// which migs can stand in for the current one, both if nil
+	Compatibility migCompatibility
+	// sized down to no smaller than Min if set, rather than up
+	Min *migIdentifier
+}
 
-	current, quantity := a.currentMIGResource(req)
-	if current == nil || quantity == nil {
-		current, quantity = a.currentMIGResource(limits)
-	}
-	if current == nil {
-		amlog.Info("failed to find current mig", "req", req, "limits", limits)
-		return false
+func containerNames(demands []migDemand) []string {
+	names := []string{}
+	for _, d := range demands {
+		names = append(names, d.Container)
 	}
 
-	newmig := a.findAvailableMIGResource(current, *quantity, selector, available, order)
-	if newmig == nil {
-		amlog.Info("no available mig to size up")
-		return false
+	return names
+}
+
+// migPlan is the mig every container of a pod gets on a node
+type migPlan struct {
+	Node string
+	MIGs map[string]migIdentifier
+	// steps above the current migs in total, the lower the better
+	Cost int
+	// what the pod holds of each mig on the node with the plan
+	Usage map[migIdentifier]resource.Quantity
+}
+
+// getContainerResources copies the resources of all containers of a pod by name, init containers included,
+// along with the names of the regular init containers
+func (a *Adapter) getContainerResources(spec *corev1.PodSpec) (PodResources, map[string]bool) {
+
+	resources := make(PodResources)
+	sequential := make(map[string]bool)
+
+	for _, c := range spec.InitContainers {
+		resources[c.Name] = *c.Resources.DeepCopy()
+		if c.RestartPolicy == nil || *c.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+			sequential[c.Name] = true
+		}
+	}
+	for _, c := range spec.Containers {
+		resources[c.Name] = *c.Resources.DeepCopy()
 	}
 
-	updated := a.updateMIGInResourceList(req, newmig, *quantity)
-	if !updated {
-		return false
+	return resources, sequential
+}
+
+// getMIGDemands returns the containers asking for a mig, the largest first
+func (a *Adapter) getMIGDemands(resources PodResources, sequential map[string]bool) []migDemand {
+
+	demands := []migDemand{}
+	for name, res := range resources {
+		current, quantity := a.currentMIGResource(res.Requests)
+		if current == nil || quantity == nil {
+			current, quantity = a.currentMIGResource(res.Limits)
+		}
+		if current == nil || quantity == nil {
+			continue
+		}
+		demands = append(demands, migDemand{
+			Container:  name,
+			Sequential: sequential[name],
+			MIG:        *current,
+			Quantity:   quantity.DeepCopy(),
+		})
 	}
 
-	a.updateMIGInResourceList(limits, newmig, *quantity)
-	return true
+	sort.Slice(demands, func(i, j int) bool {
+		if !demands[i].MIG.Equal(&demands[j].MIG) {
+			return demands[j].MIG.Less(&demands[i].MIG)
+		}
+		return demands[i].Container < demands[j].Container
+	})
+
+	return demands
 }
 
-func (a *Adapter) findAvailableNodeWithFreeGPU(selector map[string]string, nodes []corev1.Node, available availableMIGMap) *corev1.Node {
+// getMIGUsage sums up what the pod holds of each mig, like the scheduler does with init containers,
+// migs of the plan are used in place of the current ones if given
+func (a *Adapter) getMIGUsage(demands []migDemand, plan *migPlan) map[migIdentifier]resource.Quantity {
 
-	selectedNodes := []*corev1.Node{}
+	concurrent := make(map[migIdentifier]resource.Quantity)
+	sequential := make(map[migIdentifier]resource.Quantity)
 
-	for _, n := range nodes {
-		selected := true
-		for k, v := range selector {
-			if n.Labels[k] != v {
-				selected = false
+	for _, d := range demands {
+		mig := d.MIG
+		if plan != nil {
+			mig = plan.MIGs[d.Container]
+		}
+		if d.Sequential {
+			if q := sequential[mig]; q.Cmp(d.Quantity) == -1 {
+				sequential[mig] = d.Quantity.DeepCopy()
 			}
+			continue
 		}
-		if selected {
-			selectedNodes = append(selectedNodes, n.DeepCopy())
+		q := concurrent[mig]
+		q.Add(d.Quantity)
+		concurrent[mig] = q
+	}
+
+	for mig, q := range sequential {
+		if c := concurrent[mig]; c.Cmp(q) == -1 {
+			concurrent[mig] = q
 		}
 	}
 
-	// for now: assume only 1 GPU on 1 Node -> free means no pod usage
-	for _, n := range selectedNodes {
-		if candidate, ok := available[n.Name]; ok {
-			if reflect.DeepEqual(a.getAllocableMIGsOnNode(n).MIGs, candidate.MIGs) {
-				return n.DeepCopy()
-			}
+	return concurrent
+}
+
+// planMIGsOnNode fits all the demands of a pod on a node or returns nil
+func (a *Adapter) planMIGsOnNode(node string, demands []migDemand, migsOnNode availableMIGsOnNode, order OrderedmigIdentifierList) *migPlan {
+
+	plan := &migPlan{
+		Node: node,
+		MIGs: make(map[string]migIdentifier),
+	}
+
+	// containers running together share what is left on the node
+	left := make(map[migIdentifier]resource.Quantity)
+	for k, v := range migsOnNode.MIGs {
+		left[k] = v.DeepCopy()
+	}
+
+	for _, d := range demands {
+		migs := left
+		if d.Sequential {
+			migs = migsOnNode.MIGs
 		}
+
+		mig, steps := a.findMIGOnNode(&d, migs, order)
+		if mig == nil {
+			return nil
+		}
+		if !d.Sequential {
+			q := left[*mig]
+			q.Sub(d.Quantity)
+			left[*mig] = q
+		}
+
+		plan.MIGs[d.Container] = *mig
+		plan.Cost += steps
 	}
 
-	return nil
+	// regular init containers do not hold their migs together, but neither can they take the ones of the others
+	plan.Usage = a.getMIGUsage(demands, plan)
+	for mig, used := range plan.Usage {
+		q := migsOnNode.MIGs[mig]
+		if q.Cmp(used) == -1 {
+			return nil
+		}
+	}
+
+	return plan
+}
+
+// planMIGsForPod finds the node where all the demands of a pod fit with the smallest migs,
+// it is all or nothing as the containers of a pod always land on the same node
+func (a *Adapter) planMIGsForPod(demands []migDemand, selector map[string]string, available availableMIGMap, order OrderedmigIdentifierList) *migPlan {
+
+	if len(demands) == 0 {
+		return nil
+	}
+
+	nodes := []string{}
+	for node := range available {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	var best *migPlan
+	for _, node := range nodes {
+		migsOnNode := available[node]
+		if !a.matchNodeSelector(migsOnNode.NodeLabels, selector) {
+			continue
+		}
+
+		plan := a.planMIGsOnNode(node, demands, migsOnNode, order)
+		if plan != nil && (best == nil || plan.Cost < best.Cost) {
+			best = plan
+		}
+	}
+
+	return best
+}
+
+// takeMIGs removes the migs of the plan from available
+func (a *Adapter) takeMIGs(demands []migDemand, plan *migPlan, available availableMIGMap) {
+
+	migsOnNode := available[plan.Node]
+	for mig, used := range a.getMIGUsage(demands, plan) {
+		q := migsOnNode.MIGs[mig]
+		q.Sub(used)
+		migsOnNode.MIGs[mig] = q
+	}
+	available[plan.Node] = migsOnNode
+}
+
+// releaseMIGs gives the migs of the plan back to available, the other way of takeMIGs
+func (a *Adapter) releaseMIGs(demands []migDemand, plan *migPlan, available availableMIGMap) {
+
+	migsOnNode := available[plan.Node]
+	for mig, used := range a.getMIGUsage(demands, plan) {
+		q := migsOnNode.MIGs[mig]
+		q.Add(used)
+		migsOnNode.MIGs[mig] = q
+	}
+	available[plan.Node] = migsOnNode
+}
+
+// applyMIGPlan updates the resources with the migs of the plan and returns the names of the updated containers
+func (a *Adapter) applyMIGPlan(resources PodResources, demands []migDemand, plan *migPlan) []string {
+
+	updated := []string{}
+	for _, d := range demands {
+		mig := plan.MIGs[d.Container]
+		if mig.Equal(&d.MIG) {
+			continue
+		}
+
+		res := resources[d.Container]
+		if res.Requests == nil {
+			res.Requests = make(corev1.ResourceList)
+		}
+		a.updateMIGInResourceList(res.Requests, &mig, d.Quantity)
+		if res.Limits != nil {
+			a.updateMIGInResourceList(res.Limits, &mig, d.Quantity)
+		}
+		resources[d.Container] = res
+
+		updated = append(updated, d.Container)
+	}
+	sort.Strings(updated)
+
+	return updated
+}
+
+// checkAndSizeUpMIGsForPodResources sizes up the migs of all containers of a pod as one demand within their bounds,
+// nothing is updated unless every container fits on the same node, in which case nil is returned.
+// the plan taken is returned along with the updated containers
+func (a *Adapter) checkAndSizeUpMIGsForPodResources(resources PodResources, sequential map[string]bool, bounds map[string]*migBound, compatibility migCompatibility, selector map[string]string, available availableMIGMap, order OrderedmigIdentifierList) ([]string, *migPlan) {
+
+	demands := a.getMIGDemands(resources, sequential)
+	if len(demands) == 0 {
+		amlog.Info("failed to find current mig", "resources", resources)
+		return nil, nil
+	}
+	for i := range demands {
+		demands[i].Bound = bounds[demands[i].Container]
+		demands[i].Compatibility = compatibility
+	}
+
+	plan := a.planMIGsForPod(demands, selector, available, order)
+	if plan == nil {
+		amlog.Info("no available mig to size up")
+		return nil, nil
+	}
+
+	a.takeMIGs(demands, plan, available)
+
+	return a.applyMIGPlan(resources, demands, plan), plan
+}
+
+// checkAndSizeDownMIGsForPodResources sizes down the migs of all containers of a pod as one demand, each to
+// the largest mig left not smaller than floor, nothing is updated unless every container fits on the same node
+func (a *Adapter) checkAndSizeDownMIGsForPodResources(resources PodResources, sequential map[string]bool, floor *migIdentifier, compatibility migCompatibility, selector map[string]string, available availableMIGMap, order OrderedmigIdentifierList) ([]string, *migPlan) {
+
+	demands := a.getMIGDemands(resources, sequential)
+	if len(demands) == 0 {
+		amlog.Info("failed to find current mig", "resources", resources)
+		return nil, nil
+	}
+	for i := range demands {
+		demands[i].Compatibility = compatibility
+		demands[i].Min = floor
+	}
+
+	plan := a.planMIGsForPod(demands, selector, available, order)
+	if plan == nil {
+		amlog.Info("no available mig to size down", "min", floor.String())
+		return nil, nil
+	}
+
+	a.takeMIGs(demands, plan, available)
+
+	return a.applyMIGPlan(resources, demands, plan), plan
 }
 
 // desc sort pods by MIG
@@ -27,7 +27,7 @@ import (
 const (
 	_test_mig_Identifier_string_1_5 = "nvidia.com/mig-1g.5gb"
 	_test_mig_Identifier_compute    = 1
-	_test_mig_Identifier_memory     = 5
+	_test_mig_Identifier_memory     = 5.0
 
 	_test_mig_Identifier_string_2_10 = "nvidia.com/mig-2g.10gb"
 	_test_mig_Identifier_string_3_20 = "nvidia.com/mig-3g.20gb"
@@ -52,6 +52,67 @@ var _ = Describe("MIG Unit Test", func() {
 		})
 	})
 
+	Context("For newer MIG profiles", func() {
+		It("should keep media engines, compute instances and fractional memory", func() {
+			for str, expected := range map[string]migIdentifier{
+				"nvidia.com/mig-1g.10gb+me":     {Compute: 1, Memory: 10, Attributes: "+me"},
+				"nvidia.com/mig-1g.23gb-me":     {Compute: 1, Memory: 23, Attributes: "-me"},
+				"nvidia.com/mig-1g.23gb+me.all": {Compute: 1, Memory: 23, Attributes: "+me.all"},
+				"nvidia.com/mig-1c.3g.20gb":     {Compute: 3, Memory: 20, ComputeInstance: 1},
+				"nvidia.com/mig-1g.4.75gb":      {Compute: 1, Memory: 4.75},
+				"nvidia.com/mig-1g.12gb":        {Compute: 1, Memory: 12},
+			} {
+				mig := migIdentifier{}
+				Expect(mig.Parse(str)).To(Succeed(), str)
+				Expect(mig).To(Equal(expected), str)
+				Expect(mig.String()).To(Equal(str))
+			}
+		})
+
+		It("should fail on anything else rather than give zeros", func() {
+			for _, str := range []string{
+				"nvidia.com/mig-",
+				"nvidia.com/mig-1g",
+				"nvidia.com/mig-0g.5gb",
+				"nvidia.com/mig-g.5gb",
+				"nvidia.com/mig-1g.5gb+",
+				"nvidia.com/mig-4c.2g.20gb",
+				"nvidia.com/gpu.shared",
+				"1g.5gb",
+			} {
+				mig := migIdentifier{Compute: 2, Memory: 10}
+				Expect(mig.Parse(str)).NotTo(Succeed(), str)
+				Expect(mig).To(Equal(migIdentifier{Compute: 2, Memory: 10}), str)
+			}
+		})
+
+		It("should order them by what they compute with, then memory and attributes", func() {
+			order := OrderedmigIdentifierList{}
+			for _, str := range []string{"nvidia.com/mig-2g.20gb", "nvidia.com/mig-1c.2g.20gb", "nvidia.com/mig-1g.10gb+me", "nvidia.com/mig-1g.10gb"} {
+				mig := migIdentifier{}
+				Expect(mig.Parse(str)).To(Succeed())
+				order = append(order, mig)
+			}
+			sort.Sort(order)
+
+			names := []string{}
+			for _, mig := range order {
+				names = append(names, mig.String())
+			}
+			Expect(names).To(Equal([]string{"nvidia.com/mig-1g.10gb", "nvidia.com/mig-1g.10gb+me", "nvidia.com/mig-1c.2g.20gb", "nvidia.com/mig-2g.20gb"}))
+		})
+
+		It("should not count invalid allocatable migs", func() {
+			node := _test_node1.DeepCopy()
+			node.Status.Allocatable["nvidia.com/mig-invalid"] = _test_quantity_1
+
+			adapter := GetAdapter(cli)
+			migsOnNode := adapter.getAllocableMIGsOnNode(node)
+			Expect(migsOnNode.MIGs).To(HaveLen(2))
+			Expect(migsOnNode.MIGs).NotTo(HaveKey(migIdentifier{}))
+		})
+	})
+
 	Context("For MIG Identifier Array", func() {
 		It("should be able to work with sort.Sort ", func() {
 			m1 := &migIdentifier{}
@@ -108,13 +169,30 @@ var _ = Describe("MIG Unit Test", func() {
 		})
 	})
 
+	Context("For a pod with init containers", func() {
+		It("should hold the larger of its init containers and its containers", func() {
+			pod := _test_pod2.DeepCopy()
+			pod.Spec.InitContainers = []corev1.Container{
+				*pod.Spec.Containers[0].DeepCopy(),
+			}
+			pod.Spec.InitContainers[0].Name = _test_container2_name
+
+			adapter := GetAdapter(cli)
+			available := adapter.detectAllAvailableMIGs([]corev1.Node{_test_node2}, []corev1.Pod{*pod})
+			md := migIdentifier{}
+			Expect(md.Parse(_test_mig_Identifier_string_1_5)).To(Succeed())
+			left := available[_test_node2_name].MIGs[md]
+			Expect(left.Cmp(_test_quantity_1)).To(BeZero())
+		})
+	})
+
 	Context("For a given node", func() {
 		It("should be able to generate available mig map and ordered mig type list", func() {
 			nodes := []corev1.Node{_test_node1, _test_node2}
 			pods := []corev1.Pod{_test_pod1, _test_pod2}
 
 			adapter := GetAdapter(cli)
-			available, order := adapter.getAvailableMIGsAndOrder(nodes, pods)
+			available, order := adapter.getAvailableMIGsAndOrder(nodes, pods, nil)
 			Expect(available).NotTo(BeNil())
 			Expect(order).NotTo(BeNil())
 
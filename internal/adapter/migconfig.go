@@ -0,0 +1,163 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	CLUSTERPOLICY_GROUP   = "nvidia.com"
+	CLUSTERPOLICY_VERSION = "v1"
+	CLUSTERPOLICY_KIND    = "ClusterPolicy"
+
+	DEFAULT_GPU_OPERATOR_NAMESPACE = "gpu-operator"
+	DEFAULT_MIG_PARTED_CONFIG      = "default-mig-parted-config"
+)
+
+// migPartedConfig is the mig-parted config file, only with the fields needed by the adapter
+type migPartedConfig struct {
+	Version    string                             `json:"version"`
+	MIGConfigs map[string][]migPartedDeviceConfig `json:"mig-configs"`
+}
+
+type migPartedDeviceConfig struct {
+	DeviceFilter interface{}    `json:"device-filter,omitempty"`
+	Devices      interface{}    `json:"devices,omitempty"`
+	MIGEnabled   bool           `json:"mig-enabled"`
+	MIGDevices   map[string]int `json:"mig-devices,omitempty"`
+}
+
+// loadMIGPartedConfigMap follows the GPU Operator cluster policy to the config map of mig-parted
+func (a *Adapter) loadMIGPartedConfigMap(ctx context.Context) (*corev1.ConfigMap, error) {
+
+	if a.Client == nil {
+		return nil, errors.New("no client to load " + CLUSTERPOLICY_KIND)
+	}
+
+	policies := &unstructured.UnstructuredList{}
+	policies.SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   CLUSTERPOLICY_GROUP,
+		Version: CLUSTERPOLICY_VERSION,
+		Kind:    CLUSTERPOLICY_KIND + "List",
+	})
+
+	err := a.List(ctx, policies)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies.Items) == 0 {
+		return nil, errors.New("no " + CLUSTERPOLICY_KIND + " found")
+	}
+
+	name, found, err := unstructured.NestedString(policies.Items[0].Object, "spec", "migManager", "config", "name")
+	if err != nil {
+		return nil, err
+	}
+	if !found || name == "" {
+		name = DEFAULT_MIG_PARTED_CONFIG
+	}
+
+	cfg := &corev1.ConfigMap{}
+	err = a.Get(ctx, types.NamespacedName{Namespace: a.GPUOperatorNamespace, Name: name}, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// parseMIGPartedConfigs returns the device configs of each mig config in the config map
+func (a *Adapter) parseMIGPartedConfigs(cfg *corev1.ConfigMap) map[string][]migPartedDeviceConfig {
+
+	configs := make(map[string][]migPartedDeviceConfig)
+	if cfg == nil {
+		return configs
+	}
+
+	for key, data := range cfg.Data {
+		parted := migPartedConfig{}
+		err := yaml.Unmarshal([]byte(data), &parted)
+		if err != nil {
+			amlog.Info("skip invalid mig-parted config", "configmap", cfg.Name, "key", key, "error", err.Error())
+			continue
+		}
+
+		for config, devices := range parted.MIGConfigs {
+			configs[config] = append(configs[config], devices...)
+		}
+	}
+
+	return configs
+}
+
+// parseMIGPartedConfigMap returns the mig configs providing each profile,
+// the config with the most instances of the profile comes first
+func (a *Adapter) parseMIGPartedConfigMap(cfg *corev1.ConfigMap) (map[corev1.ResourceName][]string, error) {
+
+	instances := make(map[corev1.ResourceName]map[string]int)
+
+	for config, devices := range a.parseMIGPartedConfigs(cfg) {
+		for _, device := range devices {
+			if !device.MIGEnabled {
+				continue
+			}
+			for profile, count := range device.MIGDevices {
+				md := &migIdentifier{}
+				if err := md.Parse(RESOURCE_MIG_PREFIX + profile); err != nil {
+					continue
+				}
+				mig := corev1.ResourceName(md.String())
+				if instances[mig] == nil {
+					instances[mig] = make(map[string]int)
+				}
+				if count > instances[mig][config] {
+					instances[mig][config] = count
+				}
+			}
+		}
+	}
+
+	if len(instances) == 0 {
+		return nil, errors.New("no mig config found in " + cfg.Namespace + "/" + cfg.Name)
+	}
+
+	profiles := make(map[corev1.ResourceName][]string)
+	for mig, configs := range instances {
+		names := []string{}
+		for config := range configs {
+			names = append(names, config)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			if configs[names[i]] != configs[names[j]] {
+				return configs[names[i]] > configs[names[j]]
+			}
+			return names[i] < names[j]
+		})
+		profiles[mig] = names
+	}
+
+	return profiles, nil
+}
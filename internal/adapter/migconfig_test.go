@@ -0,0 +1,94 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	_test_mig_parted_config = `
+version: v1
+mig-configs:
+  all-disabled:
+    - devices: all
+      mig-enabled: false
+  all-1g.5gb:
+    - devices: all
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 7
+  custom-mixed:
+    - devices: [0, 1]
+      mig-enabled: true
+      mig-devices:
+        "1g.5gb": 2
+        "2g.10gb": 1
+        "3g.20gb": 1
+    - devices: [2, 3]
+      mig-enabled: false
+`
+)
+
+var _ = Describe("MIG Config Unit Test", func() {
+
+	adapter := GetAdapter(cli)
+
+	Context("For a mig-parted config map", func() {
+		It("should map each profile to the configs providing it", func() {
+			cfg := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      DEFAULT_MIG_PARTED_CONFIG,
+					Namespace: DEFAULT_GPU_OPERATOR_NAMESPACE,
+				},
+				Data: map[string]string{
+					"config.yaml": _test_mig_parted_config,
+				},
+			}
+
+			profiles := adapter.buildMIGProfileMap(cfg)
+			Expect(profiles).To(HaveLen(3))
+			Expect(profiles[_test_mig_Identifier_string_1_5]).To(Equal([]string{"all-1g.5gb", "custom-mixed"}))
+			Expect(profiles[_test_mig_Identifier_string_2_10]).To(Equal([]string{"custom-mixed"}))
+			Expect(profiles[_test_mig_Identifier_string_3_20]).To(Equal([]string{"custom-mixed"}))
+			Expect(profiles).NotTo(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_4_20)))
+		})
+
+		It("should fall back to the default configs without a valid config map", func() {
+			cfg := &corev1.ConfigMap{
+				Data: map[string]string{
+					"config.yaml": "mig-configs: [",
+				},
+			}
+
+			profiles := adapter.buildMIGProfileMap(cfg)
+			Expect(profiles[_test_mig_Identifier_string_4_20]).To(Equal([]string{"all-4g.20gb"}))
+			Expect(adapter.buildMIGProfileMap(nil)).To(Equal(profiles))
+		})
+	})
+
+	Context("For a cluster without the GPU Operator", func() {
+		It("should fail to load the mig-parted config map", func() {
+			cfg, err := adapter.loadMIGPartedConfigMap(ctx)
+			Expect(err).To(HaveOccurred())
+			Expect(cfg).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,144 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	OWNER_KIND_REPLICASET  = "ReplicaSet"
+	OWNER_KIND_DEPLOYMENT  = "Deployment"
+	OWNER_KIND_STATEFULSET = "StatefulSet"
+	OWNER_KIND_JOB         = "Job"
+)
+
+// isBarePod tells if nothing but the adapter recreates the pod once deleted
+func (a *Adapter) isBarePod(pod *corev1.Pod) bool {
+	return metav1.GetControllerOf(pod) == nil
+}
+
+// CanRestartPodWithContext tells if the pod can be deleted to be recreated with other resources,
+// a Job counts the deleted pod as failed so it must not run out of retries
+func (a *Adapter) CanRestartPodWithContext(ctx context.Context, pod *corev1.Pod) bool {
+
+	owner := metav1.GetControllerOf(pod)
+	if owner == nil || owner.Kind != OWNER_KIND_JOB || a.Client == nil {
+		return true
+	}
+
+	job := &batchv1.Job{}
+	err := a.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: owner.Name}, job)
+	if err != nil {
+		aclog.Error(err, "get job of pod", "name", pod.Name, "namespace", pod.Namespace)
+		return false
+	}
+
+	if job.Spec.BackoffLimit != nil && job.Status.Failed >= *job.Spec.BackoffLimit {
+		aclog.Info("job has no retry left to restart pod", "name", pod.Name, "namespace", pod.Namespace)
+		return false
+	}
+
+	return true
+}
+
+// StorePodForRecreationWithContext keeps a bare pod before it is deleted, it is a no-op for pods with an owner
+func (a *Adapter) StorePodForRecreationWithContext(ctx context.Context, pod *corev1.Pod) error {
+
+	if !a.isBarePod(pod) {
+		return nil
+	}
+
+	return a.rules.StorePod(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, a.genRecreatedPod(pod))
+}
+
+// GetPodForRecreationWithContext returns the bare pod to be recreated once the deleted one is gone
+func (a *Adapter) GetPodForRecreationWithContext(ctx context.Context, podkey types.NamespacedName) *corev1.Pod {
+
+	pod, err := a.rules.GetPod(ctx, podkey)
+	if err != nil {
+		aclog.Error(err, "get pod for recreation", "pod", podkey.String())
+		return nil
+	}
+
+	return pod
+}
+
+func (a *Adapter) RemovePodForRecreationWithContext(ctx context.Context, podkey types.NamespacedName) {
+
+	if err := a.rules.RemovePod(ctx, podkey); err != nil {
+		aclog.Error(err, "remove pod for recreation", "pod", podkey.String())
+	}
+}
+
+// genRecreatedPod builds the pod as its owner would, with the original resources,
+// the webhook then applies the rules like to any other recreated pod
+func (a *Adapter) genRecreatedPod(pod *corev1.Pod) *corev1.Pod {
+
+	recreated := &corev1.Pod{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            pod.Name,
+			GenerateName:    pod.GenerateName,
+			Namespace:       pod.Namespace,
+			Labels:          pod.Labels,
+			Annotations:     pod.Annotations,
+			OwnerReferences: pod.OwnerReferences,
+			Finalizers:      pod.Finalizers,
+		},
+		Spec: *pod.Spec.DeepCopy(),
+	}
+	recreated = recreated.DeepCopy()
+
+	// let the scheduler pick the node again, among those it selected before it was steered to another product
+	recreated.Spec.NodeName = ""
+	restoreOriginalSelector(recreated)
+
+	org, exists := recreated.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]
+	if !exists {
+		return recreated
+	}
+	delete(recreated.Annotations, ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL)
+
+	records := PodResources{}
+	if err := json.Unmarshal([]byte(org), &records); err != nil {
+		return recreated
+	}
+	for _, c := range podContainers(&recreated.Spec) {
+		original, ok := records[c.Name]
+		if !ok {
+			continue
+		}
+		if original.Requests != nil {
+			c.Resources.Requests = original.Requests
+		}
+		if original.Limits != nil {
+			c.Resources.Limits = original.Limits
+		}
+		if original.Claims != nil {
+			c.Resources.Claims = original.Claims
+		}
+	}
+
+	return recreated
+}
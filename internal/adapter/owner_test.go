@@ -0,0 +1,146 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Owner of Pods", func() {
+
+	adapter := GetAdapter(cli)
+
+	Context("For pods of a StatefulSet", func() {
+		It("should match the recreated pod by ordinal name", func() {
+			pod := _test_pod2.DeepCopy()
+			pod.Name = _test_pod2_genname + "0"
+			pod.OwnerReferences[0].Kind = OWNER_KIND_STATEFULSET
+
+			Expect(adapter.genPodKey(pod)).To(Equal(types.NamespacedName{
+				Namespace: _test_namespace,
+				Name:      _test_pod2_genname + "0",
+			}))
+		})
+	})
+
+	Context("For bare pods", func() {
+		It("should match the recreated pod by name", func() {
+			pod := _test_pod2.DeepCopy()
+			pod.Name = _test_pod2_genname + "abcde"
+			pod.OwnerReferences = nil
+
+			Expect(adapter.genPodKey(pod)).To(Equal(types.NamespacedName{
+				Namespace: _test_namespace,
+				Name:      _test_pod2_genname + "abcde",
+			}))
+		})
+
+		It("should be kept and recreated with the original resources", func() {
+			pod := _test_pod1.DeepCopy()
+			original := PodResources{
+				_test_container1_name: *pod.Spec.Containers[0].Resources.DeepCopy(),
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes),
+			}
+			pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			podkey := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+
+			Expect(adapter.StorePodForRecreationWithContext(ctx, pod)).To(Succeed())
+			recreated := adapter.GetPodForRecreationWithContext(ctx, podkey)
+			Expect(recreated).NotTo(BeNil())
+			Expect(recreated.Name).To(Equal(pod.Name))
+			Expect(recreated.Spec.NodeName).To(BeEmpty())
+			Expect(recreated.Annotations).NotTo(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL))
+			Expect(recreated.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_pod1.Spec.Containers[0].Resources))
+
+			adapter.RemovePodForRecreationWithContext(ctx, podkey)
+			Expect(adapter.GetPodForRecreationWithContext(ctx, podkey)).To(BeNil())
+		})
+	})
+
+	Context("For pods with an owner", func() {
+		It("should not be kept for recreation", func() {
+			pod := _test_pod2.DeepCopy()
+			pod.Name = _test_pod2_genname + "abcde"
+
+			Expect(adapter.StorePodForRecreationWithContext(ctx, pod)).To(Succeed())
+			Expect(adapter.GetPodForRecreationWithContext(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name})).To(BeNil())
+		})
+	})
+
+	Context("For pods of a Job", func() {
+		It("should be restarted only while the job has retries left", func() {
+			adapter := &Adapter{Client: cli}
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = "default"
+
+			for limit, restartable := range map[int32]bool{0: false, 1: true} {
+				backoff := limit
+				job := &batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      fmt.Sprintf("job%d", limit),
+						Namespace: pod.Namespace,
+					},
+					Spec: batchv1.JobSpec{
+						BackoffLimit: &backoff,
+						Template: corev1.PodTemplateSpec{
+							Spec: corev1.PodSpec{
+								RestartPolicy: corev1.RestartPolicyNever,
+								Containers: []corev1.Container{
+									{
+										Name:  _test_container1_name,
+										Image: "busybox",
+									},
+								},
+							},
+						},
+					},
+				}
+				Expect(cli.Create(ctx, job)).To(Succeed())
+
+				pod.OwnerReferences = []metav1.OwnerReference{
+					{
+						APIVersion: "batch/v1",
+						Kind:       OWNER_KIND_JOB,
+						Name:       job.Name,
+						UID:        job.UID,
+						Controller: &_test_controller,
+					},
+				}
+				Expect(adapter.CanRestartPodWithContext(ctx, pod)).To(Equal(restartable))
+
+				Expect(cli.Delete(ctx, job)).To(Succeed())
+			}
+		})
+	})
+})
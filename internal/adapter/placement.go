@@ -0,0 +1,619 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const (
+	// tolerated by pods the scheduler may place on cordoned nodes
+	TAINTKEY_NODE_UNSCHEDULABLE = "node.kubernetes.io/unschedulable"
+)
+
+// the resources of the pod besides migs checked against what is left on a node, like the NodeResourcesFit plugin
+var placementResources = []corev1.ResourceName{
+	corev1.ResourceCPU,
+	corev1.ResourceMemory,
+	corev1.ResourceEphemeralStorage,
+}
+
+// nodeSelectorOperators maps the operators of node selector requirements to the ones of label selectors
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+// isPodTerminal tells if the pod no longer holds any resource of its node
+func isPodTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// getPodRequests returns what the pod requests like the scheduler accounts it: the containers and the sidecars together,
+// or a regular init container along with the sidecars started before it if larger, plus the overhead
+func getPodRequests(spec *corev1.PodSpec) corev1.ResourceList {
+
+	requests := corev1.ResourceList{}
+	add := func(list corev1.ResourceList, other corev1.ResourceList) {
+		for name, q := range other {
+			sum := list[name]
+			sum.Add(q)
+			list[name] = sum
+		}
+	}
+
+	for _, c := range spec.Containers {
+		add(requests, c.Resources.Requests)
+	}
+
+	sidecars := corev1.ResourceList{}
+	for _, c := range spec.InitContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			add(requests, c.Resources.Requests)
+			add(sidecars, c.Resources.Requests)
+			continue
+		}
+
+		init := corev1.ResourceList{}
+		add(init, sidecars)
+		add(init, c.Resources.Requests)
+		for name, q := range init {
+			if current := requests[name]; current.Cmp(q) == -1 {
+				requests[name] = q
+			}
+		}
+	}
+
+	add(requests, spec.Overhead)
+
+	return requests
+}
+
+// nodeUsage is what the pods bound to a node request, and the host ports they use
+type nodeUsage struct {
+	Requests  corev1.ResourceList
+	Pods      int
+	HostPorts []corev1.ContainerPort
+}
+
+// getNodeUsages sums up the requests of the non terminal pods bound to each node, but the pod itself, and lists
+// their host ports
+func getNodeUsages(pods []corev1.Pod, pod *corev1.Pod) map[string]*nodeUsage {
+
+	usages := make(map[string]*nodeUsage)
+	for i := range pods {
+		p := &pods[i]
+		if p.Spec.NodeName == "" || isPodTerminal(p) || isSamePod(p, pod) {
+			continue
+		}
+		usage, exists := usages[p.Spec.NodeName]
+		if !exists {
+			usage = &nodeUsage{Requests: corev1.ResourceList{}}
+			usages[p.Spec.NodeName] = usage
+		}
+		for name, q := range getPodRequests(&p.Spec) {
+			sum := usage.Requests[name]
+			sum.Add(q)
+			usage.Requests[name] = sum
+		}
+		usage.Pods++
+		for _, c := range podContainers(&p.Spec) {
+			for _, port := range c.Ports {
+				if port.HostPort > 0 {
+					usage.HostPorts = append(usage.HostPorts, port)
+				}
+			}
+		}
+	}
+
+	return usages
+}
+
+func isSamePod(p, pod *corev1.Pod) bool {
+	if p.UID != "" && p.UID == pod.UID {
+		return true
+	}
+
+	return p.Namespace == pod.Namespace && p.Name == pod.Name
+}
+
+// matchNodeSelectorTerm tells if the node matches all the requirements of the term
+func matchNodeSelectorTerm(node *corev1.Node, term *corev1.NodeSelectorTerm) bool {
+
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, expr := range term.MatchExpressions {
+		op, ok := nodeSelectorOperators[expr.Operator]
+		if !ok {
+			return false
+		}
+		requirement, err := labels.NewRequirement(expr.Key, op, expr.Values)
+		if err != nil || !requirement.Matches(labels.Set(node.Labels)) {
+			return false
+		}
+	}
+
+	for _, field := range term.MatchFields {
+		op, ok := nodeSelectorOperators[field.Operator]
+		if !ok || field.Key != "metadata.name" {
+			return false
+		}
+		requirement, err := labels.NewRequirement(field.Key, op, field.Values)
+		if err != nil || !requirement.Matches(labels.Set{field.Key: node.Name}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchNodeAffinity tells if the node matches the node selector and the required node affinity of the pod,
+// like the NodeAffinity plugin
+func (a *Adapter) matchNodeAffinity(spec *corev1.PodSpec, node *corev1.Node) bool {
+
+	if !a.matchNodeSelector(node.Labels, spec.NodeSelector) {
+		return false
+	}
+
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	// the terms are ORed
+	for i := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if matchNodeSelectorTerm(node, &spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// toleratesNode tells if the pod tolerates the taints of the node preventing scheduling, and its cordon if any,
+// like the TaintToleration and NodeUnschedulable plugins
+func toleratesNode(spec *corev1.PodSpec, node *corev1.Node) bool {
+
+	taints := []corev1.Taint{}
+	if node.Spec.Unschedulable {
+		taints = append(taints, corev1.Taint{Key: TAINTKEY_NODE_UNSCHEDULABLE, Effect: corev1.TaintEffectNoSchedule})
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			taints = append(taints, taint)
+		}
+	}
+
+	for i := range taints {
+		tolerated := false
+		for j := range spec.Tolerations {
+			if spec.Tolerations[j].ToleratesTaint(&taints[i]) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fitsNodeResources tells if the node has room for the cpu, memory and ephemeral storage requests of the pod
+// and one more pod, like the NodeResourcesFit plugin, migs are planned apart
+func fitsNodeResources(spec *corev1.PodSpec, node *corev1.Node, usage *nodeUsage) bool {
+
+	if usage == nil {
+		usage = &nodeUsage{Requests: corev1.ResourceList{}}
+	}
+
+	if max, exists := node.Status.Allocatable[corev1.ResourcePods]; exists && int64(usage.Pods+1) > max.Value() {
+		return false
+	}
+
+	requests := getPodRequests(spec)
+	for _, name := range placementResources {
+		q, exists := requests[name]
+		if !exists || q.IsZero() {
+			continue
+		}
+		left := node.Status.Allocatable[name]
+		left.Sub(usage.Requests[name])
+		if left.Cmp(q) == -1 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// topologySpread is where the pods a constraint not to be scheduled otherwise counts are, found once per pod
+type topologySpread struct {
+	TopologyKey string
+	MaxSkew     int
+	// the pods counted in each domain of the nodes the pod can be placed on
+	Counts map[string]int
+	// the fewest pods counted in a domain
+	Fewest int
+	// 1 if the pod counts for its own constraint
+	Self int
+}
+
+// getTopologySpreads counts the pods of each constraint of the pod not to be scheduled otherwise, among the domains
+// of the nodes the pod can be placed on, like the PodTopologySpread plugin
+func (a *Adapter) getTopologySpreads(pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) []topologySpread {
+
+	spreads := []topologySpread{}
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable != corev1.DoNotSchedule {
+			continue
+		}
+
+		selector := labels.Nothing()
+		if constraint.LabelSelector != nil {
+			s, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+			if err != nil {
+				aplog.Error(err, "invalid topology spread selector, constraint ignored", "name", pod.Name, "namespace", pod.Namespace)
+				continue
+			}
+			selector = s
+		}
+
+		spread := topologySpread{TopologyKey: constraint.TopologyKey, MaxSkew: int(constraint.MaxSkew), Counts: make(map[string]int)}
+		domains := make(map[string]string)
+		for i := range nodes {
+			n := &nodes[i]
+			if d, exists := n.Labels[constraint.TopologyKey]; exists && a.matchNodeAffinity(&pod.Spec, n) {
+				spread.Counts[d] += 0
+				domains[n.Name] = d
+			}
+		}
+		for i := range pods {
+			p := &pods[i]
+			d, counted := domains[p.Spec.NodeName]
+			if !counted || p.Namespace != pod.Namespace || isPodTerminal(p) || isSamePod(p, pod) || !selector.Matches(labels.Set(p.Labels)) {
+				continue
+			}
+			spread.Counts[d]++
+		}
+
+		spread.Fewest = -1
+		for _, count := range spread.Counts {
+			if spread.Fewest == -1 || count < spread.Fewest {
+				spread.Fewest = count
+			}
+		}
+		if spread.Fewest == -1 {
+			spread.Fewest = 0
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			spread.Self = 1
+		}
+		spreads = append(spreads, spread)
+	}
+
+	return spreads
+}
+
+// fitsTopologySpread tells if the pod on the node keeps within the max skew of its constraints
+func fitsTopologySpread(node *corev1.Node, spreads []topologySpread) bool {
+
+	for _, spread := range spreads {
+		domain, exists := node.Labels[spread.TopologyKey]
+		if !exists {
+			return false
+		}
+		if spread.Counts[domain]+spread.Self-spread.Fewest > spread.MaxSkew {
+			return false
+		}
+	}
+
+	return true
+}
+
+// podAffinity is where the pods a required inter-pod affinity or anti-affinity term matches are, found once per pod
+type podAffinity struct {
+	TopologyKey string
+	// the domains of the nodes running a pod the term matches
+	Domains map[string]bool
+	Anti    bool
+	// an affinity term matching no pod but the pod itself lets it be placed anywhere, as the first of its group
+	Anywhere bool
+}
+
+// getTermNamespaces returns the namespaces a pod affinity term applies to, nil for all of them. namespace selectors
+// other than the empty one are not evaluated, as the labels of the namespaces are not known here
+func getTermNamespaces(term *corev1.PodAffinityTerm, namespace string) map[string]bool {
+
+	if term.NamespaceSelector != nil && len(term.NamespaceSelector.MatchLabels) == 0 && len(term.NamespaceSelector.MatchExpressions) == 0 {
+		return nil
+	}
+
+	namespaces := make(map[string]bool)
+	for _, ns := range term.Namespaces {
+		namespaces[ns] = true
+	}
+	if len(namespaces) == 0 {
+		namespaces[namespace] = true
+	}
+
+	return namespaces
+}
+
+// matchPodAffinityTerm tells if the term of a pod in the namespace matches the other pod
+func matchPodAffinityTerm(term *corev1.PodAffinityTerm, namespace string, other *corev1.Pod) bool {
+
+	if namespaces := getTermNamespaces(term, namespace); namespaces != nil && !namespaces[other.Namespace] {
+		return false
+	}
+	// a term without selector matches no pod
+	if term.LabelSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(other.Labels))
+}
+
+// getPodAffinities finds the domains of the required inter-pod affinity and anti-affinity terms of the pod,
+// and of the anti-affinity terms of the pods bound to a node matching the pod, like the InterPodAffinity plugin
+func getPodAffinities(pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) []podAffinity {
+
+	nodeLabels := make(map[string]map[string]string)
+	for i := range nodes {
+		nodeLabels[nodes[i].Name] = nodes[i].Labels
+	}
+	bound := []*corev1.Pod{}
+	for i := range pods {
+		p := &pods[i]
+		if p.Spec.NodeName != "" && !isPodTerminal(p) && !isSamePod(p, pod) {
+			bound = append(bound, p)
+		}
+	}
+
+	affinities := []podAffinity{}
+	addTerms := func(terms []corev1.PodAffinityTerm, anti bool) {
+		for i := range terms {
+			term := &terms[i]
+			affinity := podAffinity{TopologyKey: term.TopologyKey, Domains: make(map[string]bool), Anti: anti}
+			matched := false
+			for _, p := range bound {
+				if !matchPodAffinityTerm(term, pod.Namespace, p) {
+					continue
+				}
+				matched = true
+				if d, exists := nodeLabels[p.Spec.NodeName][term.TopologyKey]; exists {
+					affinity.Domains[d] = true
+				}
+			}
+			affinity.Anywhere = !anti && !matched && matchPodAffinityTerm(term, pod.Namespace, pod)
+			affinities = append(affinities, affinity)
+		}
+	}
+	if pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAffinity != nil {
+		addTerms(pod.Spec.Affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution, false)
+	}
+	if pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAntiAffinity != nil {
+		addTerms(pod.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution, true)
+	}
+
+	// the pods already bound keep the pod away from their domains too
+	for _, p := range bound {
+		if p.Spec.Affinity == nil || p.Spec.Affinity.PodAntiAffinity == nil {
+			continue
+		}
+		terms := p.Spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		for i := range terms {
+			d, exists := nodeLabels[p.Spec.NodeName][terms[i].TopologyKey]
+			if !exists || !matchPodAffinityTerm(&terms[i], p.Namespace, pod) {
+				continue
+			}
+			affinities = append(affinities, podAffinity{TopologyKey: terms[i].TopologyKey, Domains: map[string]bool{d: true}, Anti: true})
+		}
+	}
+
+	return affinities
+}
+
+// fitsPodAffinity tells if the node is in a domain of every affinity term of the pod and in none of the anti-affinity ones
+func fitsPodAffinity(node *corev1.Node, affinities []podAffinity) bool {
+
+	for _, affinity := range affinities {
+		domain, exists := node.Labels[affinity.TopologyKey]
+		if affinity.Anti {
+			if exists && affinity.Domains[domain] {
+				return false
+			}
+			continue
+		}
+		if !affinity.Anywhere && (!exists || !affinity.Domains[domain]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fitsHostPorts tells if none of the host ports of the pod is used on the node, like the NodePorts plugin
+func fitsHostPorts(spec *corev1.PodSpec, usage *nodeUsage) bool {
+
+	if usage == nil {
+		return true
+	}
+
+	for _, c := range podContainers(spec) {
+		for _, port := range c.Ports {
+			if port.HostPort <= 0 {
+				continue
+			}
+			for _, used := range usage.HostPorts {
+				if hostPortsConflict(&port, &used) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// hostPortsConflict tells if two host ports are bound to the same port and protocol on a common address
+func hostPortsConflict(port, other *corev1.ContainerPort) bool {
+
+	protocol, otherProtocol := port.Protocol, other.Protocol
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	if otherProtocol == "" {
+		otherProtocol = corev1.ProtocolTCP
+	}
+	if port.HostPort != other.HostPort || protocol != otherProtocol {
+		return false
+	}
+
+	anyIP := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+
+	return anyIP(port.HostIP) || anyIP(other.HostIP) || port.HostIP == other.HostIP
+}
+
+// getPlacementSpec is the pod as it is about to be placed, without its product selector if it is steered
+// to another product of single strategy nodes
+func (a *Adapter) getPlacementSpec(pod *corev1.Pod) *corev1.Pod {
+
+	if _, target := a.getSingleStrategyTarget(pod); target == nil {
+		return pod
+	}
+
+	placed := pod.DeepCopy()
+	placed.Spec.NodeSelector = withoutProductSelector(placed.Spec.NodeSelector)
+	if placed.Spec.Affinity != nil && placed.Spec.Affinity.NodeAffinity != nil && placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms := placed.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+		for i := range terms {
+			exprs := []corev1.NodeSelectorRequirement{}
+			for _, expr := range terms[i].MatchExpressions {
+				if expr.Key != LABELKEY_GPU_PRODUCT {
+					exprs = append(exprs, expr)
+				}
+			}
+			terms[i].MatchExpressions = exprs
+			// a term left empty matches any node rather than none
+			if len(exprs) == 0 && len(terms[i].MatchFields) == 0 {
+				terms[i].MatchExpressions = []corev1.NodeSelectorRequirement{{Key: LABELKEY_GPU_PRODUCT, Operator: corev1.NodeSelectorOpExists}}
+			}
+		}
+	}
+
+	return placed
+}
+
+// podPlacement is what the pod is checked against on each node, found once per pod rather than per node
+type podPlacement struct {
+	Usages     map[string]*nodeUsage
+	Spreads    []topologySpread
+	Affinities []podAffinity
+}
+
+// getPodPlacement finds what the pod is checked against on each node
+func (a *Adapter) getPodPlacement(pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) *podPlacement {
+	return &podPlacement{
+		Usages:     getNodeUsages(pods, pod),
+		Spreads:    a.getTopologySpreads(pod, nodes, pods),
+		Affinities: getPodAffinities(pod, nodes, pods),
+	}
+}
+
+// isPodPlaceableOnNode tells if the scheduler would place the pod on the node but for its migs: its node name,
+// node affinity, tolerations, cpu, memory and ephemeral storage, host ports, topology spread and required
+// inter-pod affinity and anti-affinity. preferred terms and volumes are not checked
+func (a *Adapter) isPodPlaceableOnNode(pod *corev1.Pod, node *corev1.Node, placement *podPlacement) bool {
+
+	if pod.Spec.NodeName != "" && pod.Spec.NodeName != node.Name && pod.Status.Phase == corev1.PodPending {
+		return false
+	}
+
+	usage := placement.Usages[node.Name]
+	return a.matchNodeAffinity(&pod.Spec, node) &&
+		toleratesNode(&pod.Spec, node) &&
+		fitsNodeResources(&pod.Spec, node, usage) &&
+		fitsHostPorts(&pod.Spec, usage) &&
+		fitsTopologySpread(node, placement.Spreads) &&
+		fitsPodAffinity(node, placement.Affinities)
+}
+
+// filterPlaceableNodes keeps the nodes the pod can be placed on, so it is not resized for a node it never gets to
+func (a *Adapter) filterPlaceableNodes(available availableMIGMap, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) availableMIGMap {
+
+	if pod == nil {
+		return available
+	}
+
+	return filterNodesByName(available, a.getPlaceableNodes(available, pod, nodes, pods))
+}
+
+// getNodesForPod returns the names of the nodes of available the pod may be sized on, those of the GPU models
+// allowed for it it can be placed on. they do not depend on the migs left, the plans tried for a batch of pods
+// find them once per pod
+func (a *Adapter) getNodesForPod(pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod, available availableMIGMap) map[string]bool {
+	return a.getPlaceableNodes(a.filterNodesForPod(available, pod), pod, nodes, pods)
+}
+
+// getPlaceableNodes returns the names of the nodes of available the pod can be placed on
+func (a *Adapter) getPlaceableNodes(available availableMIGMap, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) map[string]bool {
+
+	placed := a.getPlacementSpec(pod)
+	placement := a.getPodPlacement(placed, nodes, pods)
+
+	placeable := make(map[string]bool)
+	for i := range nodes {
+		node := &nodes[i]
+		if _, exists := available[node.Name]; !exists {
+			continue
+		}
+		if !a.isPodPlaceableOnNode(placed, node, placement) {
+			aclog.V(1).Info("pod cannot be placed on node", "name", pod.Name, "namespace", pod.Namespace, "node", node.Name)
+			continue
+		}
+		placeable[node.Name] = true
+	}
+
+	return placeable
+}
+
+// filterNodesByName keeps the nodes of available named, sharing their migs with available
+func filterNodesByName(available availableMIGMap, names map[string]bool) availableMIGMap {
+
+	filtered := make(availableMIGMap)
+	for name := range names {
+		if migsOnNode, exists := available[name]; exists {
+			filtered[name] = migsOnNode
+		}
+	}
+
+	return filtered
+}
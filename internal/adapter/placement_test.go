@@ -0,0 +1,243 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Placement for Adapter", func() {
+
+	var adapter *Adapter
+
+	withCPU := func(c corev1.Container, cpu string) corev1.Container {
+		c.Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+		return c
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For the requests of a pod", func() {
+		It("should take the larger of its containers and its init containers", func() {
+			always := corev1.ContainerRestartPolicyAlways
+			spec := &corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					withCPU(corev1.Container{Name: "sidecar", RestartPolicy: &always}, "1"),
+					withCPU(corev1.Container{Name: "init"}, "3"),
+				},
+				Containers: []corev1.Container{
+					withCPU(corev1.Container{Name: "c1"}, "1"),
+					withCPU(corev1.Container{Name: "c2"}, "1"),
+				},
+				Overhead: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")},
+			}
+
+			cpu := getPodRequests(spec)[corev1.ResourceCPU]
+			Expect(cpu.Cmp(resource.MustParse("4500m"))).To(BeZero())
+		})
+	})
+
+	Context("For a node", func() {
+		It("should match the required node affinity", func() {
+			node := _test_node1.DeepCopy()
+			node.Labels = map[string]string{"zone": "a", "gpus": "4"}
+
+			for expr, matched := range map[*corev1.NodeSelectorRequirement]bool{
+				{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"a", "b"}}:    true,
+				{Key: "zone", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"a"}}:      false,
+				{Key: "gpus", Operator: corev1.NodeSelectorOpGt, Values: []string{"2"}}:         true,
+				{Key: "rack", Operator: corev1.NodeSelectorOpExists}:                            false,
+				{Key: "rack", Operator: corev1.NodeSelectorOpDoesNotExist}:                      true,
+				{Key: "gpus", Operator: corev1.NodeSelectorOpLt, Values: []string{"not-a-int"}}: false,
+			} {
+				spec := &corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{*expr}}},
+					},
+				}}}
+				Expect(adapter.matchNodeAffinity(spec, node)).To(Equal(matched), expr.String())
+			}
+
+			spec := &corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchFields: []corev1.NodeSelectorRequirement{
+						{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{_test_node1_name}},
+					}}},
+				},
+			}}}
+			Expect(adapter.matchNodeAffinity(spec, node)).To(BeTrue())
+		})
+
+		It("should be tolerated", func() {
+			node := _test_node1.DeepCopy()
+			node.Spec.Taints = []corev1.Taint{
+				{Key: "dedicated", Value: "training", Effect: corev1.TaintEffectNoSchedule},
+				{Key: "preferred", Effect: corev1.TaintEffectPreferNoSchedule},
+			}
+			spec := &corev1.PodSpec{}
+			Expect(toleratesNode(spec, node)).To(BeFalse())
+
+			spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "training", Effect: corev1.TaintEffectNoSchedule}}
+			Expect(toleratesNode(spec, node)).To(BeTrue())
+
+			node.Spec.Unschedulable = true
+			Expect(toleratesNode(spec, node)).To(BeFalse())
+		})
+
+		It("should have room for the cpu of the pod and one more pod", func() {
+			node := _test_node1.DeepCopy()
+			node.Status.Allocatable[corev1.ResourceCPU] = resource.MustParse("2")
+			node.Status.Allocatable[corev1.ResourcePods] = resource.MustParse("2")
+
+			running := _test_pod1.DeepCopy()
+			running.Spec.Containers[0] = withCPU(running.Spec.Containers[0], "1500m")
+			done := running.DeepCopy()
+			done.Name = "done"
+			done.Status.Phase = corev1.PodSucceeded
+
+			usage := getNodeUsages([]corev1.Pod{*running, *done}, _test_podpending.DeepCopy())[_test_node1_name]
+			Expect(usage.Pods).To(Equal(1))
+
+			spec := &corev1.PodSpec{Containers: []corev1.Container{withCPU(corev1.Container{Name: "c"}, "500m")}}
+			Expect(fitsNodeResources(spec, node, usage)).To(BeTrue())
+			spec.Containers[0] = withCPU(spec.Containers[0], "1")
+			Expect(fitsNodeResources(spec, node, usage)).To(BeFalse())
+
+			node.Status.Allocatable[corev1.ResourcePods] = resource.MustParse("1")
+			Expect(fitsNodeResources(&corev1.PodSpec{}, node, usage)).To(BeFalse())
+		})
+
+		It("should keep the pod within the skew of its topology spread", func() {
+			zoneA := _test_node1.DeepCopy()
+			zoneA.Labels = map[string]string{"zone": "a"}
+			zoneB := _test_node2.DeepCopy()
+			zoneB.Labels = map[string]string{"zone": "b"}
+			nodes := []corev1.Node{*zoneA, *zoneB}
+
+			running := _test_pod1.DeepCopy()
+			running.Labels = map[string]string{"app": "train"}
+
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = running.Namespace
+			pod.Labels = map[string]string{"app": "train"}
+			pod.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       "zone",
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "train"}},
+			}}
+
+			spreads := adapter.getTopologySpreads(pod, nodes, []corev1.Pod{*running})
+			Expect(fitsTopologySpread(zoneA, spreads)).To(BeFalse())
+			Expect(fitsTopologySpread(zoneB, spreads)).To(BeTrue())
+		})
+
+		It("should keep the pod with and away from the pods of its inter-pod affinity terms", func() {
+			zoneA := _test_node1.DeepCopy()
+			zoneA.Labels = map[string]string{"zone": "a"}
+			zoneB := _test_node2.DeepCopy()
+			zoneB.Labels = map[string]string{"zone": "b"}
+			nodes := []corev1.Node{*zoneA, *zoneB}
+
+			running := _test_pod1.DeepCopy()
+			running.Labels = map[string]string{"app": "train"}
+			term := corev1.PodAffinityTerm{
+				TopologyKey:   "zone",
+				LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "train"}},
+			}
+
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = running.Namespace
+			pod.Spec.Affinity = &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+			}}
+			affinities := getPodAffinities(pod, nodes, []corev1.Pod{*running})
+			Expect(fitsPodAffinity(zoneA, affinities)).To(BeTrue())
+			Expect(fitsPodAffinity(zoneB, affinities)).To(BeFalse())
+
+			pod.Spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+			}}
+			affinities = getPodAffinities(pod, nodes, []corev1.Pod{*running})
+			Expect(fitsPodAffinity(zoneA, affinities)).To(BeFalse())
+			Expect(fitsPodAffinity(zoneB, affinities)).To(BeTrue())
+
+			By("keeping the pod away from a running pod whose anti-affinity matches it")
+			pod.Spec.Affinity = nil
+			pod.Labels = map[string]string{"app": "train"}
+			running.Spec.Affinity = &corev1.Affinity{PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+			}}
+			affinities = getPodAffinities(pod, nodes, []corev1.Pod{*running})
+			Expect(fitsPodAffinity(zoneA, affinities)).To(BeFalse())
+			Expect(fitsPodAffinity(zoneB, affinities)).To(BeTrue())
+		})
+
+		It("should place the first pod of its own affinity group anywhere", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Labels = map[string]string{"app": "train"}
+			pod.Spec.Affinity = &corev1.Affinity{PodAffinity: &corev1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{{
+					TopologyKey:   "zone",
+					LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "train"}},
+				}},
+			}}
+			node := _test_node1.DeepCopy()
+			Expect(fitsPodAffinity(node, getPodAffinities(pod, []corev1.Node{*node}, nil))).To(BeTrue())
+		})
+
+		It("should not place the pod on a node whose host port it uses is taken", func() {
+			running := _test_pod1.DeepCopy()
+			running.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 8080, HostPort: 8080}}
+			usage := getNodeUsages([]corev1.Pod{*running}, _test_podpending.DeepCopy())[_test_node1_name]
+
+			spec := _test_podpending.Spec.DeepCopy()
+			Expect(fitsHostPorts(spec, usage)).To(BeTrue())
+			spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 80, HostPort: 8080, Protocol: corev1.ProtocolUDP}}
+			Expect(fitsHostPorts(spec, usage)).To(BeTrue())
+			spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: 80, HostPort: 8080, HostIP: "10.0.0.1"}}
+			Expect(fitsHostPorts(spec, usage)).To(BeFalse())
+		})
+	})
+
+	Context("For a pending pod", func() {
+		It("should not be sized up for a node it cannot be placed on", func() {
+			node := _test_node1.DeepCopy()
+			node.Spec.Taints = []corev1.Taint{{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, _test_podpending.DeepCopy(), []corev1.Node{*node}, nil)).To(BeFalse())
+
+			pod := _test_podpending.DeepCopy()
+			pod.Spec.Tolerations = []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{*node}, nil)).To(BeTrue())
+		})
+
+		It("should only be sized up for the node it is bound to", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Spec.NodeName = _test_node2_name
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeFalse())
+		})
+	})
+})
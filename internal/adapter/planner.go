@@ -0,0 +1,324 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// how soon a pod left pending while another replica of its workload is restarted is tried again
+	DEFERRED_RETRY_PERIOD = 5 * time.Second
+)
+
+// PendingPodAdaptation is a pod resized by the batch plan to be restarted, either a pending pod or
+// an upsized one evicted back to its original migs for a pending pod of higher priority.
+// a deferred pod is not restarted, it is tried again once the replica of its workload restarted before is recreated
+type PendingPodAdaptation struct {
+	Pod       *corev1.Pod
+	Downsized bool
+	Evicted   bool
+	Deferred  bool
+}
+
+// pendingPod is a pod pending for migs as the batch planner sees it
+type pendingPod struct {
+	Pod      *corev1.Pod
+	Priority int32
+	// the migs the largest container can be sized up to on the nodes the pod fits on, with nothing taken by the others
+	Options int
+	// the largest mig asked for by any container, nil if none
+	MIG *migIdentifier
+	// the names of the nodes the pod may be sized on
+	Nodes map[string]bool
+}
+
+// batchScore is how good a batch plan is, the pods placed at each priority and then the steps sized up in total
+type batchScore struct {
+	Placed map[int32]int
+	Cost   int
+}
+
+// betterThan prefers more pods placed from the highest priority down, and then the fewest steps sized up
+func (s batchScore) betterThan(other batchScore) bool {
+
+	priorities := []int32{}
+	for p := range s.Placed {
+		priorities = append(priorities, p)
+	}
+	for p := range other.Placed {
+		if _, exists := s.Placed[p]; !exists {
+			priorities = append(priorities, p)
+		}
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] > priorities[j] })
+
+	for _, p := range priorities {
+		if s.Placed[p] != other.Placed[p] {
+			return s.Placed[p] > other.Placed[p]
+		}
+	}
+
+	return s.Cost < other.Cost
+}
+
+// copyAvailableMIGs copies the migs of every node so that a plan can be tried without touching available
+func copyAvailableMIGs(available availableMIGMap) availableMIGMap {
+
+	copied := make(availableMIGMap)
+	for node, migsOnNode := range available {
+		migs := make(map[migIdentifier]resource.Quantity)
+		for mig, q := range migsOnNode.MIGs {
+			migs[mig] = q.DeepCopy()
+		}
+		migsOnNode.MIGs = migs
+		copied[node] = migsOnNode
+	}
+
+	return copied
+}
+
+// AdaptPendingPodsWithContext plans the migs of all pods pending for them at once rather than one by one as they come,
+// so that a burst of pods does not starve itself. the pods are sized greedily one after the other in two orders,
+// the most constrained first and the smallest first, it is a heuristic and not a search for the best plan: of the
+// two, the plan placing more pods of the highest priority, and then sizing them up the least, is carried out.
+// the nodes each pod may be placed on are found once for both orders. the pods resized are returned to be
+// restarted, along with the pods evicted if pod fits nowhere. in dry run mode only the decisions for pod are recorded, the other pods have theirs on
+// their own turn. the rules of a workload are applied to the next pod recreated for it, so only one replica of
+// a workload is restarted at a time, pod is deferred if another replica is
+func (a *Adapter) AdaptPendingPodsWithContext(ctx context.Context, pod *corev1.Pod, nodes []corev1.Node, pods []corev1.Pod) []PendingPodAdaptation {
+
+	items := pods
+	listed := false
+	for i := range pods {
+		if isSamePod(&pods[i], pod) {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		items = append([]corev1.Pod{*pod}, pods...)
+	}
+
+	available := a.detectAllAvailableMIGs(nodes, pods)
+
+	candidates := []*pendingPod{}
+	deferred := false
+	for i := range items {
+		p := items[i].DeepCopy()
+		trigger := isSamePod(p, pod)
+		if trigger {
+			p = pod
+		}
+		if p.DeletionTimestamp != nil || a.isReservedFor(p) || a.isRestartBackingOff(p) || !a.IsPodPendingForMIGs(p) ||
+			!a.IsPodTargetedWithContext(ctx, p) || !a.isUpsizeAllowedWithContext(ctx, p) {
+			continue
+		}
+		if a.isWorkloadRestarting(p) {
+			deferred = deferred || trigger
+			continue
+		}
+		if !a.CanRestartPodWithContext(ctx, p) {
+			if trigger {
+				a.RecordEvent(p, corev1.EventTypeWarning, EVENT_REASON_RESTART_NOT_ALLOWED, "pod cannot be restarted to size up its migs")
+			}
+			continue
+		}
+		candidates = append(candidates, a.describePendingPod(p, a.getPodPriorityWithContext(ctx, p), nodes, pods, available))
+	}
+	candidates = a.onePendingPodPerWorkload(candidates, pod)
+
+	adaptations := []PendingPodAdaptation{}
+	if deferred {
+		adaptations = append(adaptations, PendingPodAdaptation{Pod: pod, Deferred: true})
+	}
+	if len(candidates) == 0 {
+		return adaptations
+	}
+
+	aclog.Info("pods pending mig", "pods", len(candidates))
+
+	// the pods of the highest priority go first, within a priority there is no one best order, so
+	// the most constrained first and the smallest first are both tried
+	orderings := [][]*pendingPod{
+		sortPendingPods(candidates, func(x, y *pendingPod) bool { return x.Options < y.Options }),
+		sortPendingPods(candidates, func(x, y *pendingPod) bool { return false }),
+	}
+
+	var best []*podResize
+	var bestScore batchScore
+	for i, ordering := range orderings {
+		resizes, score := a.planPendingPods(ordering, nodes, pods, copyAvailableMIGs(available))
+		if i == 0 || score.betterThan(bestScore) {
+			best, bestScore = resizes, score
+		}
+	}
+
+	var unplaced *pendingPod
+	for _, candidate := range candidates {
+		if isSamePod(candidate.Pod, pod) {
+			unplaced = candidate
+		}
+	}
+	for _, resize := range best {
+		trigger := isSamePod(resize.Pod, pod)
+		if trigger && resize.Updated != nil {
+			unplaced = nil
+		}
+		if !trigger && (a.IsDryRun() || len(resize.Updated) == 0) {
+			continue
+		}
+		if a.applyPodResizeWithContext(ctx, resize) {
+			adaptations = append(adaptations, PendingPodAdaptation{Pod: resize.Pod, Downsized: resize.Min != nil})
+		}
+	}
+
+	// a pod fitting nowhere takes what pods of lower priority were upsized to, with the migs just planned out of the way
+	if unplaced != nil {
+		adaptations = append(adaptations, a.preemptUpsizedPodsWithContext(ctx, unplaced.Pod, unplaced.Priority, nodes, pods)...)
+	}
+
+	return adaptations
+}
+
+// onePendingPodPerWorkload keeps pod and the oldest pending replica of every other workload,
+// the replicas left out are planned on their own turn
+func (a *Adapter) onePendingPodPerWorkload(candidates []*pendingPod, pod *corev1.Pod) []*pendingPod {
+
+	kept := make(map[types.NamespacedName]*pendingPod)
+	for _, candidate := range candidates {
+		podkey := a.genPodKey(candidate.Pod)
+		other, exists := kept[podkey]
+		switch {
+		case !exists, isSamePod(candidate.Pod, pod):
+		case isSamePod(other.Pod, pod), !candidate.Pod.CreationTimestamp.Before(&other.Pod.CreationTimestamp):
+			continue
+		}
+		kept[podkey] = candidate
+	}
+
+	left := []*pendingPod{}
+	for _, candidate := range candidates {
+		if kept[a.genPodKey(candidate.Pod)] == candidate {
+			left = append(left, candidate)
+		}
+	}
+
+	return left
+}
+
+// describePendingPod sees how large a pending pod is and how many ways it could be sized up
+func (a *Adapter) describePendingPod(pod *corev1.Pod, priority int32, nodes []corev1.Node, pods []corev1.Pod, available availableMIGMap) *pendingPod {
+
+	pending := &pendingPod{Pod: pod, Priority: priority, Nodes: a.getNodesForPod(pod, nodes, pods, available)}
+
+	view, selector, _ := a.getPodSizingView(pod)
+	resources, sequential := a.getContainerResources(&view.Spec)
+	demands := a.getMIGDemands(resources, sequential)
+	if len(demands) == 0 {
+		return pending
+	}
+	pending.MIG = &demands[0].MIG
+
+	bounds, compatibility := a.getMIGBoundsForPod(view), a.getCompatibilityForPod(view)
+	for i := range demands {
+		demands[i].Bound = bounds[demands[i].Container]
+		demands[i].Compatibility = compatibility
+	}
+
+	filtered := filterNodesByName(available, pending.Nodes)
+	order := a.buildOrderedMIGList(filtered)
+	for node, migsOnNode := range filtered {
+		if !a.matchNodeSelector(migsOnNode.NodeLabels, selector) || a.planMIGsOnNode(node, demands, migsOnNode, order) == nil {
+			continue
+		}
+		largest := demands[0]
+		for _, mig := range a.orderCompatibleMIGs(&largest.MIG, order, largest.Compatibility) {
+			q := migsOnNode.MIGs[mig]
+			if (mig.Equal(&largest.MIG) || a.isProfileAllowed(&mig) && largest.Bound.permits(&mig)) && q.Cmp(largest.Quantity) != -1 {
+				pending.Options++
+			}
+		}
+	}
+
+	return pending
+}
+
+// sortPendingPods orders the pods by priority, then by less, and then the smallest and oldest first
+func sortPendingPods(candidates []*pendingPod, less func(x, y *pendingPod) bool) []*pendingPod {
+
+	sorted := append([]*pendingPod{}, candidates...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		x, y := sorted[i], sorted[j]
+		if x.Priority != y.Priority {
+			return x.Priority > y.Priority
+		}
+		if less(x, y) != less(y, x) {
+			return less(x, y)
+		}
+		if x.MIG != nil && y.MIG != nil && !x.MIG.Equal(y.MIG) {
+			return x.MIG.Less(y.MIG)
+		}
+		if !x.Pod.CreationTimestamp.Equal(&y.Pod.CreationTimestamp) {
+			return x.Pod.CreationTimestamp.Before(&y.Pod.CreationTimestamp)
+		}
+		if x.Pod.Namespace != y.Pod.Namespace {
+			return x.Pod.Namespace < y.Pod.Namespace
+		}
+		return x.Pod.Name < y.Pod.Name
+	})
+
+	return sorted
+}
+
+// planPendingPods sizes the pods one after the other in order out of available, a pod that cannot be
+// sized up is sized down if opted in with a min profile. the resize of every pod is returned, with no
+// container updated for the ones that do not fit
+func (a *Adapter) planPendingPods(ordering []*pendingPod, nodes []corev1.Node, pods []corev1.Pod, available availableMIGMap) ([]*podResize, batchScore) {
+
+	resizes := []*podResize{}
+	score := batchScore{Placed: make(map[int32]int)}
+
+	for _, pending := range ordering {
+		resize := a.planPodResize(pending.Pod, nil, pending.Nodes, nodes, pods, available)
+		if resize != nil && resize.Updated == nil {
+			if floor := a.getMinMIGForPod(pending.Pod); floor != nil {
+				resize = a.planPodResize(pending.Pod, floor, pending.Nodes, nodes, pods, available)
+			}
+		}
+		if resize == nil {
+			continue
+		}
+		resizes = append(resizes, resize)
+		if resize.Updated == nil {
+			continue
+		}
+
+		score.Placed[pending.Priority]++
+		if resize.Plan != nil && resize.Min == nil {
+			score.Cost += resize.Plan.Cost
+		}
+	}
+
+	return resizes, score
+}
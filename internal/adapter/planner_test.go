@@ -0,0 +1,195 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Batch planner for Adapter", func() {
+
+	var adapter *Adapter
+
+	pending := func(name string, priority int32, annotations map[string]string) corev1.Pod {
+		pod := _test_podpending.DeepCopy()
+		pod.Name = name
+		pod.UID = types.UID(name)
+		pod.Spec.Priority = &priority
+		pod.Annotations = annotations
+		return *pod
+	}
+
+	migsOf := func(adaptations []PendingPodAdaptation) map[string]corev1.ResourceList {
+		migs := make(map[string]corev1.ResourceList)
+		for _, adaptation := range adaptations {
+			migs[adaptation.Pod.Name] = adaptation.Pod.Spec.Containers[0].Resources.Limits
+		}
+		return migs
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For a burst of pending pods", func() {
+		It("should place the most constrained first so that all fit", func() {
+			// one by one in name order, the first pod takes the 2g.10gb the second one is bounded to
+			first := pending("a", 0, nil)
+			bounded := pending("b", 0, map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_MAX_PROFILE: "2g.10gb"})
+			pods := []corev1.Pod{first, bounded}
+
+			migs := migsOf(adapter.AdaptPendingPodsWithContext(ctx, &first, []corev1.Node{_test_node1}, pods))
+			Expect(migs).To(HaveLen(2))
+			Expect(migs["a"]).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_3_20)))
+			Expect(migs["b"]).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_2_10)))
+		})
+
+		It("should place the pods of higher priority first", func() {
+			low := pending("a", 0, nil)
+			low.CreationTimestamp = metav1.Unix(0, 0)
+			pods := []corev1.Pod{low, pending("b", 100, nil), pending("c", 100, nil)}
+
+			migs := migsOf(adapter.AdaptPendingPodsWithContext(ctx, &low, []corev1.Node{_test_node1}, pods))
+			Expect(migs).To(HaveLen(2))
+			Expect(migs).To(HaveKey("b"))
+			Expect(migs).To(HaveKey("c"))
+		})
+
+		It("should hold the migs of all pods resized", func() {
+			first := pending("a", 0, nil)
+			pods := []corev1.Pod{first, pending("b", 0, nil)}
+
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, &first, []corev1.Node{_test_node1}, pods)).To(HaveLen(2))
+			Expect(adapter.reservations).To(HaveLen(2))
+			// the pods already restarted are not planned again
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, &first, []corev1.Node{_test_node1}, pods)).To(BeEmpty())
+		})
+
+		It("should size down the pods opted in before the pods of lower priority", func() {
+			bound := _test_pod1.DeepCopy()
+			bound.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{_test_mig_Identifier_string_3_20: _test_quantity_1},
+				Limits:   corev1.ResourceList{_test_mig_Identifier_string_3_20: _test_quantity_1},
+			}
+			first := pending("a", 0, map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_MIN_PROFILE: "2g.10gb"})
+			first.Spec.Containers[0].Resources = *bound.Spec.Containers[0].Resources.DeepCopy()
+			pods := []corev1.Pod{*bound, first, pending("b", -1, nil)}
+
+			adaptations := adapter.AdaptPendingPodsWithContext(ctx, &first, []corev1.Node{_test_node1}, pods)
+			Expect(adaptations).To(HaveLen(1))
+			Expect(adaptations[0].Downsized).To(BeTrue())
+			Expect(migsOf(adaptations)["a"]).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_2_10)))
+		})
+
+		It("should only record the decision for the pod reconciled in dry-run mode", func() {
+			adapter.policy.DryRun = true
+			first := pending("a", 0, nil)
+			pods := []corev1.Pod{first, pending("b", 0, nil)}
+
+			adaptations := adapter.AdaptPendingPodsWithContext(ctx, &first, []corev1.Node{_test_node1}, pods)
+			Expect(adaptations).To(HaveLen(1))
+			Expect(adaptations[0].Pod.Name).To(Equal("a"))
+			Expect(adapter.reservations).To(BeEmpty())
+		})
+	})
+
+	Context("For pending replicas of a ReplicaSet", func() {
+		var replicas []corev1.Pod
+
+		BeforeEach(func() {
+			controller := true
+			replicas = nil
+			for i, name := range []string{"rs-a", "rs-b", "rs-c"} {
+				pod := pending(name, 0, nil)
+				pod.GenerateName = "rs-"
+				pod.Namespace = _test_namespace
+				pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "rs", UID: "rs", Controller: &controller}}
+				pod.CreationTimestamp = metav1.Unix(int64(i), 0)
+				replicas = append(replicas, pod)
+			}
+		})
+
+		It("should restart the replica reconciled and plan the other workloads along", func() {
+			bare := pending("x", 0, nil)
+			pods := append([]corev1.Pod{bare}, replicas...)
+
+			adaptations := adapter.AdaptPendingPodsWithContext(ctx, &replicas[1], []corev1.Node{_test_node1}, pods)
+			Expect(migsOf(adaptations)).To(HaveLen(2))
+			Expect(migsOf(adaptations)).To(HaveKey("rs-b"))
+			Expect(migsOf(adaptations)).To(HaveKey("x"))
+			Expect(adapter.reservations).To(HaveLen(2))
+			Expect(adapter.reservations).To(HaveKey(reservationKey(&replicas[1])))
+		})
+
+		It("should defer the other replicas until the restarted one is recreated", func() {
+			adaptations := adapter.AdaptPendingPodsWithContext(ctx, &replicas[0], []corev1.Node{_test_node1}, replicas)
+			Expect(adaptations).To(HaveLen(1))
+			restarted := adaptations[0].Pod.DeepCopy()
+
+			adaptations = adapter.AdaptPendingPodsWithContext(ctx, &replicas[2], []corev1.Node{_test_node1}, replicas)
+			Expect(adaptations).To(HaveLen(1))
+			Expect(adaptations[0].Pod.Name).To(Equal("rs-c"))
+			Expect(adaptations[0].Deferred).To(BeTrue())
+			Expect(adapter.reservations).To(HaveLen(1))
+
+			recreated := replicas[0]
+			recreated.Name = "rs-d"
+			recreated.UID = "rs-d"
+			recreated.Spec.NodeName = _test_node1_name
+			recreated.Status.Phase = corev1.PodRunning
+			recreated.CreationTimestamp = metav1.Now()
+			recreated.Spec.Containers[0].Resources = *restarted.Spec.Containers[0].Resources.DeepCopy()
+			pods := append([]corev1.Pod{recreated}, replicas[1:]...)
+
+			adaptations = adapter.AdaptPendingPodsWithContext(ctx, &replicas[2], []corev1.Node{_test_node1}, pods)
+			Expect(adaptations).To(HaveLen(1))
+			Expect(adaptations[0].Pod.Name).To(Equal("rs-c"))
+			Expect(adaptations[0].Deferred).To(BeFalse())
+		})
+	})
+
+	Context("For batch plans", func() {
+		It("should prefer more pods placed from the highest priority down and then less waste", func() {
+			plan := batchScore{Placed: map[int32]int{100: 1, 0: 1}, Cost: 4}
+			Expect(plan.betterThan(batchScore{Placed: map[int32]int{0: 3}})).To(BeTrue())
+			Expect(plan.betterThan(batchScore{Placed: map[int32]int{100: 1}})).To(BeTrue())
+			Expect(plan.betterThan(batchScore{Placed: map[int32]int{100: 1, 0: 1}, Cost: 2})).To(BeFalse())
+			Expect(plan.betterThan(batchScore{Placed: map[int32]int{100: 1, 0: 1}, Cost: 6})).To(BeTrue())
+		})
+
+		It("should size pods only on the nodes found for them once", func() {
+			pod := pending("a", 0, nil)
+			nodes := []corev1.Node{_test_node1, _test_node2}
+			available := adapter.detectAllAvailableMIGs(nodes, nil)
+
+			described := adapter.describePendingPod(&pod, 0, nodes, nil, available)
+			Expect(described.Nodes).To(Equal(map[string]bool{_test_node1_name: true, _test_node2_name: true}))
+
+			resize := adapter.planPodResize(&pod, nil, map[string]bool{_test_node1_name: true}, nodes, nil, copyAvailableMIGs(available))
+			Expect(resize).NotTo(BeNil())
+			Expect(resize.Plan).NotTo(BeNil())
+			Expect(resize.Plan.Node).To(Equal(_test_node1_name))
+		})
+	})
+})
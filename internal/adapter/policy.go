@@ -0,0 +1,296 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// values of the policy annotation of a pod
+	POD_POLICY_NEVER        = "never"
+	POD_POLICY_UPSIZE_ONLY  = "upsize-only"
+	POD_POLICY_RESTORE_ONLY = "restore-only"
+)
+
+var aplog = logf.Log.WithName("adapter policy")
+
+// the pods of the system are never adapted, the MutatingWebhookConfiguration leaves them out as well. those of the
+// GPU Operator and of the adapter itself are left out here only, their namespaces are not known to the manifests
+var systemNamespaces = map[string]bool{
+	"kube-system":     true,
+	"kube-public":     true,
+	"kube-node-lease": true,
+}
+
+// Policy controls what the adapter is allowed to do, it is pushed at runtime from the NVidiaMIGAdapter resource
+type Policy struct {
+	// pods in other namespaces are left untouched, all namespaces if empty
+	Namespaces []string
+	// pods in namespaces whose labels don't match are left untouched, all namespaces if nil
+	NamespaceSelector labels.Selector
+	// pods whose labels don't match are left untouched, all pods if nil
+	PodSelector labels.Selector
+	// profiles like 2g.10gb a pod can be sized up to, all profiles if empty
+	AllowedProfiles []string
+	// how many times the compute and memory of its original mig a container can be sized up to, like 1.5,
+	// unbounded if 0
+	MaxUpsizeRatio float64
+
+	// whole GPUs standing in for migs and the other way around, disabled if nil
+	FullGPUFallback *FullGPUFallback
+
+	RestoreEnabled     bool
+	RepartitionEnabled bool
+
+	// how long an adapted pod runs before it is restored, how long after a restore neither the pod nor its workload
+	// is restored again, and how many restores a workload gets before it keeps its adapted migs, never pinned if 0
+	RestoreMinRuntime time.Duration
+	RestoreCooldown   time.Duration
+	MaxRestoreCycles  int
+
+	// when adapted pods are restored, one of the RESTORE_POLICY_ modes, and the windows of the maintenance-window mode
+	RestorePolicy      string
+	MaintenanceWindows []MaintenanceWindow
+
+	// decisions are only recorded, pods are neither restarted nor patched and nodes are not relabeled
+	DryRun bool
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		RestoreEnabled:     true,
+		RepartitionEnabled: true,
+		RestoreMinRuntime:  DEFAULT_RESTORE_MIN_RUNTIME,
+		RestoreCooldown:    DEFAULT_RESTORE_COOLDOWN,
+		MaxRestoreCycles:   DEFAULT_MAX_RESTORE_CYCLES,
+		RestorePolicy:      RESTORE_POLICY_ALWAYS,
+	}
+}
+
+// Statistics counts the pods the adapter acted on since start, the NVidiaMIGAdapter status adds them up across restarts
+type Statistics struct {
+	AdaptedPods       int64
+	DownsizedPods     int64
+	RestoredPods      int64
+	RepartitionedPods int64
+	EvictedPods       int64
+
+	// what would have been done in dry-run mode
+	WouldUpsize         int64
+	WouldDownsize       int64
+	WouldRestore        int64
+	WouldRepartition    int64
+	WouldEvict          int64
+	LastDryRunDecisions []string
+}
+
+func (a *Adapter) SetPolicy(policy Policy) {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	if !reflect.DeepEqual(a.policy, policy) {
+		aplog.Info("policy updated", "policy", policy)
+	}
+	a.policy = policy
+}
+
+func (a *Adapter) GetPolicy() Policy {
+	a.pm.RLock()
+	defer a.pm.RUnlock()
+
+	return a.policy
+}
+
+func (a *Adapter) GetStatistics() Statistics {
+	a.pm.RLock()
+	defer a.pm.RUnlock()
+
+	return a.stats
+}
+
+// GetUnreportedStatistics returns the counts not reported yet with MarkStatisticsReported, along with the
+// latest dry-run decisions
+func (a *Adapter) GetUnreportedStatistics() Statistics {
+	a.pm.RLock()
+	defer a.pm.RUnlock()
+
+	return Statistics{
+		AdaptedPods:         a.stats.AdaptedPods - a.reported.AdaptedPods,
+		DownsizedPods:       a.stats.DownsizedPods - a.reported.DownsizedPods,
+		RestoredPods:        a.stats.RestoredPods - a.reported.RestoredPods,
+		RepartitionedPods:   a.stats.RepartitionedPods - a.reported.RepartitionedPods,
+		EvictedPods:         a.stats.EvictedPods - a.reported.EvictedPods,
+		WouldUpsize:         a.stats.WouldUpsize - a.reported.WouldUpsize,
+		WouldDownsize:       a.stats.WouldDownsize - a.reported.WouldDownsize,
+		WouldRestore:        a.stats.WouldRestore - a.reported.WouldRestore,
+		WouldRepartition:    a.stats.WouldRepartition - a.reported.WouldRepartition,
+		WouldEvict:          a.stats.WouldEvict - a.reported.WouldEvict,
+		LastDryRunDecisions: a.stats.LastDryRunDecisions,
+	}
+}
+
+// MarkStatisticsReported takes the counts returned by GetUnreportedStatistics as added to the status
+func (a *Adapter) MarkStatisticsReported(unreported Statistics) {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.reported.AdaptedPods += unreported.AdaptedPods
+	a.reported.DownsizedPods += unreported.DownsizedPods
+	a.reported.RestoredPods += unreported.RestoredPods
+	a.reported.RepartitionedPods += unreported.RepartitionedPods
+	a.reported.EvictedPods += unreported.EvictedPods
+	a.reported.WouldUpsize += unreported.WouldUpsize
+	a.reported.WouldDownsize += unreported.WouldDownsize
+	a.reported.WouldRestore += unreported.WouldRestore
+	a.reported.WouldRepartition += unreported.WouldRepartition
+	a.reported.WouldEvict += unreported.WouldEvict
+}
+
+func (a *Adapter) RecordAdaptedPod() {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.stats.AdaptedPods++
+	adaptedPodsTotal.Inc()
+}
+
+func (a *Adapter) RecordDownsizedPod() {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.stats.DownsizedPods++
+	downsizedPodsTotal.Inc()
+}
+
+func (a *Adapter) RecordRestoredPod() {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.stats.RestoredPods++
+	restoredPodsTotal.Inc()
+}
+
+func (a *Adapter) RecordRepartitionedPod() {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.stats.RepartitionedPods++
+	relabeledNodesTotal.Inc()
+}
+
+func (a *Adapter) RecordEvictedPod() {
+	a.pm.Lock()
+	defer a.pm.Unlock()
+
+	a.stats.EvictedPods++
+	evictedPodsTotal.Inc()
+}
+
+func (a *Adapter) isNamespaceTargeted(namespace string) bool {
+	if systemNamespaces[namespace] || a.GPUOperatorNamespace != "" && namespace == a.GPUOperatorNamespace ||
+		a.Namespace != "" && namespace == a.Namespace {
+		return false
+	}
+
+	policy := a.GetPolicy()
+	if len(policy.Namespaces) == 0 {
+		return true
+	}
+
+	for _, ns := range policy.Namespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsPodTargetedWithContext tells if the pod is selected by the policy and not opted out with its policy annotation
+func (a *Adapter) IsPodTargetedWithContext(ctx context.Context, pod *corev1.Pod) bool {
+	if !a.isNamespaceTargeted(pod.Namespace) {
+		return false
+	}
+
+	policy := a.GetPolicy()
+	if policy.PodSelector != nil && !policy.PodSelector.Matches(labels.Set(pod.Labels)) {
+		return false
+	}
+	if policy.NamespaceSelector != nil && !a.isNamespaceSelectedWithContext(ctx, pod.Namespace, policy.NamespaceSelector) {
+		return false
+	}
+
+	return a.getPodPolicy(pod) != POD_POLICY_NEVER
+}
+
+func (a *Adapter) isUpsizeAllowedWithContext(ctx context.Context, pod *corev1.Pod) bool {
+	return a.IsPodTargetedWithContext(ctx, pod) && a.getPodPolicy(pod) != POD_POLICY_RESTORE_ONLY
+}
+
+func (a *Adapter) isRestoreAllowedWithContext(ctx context.Context, pod *corev1.Pod) bool {
+	return a.IsPodTargetedWithContext(ctx, pod) && a.getPodPolicy(pod) != POD_POLICY_UPSIZE_ONLY
+}
+
+// the namespace is not selected when its labels cannot be read
+func (a *Adapter) isNamespaceSelectedWithContext(ctx context.Context, namespace string, selector labels.Selector) bool {
+	if a.Client == nil {
+		return false
+	}
+
+	ns := &corev1.Namespace{}
+	if err := a.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		aplog.Error(err, "get namespace", "name", namespace)
+		return false
+	}
+
+	return selector.Matches(labels.Set(ns.Labels))
+}
+
+// getPodPolicy returns the policy annotation of the pod, empty if none. unknown values are taken as never,
+// a pod is rather left untouched than resized against the will of its owner
+func (a *Adapter) getPodPolicy(pod *corev1.Pod) string {
+	value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_POLICY]
+	if !exists {
+		return ""
+	}
+
+	switch value {
+	case POD_POLICY_NEVER, POD_POLICY_UPSIZE_ONLY, POD_POLICY_RESTORE_ONLY:
+		return value
+	}
+
+	aplog.Info("unknown pod policy, pod left untouched", "name", pod.Name, "namespace", pod.Namespace, "policy", value)
+	return POD_POLICY_NEVER
+}
+
+// whole GPUs are allowed along with the fallback to them
+func (a *Adapter) isProfileAllowed(mig *migIdentifier) bool {
+	policy := a.GetPolicy()
+	if len(policy.AllowedProfiles) == 0 || mig.Full {
+		return true
+	}
+
+	return isProfileInList(mig, policy.AllowedProfiles)
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+var _ = Describe("Policy for Adapter", func() {
+
+	adapter := GetAdapter(cli)
+
+	AfterEach(func() {
+		adapter.SetPolicy(DefaultPolicy())
+	})
+
+	Context("For the default policy", func() {
+		It("should target all namespaces and profiles", func() {
+			Expect(adapter.isNamespaceTargeted(_test_namespace)).To(BeTrue())
+
+			md := &migIdentifier{}
+			Expect(md.Parse(_test_mig_Identifier_string_4_20)).To(Succeed())
+			Expect(adapter.isProfileAllowed(md)).To(BeTrue())
+		})
+
+		It("should not target the system, GPU Operator and adapter namespaces", func() {
+			Expect(adapter.isNamespaceTargeted("kube-system")).To(BeFalse())
+			Expect(adapter.isNamespaceTargeted(DEFAULT_GPU_OPERATOR_NAMESPACE)).To(BeFalse())
+
+			adapter.Namespace = "adapter-system"
+			defer func() { adapter.Namespace = "" }()
+			Expect(adapter.isNamespaceTargeted("adapter-system")).To(BeFalse())
+		})
+	})
+
+	Context("For a policy limiting namespaces", func() {
+		It("should not size up pods in other namespaces", func() {
+			policy := DefaultPolicy()
+			policy.Namespaces = []string{"other"}
+			adapter.SetPolicy(policy)
+
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = _test_namespace
+			nodes := []corev1.Node{_test_node1}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+		})
+	})
+
+	Context("For a policy selecting pods by labels", func() {
+		It("should not size up pods without the labels", func() {
+			policy := DefaultPolicy()
+			policy.PodSelector = labels.SelectorFromSet(labels.Set{"mig-adapter": "enabled"})
+			adapter.SetPolicy(policy)
+
+			pod := _test_podpending.DeepCopy()
+			nodes := []corev1.Node{_test_node1}
+			Expect(adapter.IsPodTargetedWithContext(ctx, pod)).To(BeFalse())
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+
+			pod.Labels = map[string]string{"mig-adapter": "enabled"}
+			Expect(adapter.IsPodTargetedWithContext(ctx, pod)).To(BeTrue())
+		})
+	})
+
+	Context("For a policy selecting namespaces by labels", func() {
+		It("should only target pods in namespaces with the labels", func() {
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "selected",
+					Labels: map[string]string{"mig-adapter": "enabled"},
+				},
+			}
+			Expect(cli.Create(ctx, ns)).To(Succeed())
+			defer func() {
+				Expect(cli.Delete(ctx, ns)).To(Succeed())
+			}()
+
+			policy := DefaultPolicy()
+			policy.NamespaceSelector = labels.SelectorFromSet(labels.Set{"mig-adapter": "enabled"})
+			selecting := &Adapter{Client: cli, rules: NewMemoryRuleStore(), policy: policy}
+
+			pod := _test_podpending.DeepCopy()
+			pod.Namespace = ns.Name
+			Expect(selecting.IsPodTargetedWithContext(ctx, pod)).To(BeTrue())
+
+			pod.Namespace = "default"
+			Expect(selecting.IsPodTargetedWithContext(ctx, pod)).To(BeFalse())
+		})
+	})
+
+	Context("For pods with a policy annotation", func() {
+		It("should only do what the annotation allows", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Annotations = map[string]string{}
+			annotation := ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_POLICY
+
+			pod.Annotations[annotation] = POD_POLICY_NEVER
+			Expect(adapter.IsPodTargetedWithContext(ctx, pod)).To(BeFalse())
+			Expect(adapter.isUpsizeAllowedWithContext(ctx, pod)).To(BeFalse())
+			Expect(adapter.isRestoreAllowedWithContext(ctx, pod)).To(BeFalse())
+
+			pod.Annotations[annotation] = POD_POLICY_UPSIZE_ONLY
+			Expect(adapter.isUpsizeAllowedWithContext(ctx, pod)).To(BeTrue())
+			Expect(adapter.isRestoreAllowedWithContext(ctx, pod)).To(BeFalse())
+
+			pod.Annotations[annotation] = POD_POLICY_RESTORE_ONLY
+			Expect(adapter.isUpsizeAllowedWithContext(ctx, pod)).To(BeFalse())
+			Expect(adapter.isRestoreAllowedWithContext(ctx, pod)).To(BeTrue())
+
+			pod.Annotations[annotation] = "sometimes"
+			Expect(adapter.IsPodTargetedWithContext(ctx, pod)).To(BeFalse())
+		})
+
+		It("should not size up pods opted out", func() {
+			pod := _test_podpending.DeepCopy()
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_POLICY: POD_POLICY_RESTORE_ONLY,
+			}
+			nodes := []corev1.Node{_test_node1}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeFalse())
+			Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_podpending.Spec.Containers[0].Resources))
+		})
+	})
+
+	Context("For a policy limiting profiles", func() {
+		It("should only size up to allowed profiles", func() {
+			policy := DefaultPolicy()
+			policy.AllowedProfiles = []string{"3g.20gb"}
+			adapter.SetPolicy(policy)
+
+			pod := _test_podpending.DeepCopy()
+			nodes := []corev1.Node{_test_node1}
+			targetMIG := corev1.ResourceList{
+				_test_mig_Identifier_string_3_20: _test_quantity_1,
+			}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, nil)).To(BeTrue())
+			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(targetMIG))
+		})
+	})
+
+	Context("For a policy disabling restore and repartition", func() {
+		It("should neither restore nor repartition", func() {
+			policy := DefaultPolicy()
+			policy.RestoreEnabled = false
+			policy.RepartitionEnabled = false
+			adapter.SetPolicy(policy)
+
+			nodes := []corev1.Node{_test_node1, _test_node2}
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, nodes, []corev1.Pod{_test_pod1})).To(BeEmpty())
+
+			pod := _test_podpending.DeepCopy()
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pod, nodes, nil)).To(BeNil())
+		})
+	})
+
+	Context("For recorded actions", func() {
+		It("should be counted in statistics", func() {
+			before := adapter.GetStatistics()
+			adapter.RecordAdaptedPod()
+			adapter.RecordDownsizedPod()
+			adapter.RecordRestoredPod()
+			adapter.RecordRepartitionedPod()
+
+			after := adapter.GetStatistics()
+			Expect(after.AdaptedPods).To(Equal(before.AdaptedPods + 1))
+			Expect(after.DownsizedPods).To(Equal(before.DownsizedPods + 1))
+			Expect(after.RestoredPods).To(Equal(before.RestoredPods + 1))
+			Expect(after.RepartitionedPods).To(Equal(before.RepartitionedPods + 1))
+		})
+	})
+})
@@ -0,0 +1,237 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// getPodPriorityWithContext returns the priority the pod is given at admission, or else the value
+// of its PriorityClass, 0 if it has none or it cannot be read
+func (a *Adapter) getPodPriorityWithContext(ctx context.Context, pod *corev1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+	if pod.Spec.PriorityClassName == "" || a.Client == nil {
+		return 0
+	}
+
+	pc := &schedulingv1.PriorityClass{}
+	if err := a.Get(ctx, types.NamespacedName{Name: pod.Spec.PriorityClassName}, pc); err != nil {
+		aclog.Error(err, "get priority class", "name", pod.Spec.PriorityClassName)
+		return 0
+	}
+
+	return pc.Value
+}
+
+// sortPodsByPriorityWithContext puts the pods of higher priority first and keeps the order of the others
+func (a *Adapter) sortPodsByPriorityWithContext(ctx context.Context, pods []*corev1.Pod) {
+
+	priorities := make(map[*corev1.Pod]int32)
+	for _, pod := range pods {
+		priorities[pod] = a.getPodPriorityWithContext(ctx, pod)
+	}
+
+	sort.SliceStable(pods, func(i, j int) bool {
+		return priorities[pods[i]] > priorities[pods[j]]
+	})
+}
+
+// getUpsizedOriginal returns the original resources of a pod given larger migs than it asked for, nil if the pod
+// is not adapted or any of its containers holds a smaller mig than it asked for
+func (a *Adapter) getUpsizedOriginal(pod *corev1.Pod) PodResources {
+
+	org, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]
+	if !exists {
+		return nil
+	}
+	records := PodResources{}
+	if err := json.Unmarshal([]byte(org), &records); err != nil || len(records) == 0 {
+		return nil
+	}
+
+	current, sequential := a.getContainerResources(&pod.Spec)
+	currentMIGs := make(map[string]migIdentifier)
+	for _, d := range a.getMIGDemands(current, sequential) {
+		currentMIGs[d.Container] = d.MIG
+	}
+
+	upsized := false
+	for _, d := range a.getMIGDemands(records, sequential) {
+		held, ok := currentMIGs[d.Container]
+		if !ok || held.Less(&d.MIG) {
+			return nil
+		}
+		if d.MIG.Less(&held) {
+			upsized = true
+		}
+	}
+	if !upsized {
+		return nil
+	}
+
+	return records
+}
+
+// preemptUpsizedPodsWithContext makes room for a pending pod which fits nowhere, by sizing back to their original migs
+// the pods of lower priority holding larger migs than they asked for. the fewest pods of the lowest priority on a
+// node are picked, and the pending pod is planned on what they free up
+func (a *Adapter) preemptUpsizedPodsWithContext(ctx context.Context, pod *corev1.Pod, priority int32, nodes []corev1.Node, pods []corev1.Pod) []PendingPodAdaptation {
+
+	if !a.GetPolicy().RestoreEnabled {
+		return nil
+	}
+
+	type victim struct {
+		Pod      *corev1.Pod
+		Priority int32
+		Original PodResources
+		MIG      *migIdentifier
+	}
+
+	victims := make(map[string][]victim)
+	for i := range pods {
+		p := &pods[i]
+		if p.Spec.NodeName == "" || p.DeletionTimestamp != nil || isPodTerminal(p) || isSamePod(p, pod) {
+			continue
+		}
+		if _, target := a.getSingleStrategyTarget(p); target != nil {
+			continue
+		}
+		v := victim{Pod: p.DeepCopy(), Priority: a.getPodPriorityWithContext(ctx, p)}
+		if v.Priority >= priority {
+			continue
+		}
+		if v.Original = a.getUpsizedOriginal(p); v.Original == nil {
+			continue
+		}
+		if !a.isRestoreAllowedWithContext(ctx, p) || a.isRestartBackingOff(p) || !a.CanRestartPodWithContext(ctx, p) {
+			continue
+		}
+		pd := &podDescriptor{}
+		if pd.ParsePod(p) == nil {
+			v.MIG = pd.md
+		}
+		victims[p.Spec.NodeName] = append(victims[p.Spec.NodeName], v)
+	}
+	if len(victims) == 0 {
+		return nil
+	}
+
+	names := []string{}
+	for node, onNode := range victims {
+		names = append(names, node)
+		// the lowest priority first, and then the largest migs to free the most
+		sort.SliceStable(onNode, func(i, j int) bool {
+			x, y := onNode[i], onNode[j]
+			if x.Priority != y.Priority {
+				return x.Priority < y.Priority
+			}
+			if x.MIG != nil && y.MIG != nil && !x.MIG.Equal(y.MIG) {
+				return y.MIG.Less(x.MIG)
+			}
+			return x.Pod.Namespace+"/"+x.Pod.Name < y.Pod.Namespace+"/"+y.Pod.Name
+		})
+	}
+	sort.Strings(names)
+
+	base := a.detectAllAvailableMIGs(nodes, pods)
+
+	var evicted []victim
+	var resize *podResize
+	for _, node := range names {
+		if _, exists := base[node]; !exists {
+			continue
+		}
+		available := copyAvailableMIGs(base)
+		freed := []victim{}
+		left := pods
+		for _, v := range victims[node] {
+			if evicted != nil && len(freed) >= len(evicted) {
+				break
+			}
+
+			migsOnNode := available[node]
+			current, sequential := a.getContainerResources(&v.Pod.Spec)
+			for mig, used := range a.getMIGUsage(a.getMIGDemands(current, sequential), nil) {
+				q := migsOnNode.MIGs[mig]
+				q.Add(used)
+				migsOnNode.MIGs[mig] = q
+			}
+			freed = append(freed, v)
+			left = withoutPod(left, v.Pod)
+
+			planned := a.planPodResize(pod, nil, nil, nodes, left, copyAvailableMIGs(available))
+			if planned != nil && planned.Updated != nil && planned.Plan.Node == node {
+				evicted, resize = freed, planned
+				break
+			}
+		}
+	}
+	if resize == nil {
+		return nil
+	}
+
+	adaptations := []PendingPodAdaptation{}
+	for _, v := range evicted {
+		a.recordEviction(v.Pod, v.Original, pod)
+		adaptations = append(adaptations, PendingPodAdaptation{Pod: v.Pod, Evicted: true})
+	}
+	if a.applyPodResizeWithContext(ctx, resize) {
+		adaptations = append(adaptations, PendingPodAdaptation{Pod: pod})
+	}
+
+	return adaptations
+}
+
+// recordEviction records the pod as to be restarted with its original resources to make room for another,
+// like a restored pod it needs no rules as it is recreated as it was first
+func (a *Adapter) recordEviction(pod *corev1.Pod, original PodResources, pending *corev1.Pod) {
+
+	current, _ := a.getContainerResources(&pod.Spec)
+	names := []string{}
+	for name := range original {
+		names = append(names, name)
+	}
+	changes := a.describeMIGChanges(current, original, names)
+
+	if a.IsDryRun() {
+		a.recordDryRunDecision(pod, DRY_RUN_DECISION_EVICT, EVENT_REASON_WOULD_EVICT, "restart to size back %s for %s/%s of higher priority", changes, pending.Namespace, pending.Name)
+		return
+	}
+	a.RecordEvent(pod, corev1.EventTypeNormal, EVENT_REASON_EVICTED, "restart to size back %s for %s/%s of higher priority", changes, pending.Namespace, pending.Name)
+}
+
+// withoutPod returns the pods but the given one
+func withoutPod(pods []corev1.Pod, pod *corev1.Pod) []corev1.Pod {
+
+	left := []corev1.Pod{}
+	for i := range pods {
+		if !isSamePod(&pods[i], pod) {
+			left = append(left, pods[i])
+		}
+	}
+
+	return left
+}
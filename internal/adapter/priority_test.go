@@ -0,0 +1,156 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Priorities for Adapter", func() {
+
+	var adapter *Adapter
+
+	withMIG := func(pod *corev1.Pod, mig string) {
+		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceName(mig): _test_quantity_1},
+			Limits:   corev1.ResourceList{corev1.ResourceName(mig): _test_quantity_1},
+		}
+	}
+
+	// upsized is a pod bound to node1 holding mig, sized up from original
+	upsized := func(name string, priority int32, mig, original string) corev1.Pod {
+		pod := _test_pod1.DeepCopy()
+		pod.Name = name
+		pod.UID = types.UID(name)
+		pod.Spec.Priority = &priority
+		withMIG(pod, original)
+		bytes, err := json.Marshal(PodResources{_test_container1_name: pod.Spec.Containers[0].Resources})
+		Expect(err).NotTo(HaveOccurred())
+		pod.Annotations = map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes)}
+		withMIG(pod, mig)
+		return *pod
+	}
+
+	pending := func(name string, priority int32, mig string) corev1.Pod {
+		pod := _test_podpending.DeepCopy()
+		pod.Name = name
+		pod.UID = types.UID(name)
+		pod.Spec.Priority = &priority
+		withMIG(pod, mig)
+		return *pod
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For a pod", func() {
+		It("should take the priority it was admitted with", func() {
+			pod := pending("a", 100, _test_mig_Identifier_string_1_5)
+			Expect(adapter.getPodPriorityWithContext(ctx, &pod)).To(Equal(int32(100)))
+
+			pod.Spec.Priority = nil
+			Expect(adapter.getPodPriorityWithContext(ctx, &pod)).To(BeZero())
+		})
+
+		It("should fall back to its PriorityClass", func() {
+			pc := &schedulingv1.PriorityClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "mig-adapter-test-high"},
+				Value:      1000,
+			}
+			Expect(cli.Create(ctx, pc)).To(Succeed())
+			defer func() {
+				Expect(cli.Delete(ctx, pc)).To(Succeed())
+			}()
+
+			adapter.Client = cli
+			pod := pending("a", 0, _test_mig_Identifier_string_1_5)
+			pod.Spec.Priority = nil
+			pod.Spec.PriorityClassName = pc.Name
+			Expect(adapter.getPodPriorityWithContext(ctx, &pod)).To(Equal(int32(1000)))
+
+			pod.Spec.PriorityClassName = "mig-adapter-test-missing"
+			Expect(adapter.getPodPriorityWithContext(ctx, &pod)).To(BeZero())
+		})
+
+		It("should sort the pods of higher priority first and keep the order of the others", func() {
+			first, second, third := pending("a", 0, _test_mig_Identifier_string_3_20), pending("b", 100, _test_mig_Identifier_string_1_5), pending("c", 0, _test_mig_Identifier_string_2_10)
+			pods := []*corev1.Pod{&first, &second, &third}
+			adapter.sortPodsByPriorityWithContext(ctx, pods)
+			Expect(pods).To(Equal([]*corev1.Pod{&second, &first, &third}))
+		})
+
+		It("should only be upsized if it holds larger migs than it asked for", func() {
+			pod := upsized("a", 0, _test_mig_Identifier_string_3_20, _test_mig_Identifier_string_1_5)
+			Expect(adapter.getUpsizedOriginal(&pod)).To(HaveKey(_test_container1_name))
+
+			downsized := upsized("b", 0, _test_mig_Identifier_string_1_5, _test_mig_Identifier_string_3_20)
+			Expect(adapter.getUpsizedOriginal(&downsized)).To(BeNil())
+
+			Expect(adapter.getUpsizedOriginal(_test_pod1.DeepCopy())).To(BeNil())
+		})
+	})
+
+	Context("For a pending pod of high priority fitting nowhere", func() {
+		var low, holding, high corev1.Pod
+
+		BeforeEach(func() {
+			low = upsized("low", 0, _test_mig_Identifier_string_3_20, _test_mig_Identifier_string_1_5)
+			holding = upsized("holding", 0, _test_mig_Identifier_string_2_10, _test_mig_Identifier_string_2_10)
+			holding.Annotations = nil
+			high = pending("high", 100, _test_mig_Identifier_string_2_10)
+		})
+
+		It("should evict the upsized pods of lower priority and take what they free", func() {
+			adaptations := adapter.AdaptPendingPodsWithContext(ctx, &high, []corev1.Node{_test_node1}, []corev1.Pod{low, holding, high})
+			Expect(adaptations).To(HaveLen(2))
+			Expect(adaptations[0].Evicted).To(BeTrue())
+			Expect(adaptations[0].Pod.Name).To(Equal("low"))
+			Expect(adaptations[1].Pod.Name).To(Equal("high"))
+			Expect(high.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_3_20)))
+			// what the evicted pod held is kept for the pod of high priority
+			Expect(adapter.reservations).To(HaveKey(reservationKey(&high)))
+		})
+
+		It("should not evict the pods of the same priority or higher", func() {
+			high.Spec.Priority = low.Spec.Priority
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, &high, []corev1.Node{_test_node1}, []corev1.Pod{low, holding, high})).To(BeEmpty())
+		})
+
+		It("should not evict pods which are not to be restored", func() {
+			adapter.policy.RestoreEnabled = false
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, &high, []corev1.Node{_test_node1}, []corev1.Pod{low, holding, high})).To(BeEmpty())
+		})
+
+		It("should only record the eviction in dry-run mode", func() {
+			adapter.policy.DryRun = true
+			Expect(adapter.AdaptPendingPodsWithContext(ctx, &high, []corev1.Node{_test_node1}, []corev1.Pod{low, holding, high})).To(HaveLen(2))
+			Expect(adapter.GetStatistics().WouldEvict).To(Equal(int64(1)))
+			Expect(adapter.reservations).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,159 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// how long the migs of a restarted pod are held for the pod recreated for it, in case it never gets bound
+	MIG_RESERVATION_TTL = 5 * time.Minute
+)
+
+// migReservation holds the migs the controller decided to give a restarted pod on a node,
+// until the pod recreated for it is bound and counted like any other
+type migReservation struct {
+	Node string
+	MIGs map[migIdentifier]resource.Quantity
+	// the pod restarted, the one recreated for it has another uid and the same pod key
+	UID    types.UID
+	PodKey types.NamespacedName
+	Since  time.Time
+}
+
+// reservationKey is the restarted pod itself, replicas of a workload share their pod key
+func reservationKey(pod *corev1.Pod) types.NamespacedName {
+	return types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+}
+
+// reserveMIGs holds the migs of the plan for the pod recreated for the pod
+func (a *Adapter) reserveMIGs(pod *corev1.Pod, plan *migPlan) {
+	if plan == nil {
+		return
+	}
+
+	a.rm.Lock()
+	defer a.rm.Unlock()
+
+	if a.reservations == nil {
+		a.reservations = make(map[types.NamespacedName]migReservation)
+	}
+
+	migs := make(map[migIdentifier]resource.Quantity)
+	for mig, q := range plan.Usage {
+		migs[mig] = q.DeepCopy()
+	}
+	a.reservations[reservationKey(pod)] = migReservation{
+		Node:   plan.Node,
+		MIGs:   migs,
+		UID:    pod.UID,
+		PodKey: a.genPodKey(pod),
+		Since:  time.Now(),
+	}
+}
+
+// subtractReservations takes the migs held for restarted pods from available, but not the ones of pods
+// recreated and bound since, or held for too long, which are only released with release
+func (a *Adapter) subtractReservations(available availableMIGMap, pods []corev1.Pod, release bool) {
+
+	a.rm.Lock()
+	defer a.rm.Unlock()
+
+	bound := make(map[types.NamespacedName][]*corev1.Pod)
+	for i := range pods {
+		if pods[i].Spec.NodeName != "" && !isPodTerminal(&pods[i]) {
+			podkey := a.genPodKey(&pods[i])
+			bound[podkey] = append(bound[podkey], &pods[i])
+		}
+	}
+
+	// the oldest reservations are released first, a bound pod stands for one restarted pod only
+	keys := make([]types.NamespacedName, 0, len(a.reservations))
+	for key := range a.reservations {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return a.reservations[keys[i]].Since.Before(a.reservations[keys[j]].Since) })
+
+	claimed := make(map[types.UID]bool)
+	for _, key := range keys {
+		r := a.reservations[key]
+		if time.Since(r.Since) > MIG_RESERVATION_TTL || claimRecreated(bound[r.PodKey], r, claimed) {
+			if release {
+				delete(a.reservations, key)
+			}
+			continue
+		}
+
+		migsOnNode, exists := available[r.Node]
+		if !exists {
+			continue
+		}
+		for mig, held := range r.MIGs {
+			q := migsOnNode.MIGs[mig]
+			q.Sub(held)
+			migsOnNode.MIGs[mig] = q
+		}
+	}
+}
+
+// claimRecreated tells if a pod created since the restart is bound and not already counted for another restart,
+// the replicas running before the restart are not. creation timestamps are in seconds
+func claimRecreated(bound []*corev1.Pod, r migReservation, claimed map[types.UID]bool) bool {
+	since := r.Since.Truncate(time.Second)
+	for _, pod := range bound {
+		if pod.UID == r.UID || claimed[pod.UID] || pod.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		claimed[pod.UID] = true
+		return true
+	}
+
+	return false
+}
+
+// isReservedFor tells if the migs are held for the pod recreated for this one, which is already restarted
+func (a *Adapter) isReservedFor(pod *corev1.Pod) bool {
+
+	a.rm.Lock()
+	defer a.rm.Unlock()
+
+	r, exists := a.reservations[reservationKey(pod)]
+	return exists && r.UID == pod.UID
+}
+
+// isWorkloadRestarting tells if another replica sharing the pod key of the pod was restarted
+// and the pod recreated for it is not bound yet
+func (a *Adapter) isWorkloadRestarting(pod *corev1.Pod) bool {
+
+	a.rm.Lock()
+	defer a.rm.Unlock()
+
+	podkey := a.genPodKey(pod)
+	for key, r := range a.reservations {
+		if r.PodKey == podkey && key != reservationKey(pod) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var _ = Describe("Reservations for Adapter", func() {
+
+	var adapter *Adapter
+
+	mig_2_10 := migIdentifier{Compute: 2, Memory: 10}
+
+	leftOnNode1 := func(pods []corev1.Pod) int64 {
+		q := adapter.detectAllAvailableMIGs([]corev1.Node{_test_node1}, pods)[_test_node1_name].MIGs[mig_2_10]
+		return q.Value()
+	}
+
+	boundTo2_10 := func(phase corev1.PodPhase) corev1.Pod {
+		pod := _test_pod1.DeepCopy()
+		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+			Limits:   corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+		}
+		pod.Status.Phase = phase
+		return *pod
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For pods bound to a node", func() {
+		It("should count them until they are done", func() {
+			Expect(leftOnNode1([]corev1.Pod{boundTo2_10(corev1.PodPending)})).To(BeZero())
+			Expect(leftOnNode1([]corev1.Pod{boundTo2_10(corev1.PodRunning)})).To(BeZero())
+
+			terminating := boundTo2_10(corev1.PodRunning)
+			now := metav1.Now()
+			terminating.DeletionTimestamp = &now
+			Expect(leftOnNode1([]corev1.Pod{terminating})).To(BeZero())
+
+			Expect(leftOnNode1([]corev1.Pod{boundTo2_10(corev1.PodSucceeded)})).To(Equal(int64(1)))
+			Expect(leftOnNode1([]corev1.Pod{boundTo2_10(corev1.PodFailed)})).To(Equal(int64(1)))
+		})
+	})
+
+	Context("For a pod restarted with larger migs", func() {
+		var pod *corev1.Pod
+
+		BeforeEach(func() {
+			pod = _test_podpending.DeepCopy()
+			pod.UID = "restarted"
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+		})
+
+		It("should hold its migs for the pod recreated for it", func() {
+			Expect(leftOnNode1(nil)).To(BeZero())
+
+			other := _test_podpending.DeepCopy()
+			other.Name = "other"
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, other, []corev1.Node{_test_node1}, nil)).To(BeTrue())
+			Expect(other.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName(_test_mig_Identifier_string_3_20)))
+		})
+
+		It("should release them once the recreated pod is bound", func() {
+			recreated := boundTo2_10(corev1.PodPending)
+			recreated.Name = pod.Name
+			recreated.Namespace = pod.Namespace
+			recreated.UID = "recreated"
+			recreated.CreationTimestamp = metav1.Now()
+			Expect(leftOnNode1([]corev1.Pod{recreated})).To(BeZero())
+			Expect(adapter.reservations).To(BeEmpty())
+			Expect(leftOnNode1(nil)).To(Equal(int64(1)))
+		})
+
+		It("should release them after a while if the recreated pod is never bound", func() {
+			r := adapter.reservations[reservationKey(pod)]
+			r.Since = time.Now().Add(-MIG_RESERVATION_TTL - time.Second)
+			adapter.reservations[reservationKey(pod)] = r
+
+			Expect(leftOnNode1(nil)).To(Equal(int64(1)))
+			Expect(adapter.reservations).To(BeEmpty())
+		})
+
+		It("should only count them when the capacity is scraped", func() {
+			left := adapter.countAvailableMIGs([]corev1.Node{_test_node1}, nil, false)[_test_node1_name].MIGs[mig_2_10]
+			Expect(left.Value()).To(BeZero())
+
+			r := adapter.reservations[reservationKey(pod)]
+			r.Since = time.Now().Add(-MIG_RESERVATION_TTL - time.Second)
+			adapter.reservations[reservationKey(pod)] = r
+
+			left = adapter.countAvailableMIGs([]corev1.Node{_test_node1}, nil, false)[_test_node1_name].MIGs[mig_2_10]
+			Expect(left.Value()).To(Equal(int64(1)))
+			Expect(adapter.reservations).To(HaveKey(reservationKey(pod)))
+		})
+	})
+
+	Context("For replicas of a workload restarted with larger migs", func() {
+		var replicas []*corev1.Pod
+
+		replica := func(name string, created time.Time) corev1.Pod {
+			controller := true
+			pod := boundTo2_10(corev1.PodRunning)
+			pod.Name = name
+			pod.UID = types.UID(name)
+			pod.GenerateName = "workload-"
+			pod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "workload", UID: "workload", Controller: &controller}}
+			pod.CreationTimestamp = metav1.NewTime(created)
+			return pod
+		}
+
+		BeforeEach(func() {
+			replicas = nil
+			for _, name := range []string{"workload-a", "workload-b"} {
+				pod := replica(name, time.Now())
+				pod.Spec.NodeName = ""
+				replicas = append(replicas, &pod)
+				adapter.reserveMIGs(&pod, &migPlan{Node: _test_node2_name, Usage: map[migIdentifier]resource.Quantity{mig_2_10: _test_quantity_1}})
+			}
+		})
+
+		It("should hold migs for each of them", func() {
+			Expect(adapter.reservations).To(HaveLen(2))
+			Expect(adapter.isReservedFor(replicas[0])).To(BeTrue())
+			Expect(adapter.isReservedFor(replicas[1])).To(BeTrue())
+		})
+
+		It("should not release them for replicas running before the restart", func() {
+			running := replica("workload-c", time.Now().Add(-time.Hour))
+			adapter.detectAllAvailableMIGs([]corev1.Node{_test_node1}, []corev1.Pod{running})
+			Expect(adapter.reservations).To(HaveLen(2))
+		})
+
+		It("should release one for each pod recreated since", func() {
+			recreated := replica("workload-d", time.Now())
+			adapter.detectAllAvailableMIGs([]corev1.Node{_test_node1}, []corev1.Pod{recreated})
+			Expect(adapter.reservations).To(HaveLen(1))
+
+			another := replica("workload-e", time.Now())
+			adapter.detectAllAvailableMIGs([]corev1.Node{_test_node1}, []corev1.Pod{recreated, another})
+			Expect(adapter.reservations).To(BeEmpty())
+		})
+	})
+
+	Context("In dry-run mode", func() {
+		It("should not hold anything", func() {
+			adapter.policy.DryRun = true
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, _test_podpending.DeepCopy(), []corev1.Node{_test_node1}, nil)).To(BeTrue())
+			Expect(adapter.reservations).To(BeEmpty())
+		})
+	})
+})
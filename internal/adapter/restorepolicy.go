@@ -0,0 +1,289 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// modes of the restore policy, of the NVidiaMIGAdapter resource or the restore-policy annotation of a pod
+	RESTORE_POLICY_ALWAYS             = "always"
+	RESTORE_POLICY_NEVER              = "never"
+	RESTORE_POLICY_CHECKPOINT_SAFE    = "checkpoint-safe"
+	RESTORE_POLICY_MAINTENANCE_WINDOW = "maintenance-window"
+	RESTORE_POLICY_WHEN_PENDING       = "when-pending"
+
+	ADAPTER_ANNOTATION_RESTORE_POLICY  = "restore-policy"
+	ADAPTER_ANNOTATION_CHECKPOINT_SAFE = "checkpoint-safe"
+
+	// bounds how far back the opening of a window is looked for
+	MAX_MAINTENANCE_WINDOW = 7 * 24 * time.Hour
+	// bounds how far ahead the next opening of a window is looked for, the restores are checked again then anyway
+	MAINTENANCE_WINDOW_LOOKAHEAD = 31 * 24 * time.Hour
+)
+
+// MaintenanceWindow opens on a cron schedule, in UTC, for a while
+type MaintenanceWindow struct {
+	Schedule string
+	Duration time.Duration
+
+	fields [5]uint64
+	// both the day of month and of week are restricted, either matches
+	eitherDay bool
+}
+
+// the minute, hour, day of month, month and day of week fields of a cron schedule
+var cronFieldBounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+func ParseMaintenanceWindow(schedule string, duration time.Duration) (MaintenanceWindow, error) {
+
+	w := MaintenanceWindow{Schedule: schedule, Duration: duration}
+	if duration <= 0 || duration > MAX_MAINTENANCE_WINDOW {
+		return w, fmt.Errorf("maintenance window %q lasts %s, not within (0, %s]", schedule, duration, MAX_MAINTENANCE_WINDOW)
+	}
+
+	fields := strings.Fields(schedule)
+	if len(fields) != len(cronFieldBounds) {
+		return w, fmt.Errorf("maintenance window %q has %d fields, not minute hour day month weekday", schedule, len(fields))
+	}
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronFieldBounds[i][0], cronFieldBounds[i][1])
+		if err != nil {
+			return w, fmt.Errorf("maintenance window %q: %w", schedule, err)
+		}
+		w.fields[i] = bits
+	}
+	// sunday is either 0 or 7
+	if w.fields[4]&(1<<7) != 0 {
+		w.fields[4] |= 1
+	}
+	w.eitherDay = fields[2] != "*" && fields[4] != "*"
+
+	return w, nil
+}
+
+// parseCronField sets the bit of every value of a comma separated list of *, n, n-m, each optionally stepped by /s
+func parseCronField(field string, first int, last int) (uint64, error) {
+
+	var values uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rng, s, found := strings.Cut(part, "/"); found {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part, step = rng, n
+		}
+
+		low, high := first, last
+		if part != "*" {
+			from, to, isRange := strings.Cut(part, "-")
+			var err error
+			if low, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", field)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", field)
+				}
+			} else if step > 1 {
+				high = last
+			}
+		}
+		if low < first || high > last || low > high {
+			return 0, fmt.Errorf("%q out of %d-%d", field, first, last)
+		}
+
+		for v := low; v <= high; v += step {
+			values |= 1 << uint(v)
+		}
+	}
+
+	return values, nil
+}
+
+// firesOnDay tells if the schedule fires on the day
+func (w MaintenanceWindow) firesOnDay(t time.Time) bool {
+
+	dom := w.fields[2]&(1<<uint(t.Day())) != 0
+	dow := w.fields[4]&(1<<uint(t.Weekday())) != 0
+	if w.eitherDay {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// IsOpen tells if the window opened within its duration before the time.
+// the openings are looked for backwards by month, day, hour and minute, skipping what the schedule does not match
+func (w MaintenanceWindow) IsOpen(t time.Time) bool {
+
+	t = t.UTC().Truncate(time.Minute)
+	for open := t; t.Sub(open) < w.Duration; {
+		y, m, d := open.Date()
+		h := open.Hour()
+		switch {
+		case w.fields[3]&(1<<uint(m)) == 0:
+			open = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case !w.firesOnDay(open):
+			open = time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+		case w.fields[1]&(1<<uint(h)) == 0:
+			open = time.Date(y, m, d, h, 0, 0, 0, time.UTC).Add(-time.Minute)
+		default:
+			minute, found := prevCronValue(w.fields[0], open.Minute())
+			if !found {
+				open = time.Date(y, m, d, h, 0, 0, 0, time.UTC).Add(-time.Minute)
+				continue
+			}
+			return t.Sub(time.Date(y, m, d, h, minute, 0, 0, time.UTC)) < w.Duration
+		}
+	}
+
+	return false
+}
+
+// nextOpening returns the next minute after the time the window opens, or the end of the lookahead.
+// the openings are looked for forwards by month, day, hour and minute, skipping what the schedule does not match
+func (w MaintenanceWindow) nextOpening(t time.Time) time.Time {
+
+	t = t.UTC().Truncate(time.Minute)
+	for open := t.Add(time.Minute); open.Sub(t) < MAINTENANCE_WINDOW_LOOKAHEAD; {
+		y, m, d := open.Date()
+		h := open.Hour()
+		switch {
+		case w.fields[3]&(1<<uint(m)) == 0:
+			open = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !w.firesOnDay(open):
+			open = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case w.fields[1]&(1<<uint(h)) == 0:
+			open = time.Date(y, m, d, h+1, 0, 0, 0, time.UTC)
+		default:
+			minute, found := nextCronValue(w.fields[0], open.Minute())
+			if !found {
+				open = time.Date(y, m, d, h+1, 0, 0, 0, time.UTC)
+				continue
+			}
+			if open = time.Date(y, m, d, h, minute, 0, 0, time.UTC); open.Sub(t) < MAINTENANCE_WINDOW_LOOKAHEAD {
+				return open
+			}
+		}
+	}
+
+	return t.Add(MAINTENANCE_WINDOW_LOOKAHEAD)
+}
+
+// nextCronValue returns the lowest value of the field from the value on, false if there is none
+func nextCronValue(field uint64, from int) (int, bool) {
+	rest := field >> uint(from)
+	if rest == 0 {
+		return 0, false
+	}
+
+	return from + bits.TrailingZeros64(rest), true
+}
+
+// prevCronValue returns the highest value of the field up to the value, false if there is none
+func prevCronValue(field uint64, to int) (int, bool) {
+	rest := field & (1<<uint(to+1) - 1)
+	if rest == 0 {
+		return 0, false
+	}
+
+	return 63 - bits.LeadingZeros64(rest), true
+}
+
+// nextMaintenanceWindow returns when the next of the windows opens, zero if there is none
+func nextMaintenanceWindow(windows []MaintenanceWindow, t time.Time) time.Time {
+	next := time.Time{}
+	for _, w := range windows {
+		next = earlier(next, w.nextOpening(t))
+	}
+
+	return next
+}
+
+func isInMaintenanceWindow(windows []MaintenanceWindow, t time.Time) bool {
+	for _, w := range windows {
+		if w.IsOpen(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getRestorePolicy returns the restore-policy annotation of the pod, or the mode of the policy.
+// unknown values are taken as never, a running pod is rather kept than restarted against the will of its owner
+func (a *Adapter) getRestorePolicy(pod *corev1.Pod) string {
+
+	value, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_POLICY]
+	if !exists {
+		value = a.GetPolicy().RestorePolicy
+	}
+
+	switch value {
+	case "":
+		return RESTORE_POLICY_ALWAYS
+	case RESTORE_POLICY_ALWAYS, RESTORE_POLICY_NEVER, RESTORE_POLICY_CHECKPOINT_SAFE, RESTORE_POLICY_MAINTENANCE_WINDOW, RESTORE_POLICY_WHEN_PENDING:
+		return value
+	}
+
+	aplog.Info("unknown restore policy, pod not restored", "name", pod.Name, "namespace", pod.Namespace, "policy", value)
+	return RESTORE_POLICY_NEVER
+}
+
+// isRestorePermitted tells if the restore policy lets the pod be restarted now, when-pending is decided by the migs it gives back
+func isRestorePermitted(pod *corev1.Pod, mode string, inWindow bool) bool {
+
+	switch mode {
+	case RESTORE_POLICY_ALWAYS, RESTORE_POLICY_WHEN_PENDING:
+		return true
+	case RESTORE_POLICY_CHECKPOINT_SAFE:
+		return pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_CHECKPOINT_SAFE] == "true"
+	case RESTORE_POLICY_MAINTENANCE_WINDOW:
+		return inWindow
+	}
+
+	return false
+}
+
+// getPendingMIGDemands returns the migs unscheduled pods wait on
+func (a *Adapter) getPendingMIGDemands(pods []corev1.Pod) map[migIdentifier]bool {
+
+	pending := make(map[migIdentifier]bool)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Spec.NodeName != "" || pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodPending {
+			continue
+		}
+		resources, sequential := a.getContainerResources(&pod.Spec)
+		for _, d := range a.getMIGDemands(resources, sequential) {
+			pending[d.MIG] = true
+		}
+	}
+
+	return pending
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Restore policy for Adapter", func() {
+
+	Context("For maintenance windows", func() {
+		It("should reject invalid schedules and durations", func() {
+			for _, schedule := range []string{"", "0 2 * *", "60 2 * * *", "0 2-1 * * *", "0 */0 * * *", "0 2 * * mon"} {
+				_, err := ParseMaintenanceWindow(schedule, time.Hour)
+				Expect(err).To(HaveOccurred(), schedule)
+			}
+			_, err := ParseMaintenanceWindow("0 2 * * *", 0)
+			Expect(err).To(HaveOccurred())
+			_, err = ParseMaintenanceWindow("0 2 * * *", 2*MAX_MAINTENANCE_WINDOW)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should be open for its duration after its schedule fires", func() {
+			w, err := ParseMaintenanceWindow("30 2 * * 6", 2*time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+
+			saturday := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+			Expect(w.IsOpen(saturday.Add(2*time.Hour + 29*time.Minute))).To(BeFalse())
+			Expect(w.IsOpen(saturday.Add(2*time.Hour + 30*time.Minute))).To(BeTrue())
+			Expect(w.IsOpen(saturday.Add(4*time.Hour + 29*time.Minute))).To(BeTrue())
+			Expect(w.IsOpen(saturday.Add(4*time.Hour + 30*time.Minute))).To(BeFalse())
+			Expect(w.IsOpen(saturday.Add(24*time.Hour + 2*time.Hour + 30*time.Minute))).To(BeFalse())
+		})
+
+		It("should tell when it opens next", func() {
+			w, err := ParseMaintenanceWindow("30 2 * * 6", 2*time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+
+			saturday := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+			Expect(w.nextOpening(saturday)).To(Equal(saturday.Add(2*time.Hour + 30*time.Minute)))
+			Expect(w.nextOpening(saturday.Add(3 * time.Hour))).To(Equal(saturday.Add(7*24*time.Hour + 2*time.Hour + 30*time.Minute)))
+
+			never, err := ParseMaintenanceWindow("0 0 30 2 *", time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(never.nextOpening(saturday)).To(Equal(saturday.Add(MAINTENANCE_WINDOW_LOOKAHEAD)))
+			Expect(nextMaintenanceWindow([]MaintenanceWindow{never, w}, saturday)).To(Equal(saturday.Add(2*time.Hour + 30*time.Minute)))
+		})
+
+		It("should find the same openings as a scan of every minute", func() {
+			firesAt := func(w MaintenanceWindow, t time.Time) bool {
+				return w.fields[0]&(1<<uint(t.Minute())) != 0 && w.fields[1]&(1<<uint(t.Hour())) != 0 &&
+					w.fields[3]&(1<<uint(t.Month())) != 0 && w.firesOnDay(t)
+			}
+
+			start := time.Date(2024, time.January, 30, 23, 0, 0, 0, time.UTC)
+			for _, schedule := range []string{"30 2 * * 6", "*/20 22-23 29 2 *", "0 0 1 * 1", "59 23 31 12 *"} {
+				w, err := ParseMaintenanceWindow(schedule, 3*time.Hour)
+				Expect(err).NotTo(HaveOccurred())
+
+				for t := start; t.Before(start.Add(40 * 24 * time.Hour)); t = t.Add(97 * time.Minute) {
+					open := false
+					for o := t; t.Sub(o) < w.Duration; o = o.Add(-time.Minute) {
+						open = open || firesAt(w, o)
+					}
+					Expect(w.IsOpen(t)).To(Equal(open), fmt.Sprintf("%s at %s", schedule, t))
+
+					next := t.Add(MAINTENANCE_WINDOW_LOOKAHEAD)
+					for o := t.Add(time.Minute); o.Before(next); o = o.Add(time.Minute) {
+						if firesAt(w, o) {
+							next = o
+							break
+						}
+					}
+					Expect(w.nextOpening(t)).To(Equal(next), fmt.Sprintf("%s after %s", schedule, t))
+				}
+			}
+		})
+
+		It("should be open all along for a weekly window of a week", func() {
+			w, err := ParseMaintenanceWindow("0 0 * * 0", MAX_MAINTENANCE_WINDOW)
+			Expect(err).NotTo(HaveOccurred())
+
+			sunday := time.Date(2024, time.June, 2, 0, 0, 0, 0, time.UTC)
+			for t := sunday; t.Before(sunday.Add(2 * MAX_MAINTENANCE_WINDOW)); t = t.Add(time.Hour) {
+				Expect(w.IsOpen(t)).To(BeTrue(), t.String())
+			}
+		})
+
+		It("should match lists, ranges and steps, and either day once both are restricted", func() {
+			w, err := ParseMaintenanceWindow("*/15 1-3,22 1 * 0", time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+
+			// 2024-06-01 is a saturday, 2024-06-02 a sunday
+			Expect(w.IsOpen(time.Date(2024, time.June, 1, 2, 45, 0, 0, time.UTC))).To(BeTrue())
+			Expect(w.IsOpen(time.Date(2024, time.June, 1, 2, 40, 0, 0, time.UTC))).To(BeFalse())
+			Expect(w.IsOpen(time.Date(2024, time.June, 2, 22, 0, 0, 0, time.UTC))).To(BeTrue())
+			Expect(w.IsOpen(time.Date(2024, time.June, 3, 22, 0, 0, 0, time.UTC))).To(BeFalse())
+			Expect(w.IsOpen(time.Date(2024, time.June, 3, 4, 0, 0, 0, time.UTC))).To(BeFalse())
+		})
+	})
+
+	Context("For an upsized pod", func() {
+		var adapter *Adapter
+		var pod *corev1.Pod
+
+		restore := func(pods ...corev1.Pod) []*corev1.Pod {
+			return adapter.CheckAndRestorePodsWithContext(ctx, []corev1.Node{_test_node2}, append([]corev1.Pod{*pod}, pods...))
+		}
+
+		setRestorePolicy := func(mode string, windows ...MaintenanceWindow) {
+			policy := DefaultPolicy()
+			policy.RestorePolicy = mode
+			policy.MaintenanceWindows = windows
+			adapter.SetPolicy(policy)
+		}
+
+		BeforeEach(func() {
+			adapter = &Adapter{
+				rules:  NewMemoryRuleStore(),
+				policy: DefaultPolicy(),
+			}
+
+			original := PodResources{
+				_test_container1_name: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+					Limits:   corev1.ResourceList{_test_mig_Identifier_string_1_5: _test_quantity_1},
+				},
+			}
+			bytes, err := json.Marshal(original)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod = _test_pod1.DeepCopy()
+			pod.Name = "restorepolicy"
+			pod.UID = "restorepolicy"
+			pod.Spec.NodeName = _test_node2_name
+			pod.Annotations = map[string]string{ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL: string(bytes)}
+			pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+				Limits:   corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+			}
+		})
+
+		It("should be restored by default", func() {
+			Expect(restore()).To(HaveLen(1))
+		})
+
+		It("should never be restored, unless the pod says otherwise", func() {
+			setRestorePolicy(RESTORE_POLICY_NEVER)
+			Expect(restore()).To(BeEmpty())
+
+			pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_POLICY] = RESTORE_POLICY_ALWAYS
+			Expect(restore()).To(HaveLen(1))
+
+			pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_POLICY] = "whenever"
+			Expect(restore()).To(BeEmpty())
+		})
+
+		It("should only be restored once it declares to be checkpoint-safe", func() {
+			pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_RESTORE_POLICY] = RESTORE_POLICY_CHECKPOINT_SAFE
+			Expect(restore()).To(BeEmpty())
+
+			pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_CHECKPOINT_SAFE] = "true"
+			Expect(restore()).To(HaveLen(1))
+		})
+
+		It("should only be restored within a maintenance window", func() {
+			now := time.Now().UTC()
+			closed, err := ParseMaintenanceWindow(fmt.Sprintf("0 %d * * *", now.Add(6*time.Hour).Hour()), time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+			open, err := ParseMaintenanceWindow(fmt.Sprintf("* %d * * *", now.Hour()), time.Hour)
+			Expect(err).NotTo(HaveOccurred())
+
+			setRestorePolicy(RESTORE_POLICY_MAINTENANCE_WINDOW, closed)
+			Expect(restore()).To(BeEmpty())
+			// checked again once the window opens
+			Expect(adapter.NextRestoreCheck()).To(BeNumerically(">", 4*time.Hour))
+			Expect(adapter.NextRestoreCheck()).To(BeZero())
+
+			setRestorePolicy(RESTORE_POLICY_MAINTENANCE_WINDOW, closed, open)
+			Expect(restore()).To(HaveLen(1))
+		})
+
+		It("should only be restored when another pod waits on its upsized mig", func() {
+			setRestorePolicy(RESTORE_POLICY_WHEN_PENDING)
+			waiting := _test_podpending.DeepCopy()
+			waiting.Name = "waiting"
+			Expect(restore(*waiting)).To(BeEmpty())
+
+			waiting.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+				Limits:   corev1.ResourceList{_test_mig_Identifier_string_2_10: _test_quantity_1},
+			}
+			Expect(restore(*waiting)).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,573 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gpuv1alpha1 "github.com/IBM/mig-adapter/api/v1alpha1"
+)
+
+const (
+	RULESTORE_MEMORY    = "memory"
+	RULESTORE_CONFIGMAP = "configmap"
+	RULESTORE_CRD       = "crd"
+
+	// the config maps of the pods, and their keys holding the rules and the bare pod
+	RULESTORE_CONFIGMAP_PREFIX    = "mig-adapter-rule-"
+	RULESTORE_CONFIGMAP_KEY_RULES = "rules"
+	RULESTORE_CONFIGMAP_KEY_POD   = "pod"
+	// set on the config maps of the rule store, to cache and prune only them
+	LABELKEY_RULESTORE = ADAPTER_ANNOTATION_PREFIX + "rule-store"
+
+	// how long the keys written by this process are read past the cache, which may not have seen the writes yet
+	RULESTORE_RECENT_WRITE_WINDOW = 10 * time.Second
+
+	// rules and pods not applied by then are left behind by workloads deleted or scaled down since,
+	// they are pruned as often
+	RULESTORE_ENTRY_TTL    = time.Hour
+	RULESTORE_PRUNE_PERIOD = 10 * time.Minute
+
+	// when a rule store object was last written, and the pod a config map is for
+	ADAPTER_ANNOTATION_STORED = "stored"
+	ADAPTER_ANNOTATION_POD    = "pod"
+)
+
+// RuleStore keeps the rules generated by the controller until the webhook applies them to the recreated pod
+type RuleStore interface {
+	// GetRules returns nil if there is no rule for the pod
+	GetRules(ctx context.Context, podkey types.NamespacedName) (PodResources, error)
+	StoreRules(ctx context.Context, podkey types.NamespacedName, rules PodResources) error
+	RemoveRules(ctx context.Context, podkey types.NamespacedName) error
+
+	// GetPod returns nil if no bare pod is kept for recreation
+	GetPod(ctx context.Context, podkey types.NamespacedName) (*corev1.Pod, error)
+	StorePod(ctx context.Context, podkey types.NamespacedName, pod *corev1.Pod) error
+	RemovePod(ctx context.Context, podkey types.NamespacedName) error
+
+	// Prune removes the rules and pods last stored before the time
+	Prune(ctx context.Context, before time.Time) error
+}
+
+// memoryRuleStore is local to the process, rules are lost on restart
+type memoryRuleStore struct {
+	m      sync.RWMutex
+	rules  map[types.NamespacedName]PodResources
+	pods   map[types.NamespacedName]*corev1.Pod
+	stored map[types.NamespacedName]time.Time
+}
+
+func NewMemoryRuleStore() RuleStore {
+	return &memoryRuleStore{
+		rules:  make(map[types.NamespacedName]PodResources),
+		pods:   make(map[types.NamespacedName]*corev1.Pod),
+		stored: make(map[types.NamespacedName]time.Time),
+	}
+}
+
+func (s *memoryRuleStore) GetRules(ctx context.Context, podkey types.NamespacedName) (PodResources, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return s.rules[podkey], nil
+}
+
+func (s *memoryRuleStore) StoreRules(ctx context.Context, podkey types.NamespacedName, rules PodResources) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.rules[podkey] = rules
+	s.stored[podkey] = time.Now()
+	return nil
+}
+
+func (s *memoryRuleStore) RemoveRules(ctx context.Context, podkey types.NamespacedName) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.rules, podkey)
+	if _, exists := s.pods[podkey]; !exists {
+		delete(s.stored, podkey)
+	}
+	return nil
+}
+
+func (s *memoryRuleStore) GetPod(ctx context.Context, podkey types.NamespacedName) (*corev1.Pod, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	return s.pods[podkey].DeepCopy(), nil
+}
+
+func (s *memoryRuleStore) StorePod(ctx context.Context, podkey types.NamespacedName, pod *corev1.Pod) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.pods[podkey] = pod.DeepCopy()
+	s.stored[podkey] = time.Now()
+	return nil
+}
+
+func (s *memoryRuleStore) RemovePod(ctx context.Context, podkey types.NamespacedName) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	delete(s.pods, podkey)
+	if _, exists := s.rules[podkey]; !exists {
+		delete(s.stored, podkey)
+	}
+	return nil
+}
+
+func (s *memoryRuleStore) Prune(ctx context.Context, before time.Time) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for podkey, stored := range s.stored {
+		if stored.Before(before) {
+			delete(s.rules, podkey)
+			delete(s.pods, podkey)
+			delete(s.stored, podkey)
+		}
+	}
+	return nil
+}
+
+// recentWrites remembers the keys written by this process lately, so they are read past the cache.
+// most pods have no rule, reading all the others from the cache keeps the webhook off the api server
+type recentWrites struct {
+	m       sync.Mutex
+	written map[types.NamespacedName]time.Time
+}
+
+func (w *recentWrites) mark(key types.NamespacedName) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	now := time.Now()
+	if w.written == nil {
+		w.written = make(map[types.NamespacedName]time.Time)
+	}
+	for k, t := range w.written {
+		if now.Sub(t) > RULESTORE_RECENT_WRITE_WINDOW {
+			delete(w.written, k)
+		}
+	}
+	w.written[key] = now
+}
+
+func (w *recentWrites) isRecent(key types.NamespacedName) bool {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	t, exists := w.written[key]
+	return exists && time.Since(t) <= RULESTORE_RECENT_WRITE_WINDOW
+}
+
+// configMapRuleStore keeps the rules and the bare pod to recreate of each pod in a config map of its own,
+// so they survive restarts and leader changes, and pods are written to concurrently without conflicts.
+// they are read from the cache, and written with the client
+type configMapRuleStore struct {
+	client.Client
+	cache     client.Reader
+	namespace string
+	recent    recentWrites
+}
+
+func NewConfigMapRuleStore(cli client.Client, cache client.Reader, namespace string) RuleStore {
+	return &configMapRuleStore{
+		Client:    cli,
+		cache:     cache,
+		namespace: namespace,
+	}
+}
+
+// the config maps of the pods of all namespaces are kept in one, the namespace of the pod is part of the name
+func (s *configMapRuleStore) configMapKey(podkey types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      hashedName(RULESTORE_CONFIGMAP_PREFIX+podkey.Namespace+"."+podkey.Name, podkey.String()),
+	}
+}
+
+func (s *configMapRuleStore) GetRules(ctx context.Context, podkey types.NamespacedName) (PodResources, error) {
+	rules := PodResources{}
+	found, err := s.get(ctx, podkey, RULESTORE_CONFIGMAP_KEY_RULES, &rules)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+func (s *configMapRuleStore) StoreRules(ctx context.Context, podkey types.NamespacedName, rules PodResources) error {
+	return s.store(ctx, podkey, RULESTORE_CONFIGMAP_KEY_RULES, rules)
+}
+
+func (s *configMapRuleStore) RemoveRules(ctx context.Context, podkey types.NamespacedName) error {
+	return s.update(ctx, podkey, func(data map[string]string) {
+		delete(data, RULESTORE_CONFIGMAP_KEY_RULES)
+	})
+}
+
+func (s *configMapRuleStore) GetPod(ctx context.Context, podkey types.NamespacedName) (*corev1.Pod, error) {
+	pod := &corev1.Pod{}
+	found, err := s.get(ctx, podkey, RULESTORE_CONFIGMAP_KEY_POD, pod)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return pod, nil
+}
+
+func (s *configMapRuleStore) StorePod(ctx context.Context, podkey types.NamespacedName, pod *corev1.Pod) error {
+	return s.store(ctx, podkey, RULESTORE_CONFIGMAP_KEY_POD, pod)
+}
+
+func (s *configMapRuleStore) RemovePod(ctx context.Context, podkey types.NamespacedName) error {
+	return s.update(ctx, podkey, func(data map[string]string) {
+		delete(data, RULESTORE_CONFIGMAP_KEY_POD)
+	})
+}
+
+// getConfigMap reads from the cache, but from the api server for the config maps this process just wrote
+func (s *configMapRuleStore) getConfigMap(ctx context.Context, key types.NamespacedName, cm *corev1.ConfigMap) error {
+	if s.cache != nil && !s.recent.isRecent(key) {
+		return s.cache.Get(ctx, key, cm)
+	}
+	return s.Get(ctx, key, cm)
+}
+
+func (s *configMapRuleStore) get(ctx context.Context, podkey types.NamespacedName, dataKey string, obj interface{}) (bool, error) {
+	cm := &corev1.ConfigMap{}
+	err := s.getConfigMap(ctx, s.configMapKey(podkey), cm)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	data, exists := cm.Data[dataKey]
+	if !exists || cm.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_POD] != podkey.String() {
+		return false, nil
+	}
+
+	return true, json.Unmarshal([]byte(data), obj)
+}
+
+func (s *configMapRuleStore) store(ctx context.Context, podkey types.NamespacedName, dataKey string, obj interface{}) error {
+	bytes, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, podkey, func(data map[string]string) {
+		data[dataKey] = string(bytes)
+	})
+}
+
+// Prune deletes the config maps of the cache last stored before the time
+func (s *configMapRuleStore) Prune(ctx context.Context, before time.Time) error {
+	var reader client.Reader = s.Client
+	if s.cache != nil {
+		reader = s.cache
+	}
+
+	cms := &corev1.ConfigMapList{}
+	if err := reader.List(ctx, cms, client.InNamespace(s.namespace), client.MatchingLabels{LABELKEY_RULESTORE: RULESTORE_CONFIGMAP}); err != nil {
+		return err
+	}
+
+	for i := range cms.Items {
+		cm := &cms.Items[i]
+		if !getStoredTime(&cm.ObjectMeta).Before(before) {
+			continue
+		}
+
+		// a config map stored again since is left alone
+		precondition := client.Preconditions{UID: &cm.UID, ResourceVersion: &cm.ResourceVersion}
+		if err := s.Delete(ctx, cm, precondition); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update applies the change to the latest config map of the pod, the config map is created if it does not exist yet,
+// and deleted once it neither has rules nor a pod to recreate
+func (s *configMapRuleStore) update(ctx context.Context, podkey types.NamespacedName, change func(data map[string]string)) error {
+	key := s.configMapKey(podkey)
+
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+
+	s.recent.mark(key)
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		cm := &corev1.ConfigMap{}
+		err := s.getConfigMap(ctx, key, cm)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		exists := err == nil
+
+		if cm.Data == nil || cm.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_POD] != podkey.String() {
+			cm.Data = make(map[string]string)
+		}
+		change(cm.Data)
+
+		switch {
+		case !exists && len(cm.Data) == 0:
+			return nil
+		case len(cm.Data) == 0:
+			precondition := client.Preconditions{UID: &cm.UID, ResourceVersion: &cm.ResourceVersion}
+			return client.IgnoreNotFound(s.Delete(ctx, cm, precondition))
+		}
+
+		if !exists {
+			cm.ObjectMeta = metav1.ObjectMeta{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+				Labels:    map[string]string{LABELKEY_RULESTORE: RULESTORE_CONFIGMAP},
+			}
+		}
+		setStoredTime(&cm.ObjectMeta, time.Now())
+		cm.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_POD] = podkey.String()
+		if !exists {
+			return s.Create(ctx, cm)
+		}
+		return s.Update(ctx, cm)
+	})
+}
+
+// hashedName cuts the name short enough to be a valid object name along with the hash of the key, which keeps
+// names distinct. generate names end with '-', and a name cut short may end with '.', neither is allowed before
+// the '-' of the hash
+func hashedName(name string, key string) string {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	name = strings.TrimRightFunc(name, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9')
+	})
+
+	return fmt.Sprintf("%s-%08x", name, h.Sum32())
+}
+
+// getStoredTime returns when the object was last stored, when it was created if it does not tell
+func getStoredTime(meta *metav1.ObjectMeta) time.Time {
+	if t, err := time.Parse(time.RFC3339, meta.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_STORED]); err == nil {
+		return t
+	}
+	return meta.CreationTimestamp.Time
+}
+
+func setStoredTime(meta *metav1.ObjectMeta, t time.Time) {
+	if meta.Annotations == nil {
+		meta.Annotations = make(map[string]string)
+	}
+	meta.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_STORED] = t.UTC().Format(time.RFC3339)
+}
+
+// crdRuleStore keeps the rules in MIGAdaptationRule resources, watched by every replica,
+// so any webhook replica can apply the rules created by the leader
+type crdRuleStore struct {
+	client.Client
+	// reader bypasses the cache for rules this replica wrote but its informer may not have seen yet
+	reader client.Reader
+	recent recentWrites
+}
+
+func NewCRDRuleStore(cli client.Client, reader client.Reader) RuleStore {
+	return &crdRuleStore{
+		Client: cli,
+		reader: reader,
+	}
+}
+
+// the hash keeps the rules of names cut short distinct
+func (s *crdRuleStore) ruleKey(podkey types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: podkey.Namespace,
+		Name:      hashedName(podkey.Name, podkey.Name),
+	}
+}
+
+// getRule reads from the cache, but from the api server for the rules this replica just wrote
+func (s *crdRuleStore) getRule(ctx context.Context, key types.NamespacedName, rule *gpuv1alpha1.MIGAdaptationRule) error {
+	if s.reader != nil && s.recent.isRecent(key) {
+		return s.reader.Get(ctx, key, rule)
+	}
+	return s.Get(ctx, key, rule)
+}
+
+func (s *crdRuleStore) GetRules(ctx context.Context, podkey types.NamespacedName) (PodResources, error) {
+	rule := &gpuv1alpha1.MIGAdaptationRule{}
+	err := s.getRule(ctx, s.ruleKey(podkey), rule)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if rule.Spec.PodName != podkey.Name {
+		return nil, nil
+	}
+
+	return PodResources(rule.Spec.Containers), nil
+}
+
+func (s *crdRuleStore) StoreRules(ctx context.Context, podkey types.NamespacedName, rules PodResources) error {
+	return s.update(ctx, podkey, func(spec *gpuv1alpha1.MIGAdaptationRuleSpec) {
+		spec.Containers = rules
+	})
+}
+
+func (s *crdRuleStore) RemoveRules(ctx context.Context, podkey types.NamespacedName) error {
+	return s.update(ctx, podkey, func(spec *gpuv1alpha1.MIGAdaptationRuleSpec) {
+		spec.Containers = nil
+	})
+}
+
+func (s *crdRuleStore) GetPod(ctx context.Context, podkey types.NamespacedName) (*corev1.Pod, error) {
+	rule := &gpuv1alpha1.MIGAdaptationRule{}
+	err := s.getRule(ctx, s.ruleKey(podkey), rule)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if rule.Spec.PodName != podkey.Name || rule.Spec.Pod == nil {
+		return nil, nil
+	}
+
+	pod := &corev1.Pod{}
+	err = json.Unmarshal(rule.Spec.Pod.Raw, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	return pod, nil
+}
+
+// the pod is an embedded resource of the rule, the api server requires its apiVersion and kind
+func (s *crdRuleStore) StorePod(ctx context.Context, podkey types.NamespacedName, pod *corev1.Pod) error {
+	embedded := *pod
+	embedded.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	bytes, err := json.Marshal(&embedded)
+	if err != nil {
+		return err
+	}
+
+	return s.update(ctx, podkey, func(spec *gpuv1alpha1.MIGAdaptationRuleSpec) {
+		spec.Pod = &runtime.RawExtension{Raw: bytes}
+	})
+}
+
+func (s *crdRuleStore) RemovePod(ctx context.Context, podkey types.NamespacedName) error {
+	return s.update(ctx, podkey, func(spec *gpuv1alpha1.MIGAdaptationRuleSpec) {
+		spec.Pod = nil
+	})
+}
+
+// Prune deletes the rules of the cache last stored before the time
+func (s *crdRuleStore) Prune(ctx context.Context, before time.Time) error {
+	rules := &gpuv1alpha1.MIGAdaptationRuleList{}
+	if err := s.List(ctx, rules); err != nil {
+		return err
+	}
+
+	for i := range rules.Items {
+		rule := &rules.Items[i]
+		if !getStoredTime(&rule.ObjectMeta).Before(before) {
+			continue
+		}
+
+		// a rule stored again since is left alone
+		precondition := client.Preconditions{UID: &rule.UID, ResourceVersion: &rule.ResourceVersion}
+		if err := s.Delete(ctx, rule, precondition); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// update applies the change to the latest rule of the pod, the rule is created if it does not exist yet,
+// and deleted once it neither has resources nor a pod to recreate
+func (s *crdRuleStore) update(ctx context.Context, podkey types.NamespacedName, change func(spec *gpuv1alpha1.MIGAdaptationRuleSpec)) error {
+	key := s.ruleKey(podkey)
+
+	retriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+
+	s.recent.mark(key)
+	return retry.OnError(retry.DefaultRetry, retriable, func() error {
+		rule := &gpuv1alpha1.MIGAdaptationRule{}
+		err := s.getRule(ctx, key, rule)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		exists := err == nil
+
+		rule.Spec.PodName = podkey.Name
+		change(&rule.Spec)
+		empty := len(rule.Spec.Containers) == 0 && rule.Spec.Pod == nil
+
+		switch {
+		case !exists && empty:
+			return nil
+		case empty:
+			return client.IgnoreNotFound(s.Delete(ctx, rule))
+		}
+
+		if !exists {
+			rule.ObjectMeta = metav1.ObjectMeta{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+			}
+		}
+		setStoredTime(&rule.ObjectMeta, time.Now())
+		if !exists {
+			return s.Create(ctx, rule)
+		}
+		return s.Update(ctx, rule)
+	})
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gpuv1alpha1 "github.com/IBM/mig-adapter/api/v1alpha1"
+)
+
+var _ = Describe("Rule Store", func() {
+
+	podkey := types.NamespacedName{
+		Namespace: "default",
+		Name:      _test_pod2_genname,
+	}
+	rules := PodResources{
+		_test_container1_name: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			},
+			Limits: corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			},
+		},
+	}
+
+	testRuleStore := func(store RuleStore) {
+		stored, err := store.GetRules(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored).To(BeNil())
+
+		Expect(store.StoreRules(ctx, podkey, rules)).To(Succeed())
+		stored, err = store.GetRules(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored[_test_container1_name].Requests).To(BeEquivalentTo(rules[_test_container1_name].Requests))
+
+		Expect(store.RemoveRules(ctx, podkey)).To(Succeed())
+		stored, err = store.GetRules(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored).To(BeNil())
+
+		pod, err := store.GetPod(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod).To(BeNil())
+
+		Expect(store.StorePod(ctx, podkey, _test_pod1.DeepCopy())).To(Succeed())
+		pod, err = store.GetPod(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod).NotTo(BeNil())
+		Expect(pod.Name).To(Equal(_test_pod1.Name))
+		Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_pod1.Spec.Containers[0].Resources))
+
+		Expect(store.RemovePod(ctx, podkey)).To(Succeed())
+		pod, err = store.GetPod(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod).To(BeNil())
+
+		Expect(store.StoreRules(ctx, podkey, rules)).To(Succeed())
+		Expect(store.StorePod(ctx, podkey, _test_pod1.DeepCopy())).To(Succeed())
+		Expect(store.Prune(ctx, time.Now().Add(-time.Minute))).To(Succeed())
+		stored, err = store.GetRules(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored).NotTo(BeNil())
+
+		Expect(store.Prune(ctx, time.Now().Add(time.Minute))).To(Succeed())
+		stored, err = store.GetRules(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored).To(BeNil())
+		pod, err = store.GetPod(ctx, podkey)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod).To(BeNil())
+	}
+
+	Context("For the memory rule store", func() {
+		It("should store, retrieve and remove rules and pods", func() {
+			testRuleStore(NewMemoryRuleStore())
+		})
+	})
+
+	Context("For the config map rule store", func() {
+		It("should store, retrieve and remove rules and pods", func() {
+			testRuleStore(NewConfigMapRuleStore(cli, cli, "default"))
+		})
+
+		It("should keep rules for a new store instance", func() {
+			Expect(NewConfigMapRuleStore(cli, cli, "default").StoreRules(ctx, podkey, rules)).To(Succeed())
+
+			restarted := NewConfigMapRuleStore(cli, cli, "default")
+			stored, err := restarted.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveKey(_test_container1_name))
+
+			Expect(restarted.RemoveRules(ctx, podkey)).To(Succeed())
+		})
+
+		It("should keep each pod in a config map of its own, deleted once empty", func() {
+			store := NewConfigMapRuleStore(cli, cli, "default")
+			other := types.NamespacedName{Namespace: "other", Name: podkey.Name}
+			Expect(store.StoreRules(ctx, podkey, rules)).To(Succeed())
+			Expect(store.StorePod(ctx, other, _test_pod1.DeepCopy())).To(Succeed())
+
+			cms := &corev1.ConfigMapList{}
+			Expect(cli.List(ctx, cms, client.InNamespace("default"), client.MatchingLabels{LABELKEY_RULESTORE: RULESTORE_CONFIGMAP})).To(Succeed())
+			Expect(cms.Items).To(HaveLen(2))
+			pod, err := store.GetPod(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod).To(BeNil())
+
+			Expect(store.RemoveRules(ctx, podkey)).To(Succeed())
+			Expect(store.RemovePod(ctx, other)).To(Succeed())
+			Expect(cli.List(ctx, cms, client.InNamespace("default"), client.MatchingLabels{LABELKEY_RULESTORE: RULESTORE_CONFIGMAP})).To(Succeed())
+			Expect(cms.Items).To(BeEmpty())
+		})
+
+		It("should name config maps validly for long generate names with dots", func() {
+			store := NewConfigMapRuleStore(cli, cli, "default")
+			long := types.NamespacedName{
+				Namespace: strings.Repeat("n", 63),
+				Name:      strings.Repeat("a", 199) + ".b-",
+			}
+
+			key := store.(*configMapRuleStore).configMapKey(long)
+			Expect(validation.IsDNS1123Subdomain(key.Name)).To(BeEmpty())
+			Expect(store.StoreRules(ctx, long, rules)).To(Succeed())
+			Expect(store.RemoveRules(ctx, long)).To(Succeed())
+		})
+
+		It("should read absent rules from the cache and only rules just written past it", func() {
+			reader := &countingReader{Reader: cli}
+			store := NewConfigMapRuleStore(cli, reader, "default")
+
+			stored, err := store.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(BeNil())
+			Expect(reader.reads).To(Equal(1))
+
+			Expect(store.StoreRules(ctx, podkey, rules)).To(Succeed())
+			reader.reads = 0
+			stored, err = store.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveKey(_test_container1_name))
+			Expect(reader.reads).To(BeZero())
+
+			Expect(store.RemoveRules(ctx, podkey)).To(Succeed())
+		})
+	})
+
+	Context("For the crd rule store", func() {
+		It("should store, retrieve and remove rules and pods", func() {
+			testRuleStore(NewCRDRuleStore(cli, cli))
+		})
+
+		It("should store pods with their apiVersion and kind", func() {
+			store := NewCRDRuleStore(cli, cli)
+			Expect(store.StorePod(ctx, podkey, _test_pod1.DeepCopy())).To(Succeed())
+
+			rule := &gpuv1alpha1.MIGAdaptationRule{}
+			Expect(cli.Get(ctx, store.(*crdRuleStore).ruleKey(podkey), rule)).To(Succeed())
+			Expect(rule.Spec.Pod).NotTo(BeNil())
+			embedded := metav1.TypeMeta{}
+			Expect(json.Unmarshal(rule.Spec.Pod.Raw, &embedded)).To(Succeed())
+			Expect(embedded).To(Equal(metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"}))
+
+			Expect(store.RemovePod(ctx, podkey)).To(Succeed())
+		})
+
+		It("should read absent rules from the cache and only rules just written past it", func() {
+			reader := &countingReader{Reader: cli}
+			store := NewCRDRuleStore(cli, reader)
+
+			stored, err := store.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(BeNil())
+			Expect(reader.reads).To(BeZero())
+
+			Expect(store.StoreRules(ctx, podkey, rules)).To(Succeed())
+			reader.reads = 0
+			stored, err = store.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveKey(_test_container1_name))
+			Expect(reader.reads).To(Equal(1))
+
+			Expect(store.RemoveRules(ctx, podkey)).To(Succeed())
+		})
+
+		It("should name rules validly for long generate names with dots", func() {
+			store := NewCRDRuleStore(cli, cli)
+			long := types.NamespacedName{
+				Namespace: "default",
+				Name:      strings.Repeat("a", 199) + ".b-",
+			}
+
+			key := store.(*crdRuleStore).ruleKey(long)
+			Expect(validation.IsDNS1123Subdomain(key.Name)).To(BeEmpty())
+			Expect(store.StoreRules(ctx, long, rules)).To(Succeed())
+			Expect(store.RemoveRules(ctx, long)).To(Succeed())
+		})
+
+		It("should share rules between replicas", func() {
+			leader := NewCRDRuleStore(cli, cli)
+			replica := NewCRDRuleStore(cli, cli)
+
+			Expect(leader.StoreRules(ctx, podkey, rules)).To(Succeed())
+			stored, err := replica.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(HaveKey(_test_container1_name))
+
+			Expect(replica.RemoveRules(ctx, podkey)).To(Succeed())
+			stored, err = leader.GetRules(ctx, podkey)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stored).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,274 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// labels of the GPU feature discovery, with the single strategy the migs show up as nvidia.com/gpu
+	// and the product tells their profile, like A100-SXM4-40GB-MIG-1g.5gb
+	LABELKEY_GPU_PRODUCT  = "nvidia.com/gpu.product"
+	LABELKEY_MIG_STRATEGY = "nvidia.com/mig.strategy"
+
+	MIG_STRATEGY_SINGLE   = "single"
+	PRODUCT_MIG_SEPARATOR = "-MIG-"
+
+	PODMESSAGE_NODE_SELECTOR_MISMATCH = "didn't match Pod's node affinity/selector"
+
+	// the node selector and node affinity a pod steered to another product had first
+	ADAPTER_ANNOTATION_ORIGINAL_SELECTOR = "original-selector"
+)
+
+// originalSelector is how a pod selected its nodes before it was steered to another product
+type originalSelector struct {
+	NodeSelector map[string]string    `json:"nodeSelector,omitempty"`
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+}
+
+// parseProductMIG splits a product like A100-SXM4-40GB-MIG-1g.5gb into the GPU model and the mig, nil if it is no mig
+func parseProductMIG(product string) (string, *migIdentifier) {
+	i := strings.LastIndex(product, PRODUCT_MIG_SEPARATOR)
+	if i == -1 {
+		return "", nil
+	}
+
+	mig := &migIdentifier{}
+	if err := mig.Parse(RESOURCE_MIG_PREFIX + product[i+len(PRODUCT_MIG_SEPARATOR):]); err != nil {
+		return "", nil
+	}
+
+	return product[:i], mig
+}
+
+// productOf is the product of the migs of the model, the other way of parseProductMIG
+func productOf(model string, mig *migIdentifier) string {
+	return model + PRODUCT_MIG_SEPARATOR + strings.TrimPrefix(mig.String(), RESOURCE_MIG_PREFIX)
+}
+
+// getSingleStrategyMIG returns the GPU model and the mig of a single strategy node from its labels, nil for other nodes
+func getSingleStrategyMIG(labels map[string]string) (string, *migIdentifier) {
+	if strategy, exists := labels[LABELKEY_MIG_STRATEGY]; exists && strategy != MIG_STRATEGY_SINGLE {
+		return "", nil
+	}
+
+	return parseProductMIG(labels[LABELKEY_GPU_PRODUCT])
+}
+
+// getSingleStrategyTarget returns the GPU model and the mig a pod asking for nvidia.com/gpu selects by product,
+// with its node selector or a required node affinity, nil if it does not target single strategy nodes
+func (a *Adapter) getSingleStrategyTarget(pod *corev1.Pod) (string, *migIdentifier) {
+
+	requested := false
+	for _, c := range podContainers(&pod.Spec) {
+		if _, exists := c.Resources.Limits[RESOURCE_GPU]; exists {
+			requested = true
+		}
+	}
+	if !requested {
+		return "", nil
+	}
+
+	if product, exists := pod.Spec.NodeSelector[LABELKEY_GPU_PRODUCT]; exists {
+		return parseProductMIG(product)
+	}
+
+	for _, expr := range productSelectorRequirements(&pod.Spec) {
+		if expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+			return parseProductMIG(expr.Values[0])
+		}
+	}
+
+	return "", nil
+}
+
+// productSelectorRequirements returns the required node affinity terms on the product
+func productSelectorRequirements(spec *corev1.PodSpec) []*corev1.NodeSelectorRequirement {
+	requirements := []*corev1.NodeSelectorRequirement{}
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return requirements
+	}
+
+	terms := spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	for i := range terms {
+		for j := range terms[i].MatchExpressions {
+			if terms[i].MatchExpressions[j].Key == LABELKEY_GPU_PRODUCT {
+				requirements = append(requirements, &terms[i].MatchExpressions[j])
+			}
+		}
+	}
+
+	return requirements
+}
+
+// setProductSelector steers the pod to the nodes of the product with its node selector, whichever way it selected
+// a product before, the required node affinity terms on the product are changed to not contradict it
+func setProductSelector(spec *corev1.PodSpec, product string) {
+	if spec.NodeSelector == nil {
+		spec.NodeSelector = make(map[string]string)
+	}
+	spec.NodeSelector[LABELKEY_GPU_PRODUCT] = product
+
+	for _, expr := range productSelectorRequirements(spec) {
+		if expr.Operator == corev1.NodeSelectorOpIn {
+			expr.Values = []string{product}
+		}
+	}
+}
+
+// recordOriginalSelector keeps how the pod selects its nodes before it is first steered
+func recordOriginalSelector(pod *corev1.Pod) {
+	if _, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR]; exists {
+		return
+	}
+
+	original := originalSelector{NodeSelector: pod.Spec.NodeSelector}
+	if pod.Spec.Affinity != nil {
+		original.NodeAffinity = pod.Spec.Affinity.NodeAffinity
+	}
+	bytes, err := json.Marshal(original)
+	if err != nil {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR] = string(bytes)
+}
+
+// restoreOriginalSelector puts back how a steered pod selected its nodes, the pod is left as it is if it was not steered
+func restoreOriginalSelector(pod *corev1.Pod) {
+	org, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR]
+	if !exists {
+		return
+	}
+	delete(pod.Annotations, ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR)
+
+	original := originalSelector{}
+	if err := json.Unmarshal([]byte(org), &original); err != nil {
+		return
+	}
+	pod.Spec.NodeSelector = original.NodeSelector
+	if original.NodeAffinity != nil || pod.Spec.Affinity != nil {
+		if pod.Spec.Affinity == nil {
+			pod.Spec.Affinity = &corev1.Affinity{}
+		}
+		pod.Spec.Affinity.NodeAffinity = original.NodeAffinity
+	}
+}
+
+// getOriginalResources returns the resources the pod asked for before it was adapted, nil if it was not. the
+// nvidia.com/gpu of a pod steered to another product stands for the mig of the product it selected first
+func (a *Adapter) getOriginalResources(pod *corev1.Pod) (PodResources, error) {
+	org, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]
+	if !exists {
+		return nil, nil
+	}
+	records := PodResources{}
+	if err := json.Unmarshal([]byte(org), &records); err != nil {
+		return nil, err
+	}
+
+	if _, exists := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR]; exists {
+		first := pod.DeepCopy()
+		restoreOriginalSelector(first)
+		if _, target := a.getSingleStrategyTarget(first); target != nil {
+			records = getSingleStrategyResources(records, target)
+		}
+	}
+
+	return records, nil
+}
+
+// withoutProductSelector copies the node selector without the product, which the adapter is about to change
+func withoutProductSelector(selector map[string]string) map[string]string {
+	copied := make(map[string]string)
+	for k, v := range selector {
+		if k != LABELKEY_GPU_PRODUCT {
+			copied[k] = v
+		}
+	}
+
+	return copied
+}
+
+// renameGPUResource turns nvidia.com/gpu into the mig it stands for on single strategy nodes
+func renameGPUResource(list corev1.ResourceList, mig *migIdentifier) {
+	if q, exists := list[RESOURCE_GPU]; exists {
+		delete(list, RESOURCE_GPU)
+		list[corev1.ResourceName(mig.String())] = q
+	}
+}
+
+// getSingleStrategyResources renames nvidia.com/gpu of the copied resources into the mig, so the pod is sized like any other
+func getSingleStrategyResources(resources PodResources, mig *migIdentifier) PodResources {
+	for name, res := range resources {
+		renameGPUResource(res.Requests, mig)
+		renameGPUResource(res.Limits, mig)
+		resources[name] = res
+	}
+
+	return resources
+}
+
+// getSingleStrategyView copies the pod with the mig in place of nvidia.com/gpu
+func (a *Adapter) getSingleStrategyView(pod *corev1.Pod, mig *migIdentifier) *corev1.Pod {
+	view := pod.DeepCopy()
+	for _, c := range podContainers(&view.Spec) {
+		renameGPUResource(c.Resources.Requests, mig)
+		renameGPUResource(c.Resources.Limits, mig)
+	}
+
+	return view
+}
+
+// applySingleStrategyRules steers a pod targeting single strategy nodes to the product of the mig in the rules,
+// its resources are left as they are and how it selected its nodes first is recorded. it returns false for other
+// pods, whose rules are applied to their resources
+func (a *Adapter) applySingleStrategyRules(pod *corev1.Pod, rules PodResources) bool {
+
+	model, target := a.getSingleStrategyTarget(pod)
+	if target == nil {
+		return false
+	}
+
+	names := []string{}
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		mig := a.getContainerMIG(rules[name])
+		if mig == nil || mig.Full {
+			continue
+		}
+
+		product := productOf(model, mig)
+		recordOriginalSelector(pod)
+		setProductSelector(&pod.Spec, product)
+		awlog.Info("steer pod", "name", pod.Name, "namespace", pod.Namespace, "product", product)
+		break
+	}
+
+	return true
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2024.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package adapter
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Single strategy for Adapter", func() {
+
+	const (
+		product_1_5  = "A100-SXM4-40GB-MIG-1g.5gb"
+		product_2_10 = "A100-SXM4-40GB-MIG-2g.10gb"
+	)
+
+	var adapter *Adapter
+
+	singleNode := func(name, product string) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+				Labels: map[string]string{
+					LABELKEY_MIG_STRATEGY: MIG_STRATEGY_SINGLE,
+					LABELKEY_GPU_PRODUCT:  product,
+				},
+			},
+			Status: corev1.NodeStatus{
+				Allocatable: corev1.ResourceList{
+					RESOURCE_GPU: _test_quantity_2,
+				},
+			},
+		}
+	}
+
+	pendingOn := func(product string) *corev1.Pod {
+		pod := _test_podpending.DeepCopy()
+		pod.Spec.NodeSelector = map[string]string{LABELKEY_GPU_PRODUCT: product}
+		pod.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{RESOURCE_GPU: _test_quantity_1},
+			Limits:   corev1.ResourceList{RESOURCE_GPU: _test_quantity_1},
+		}
+		pod.Status.Conditions[0].Message = _test_pod_status_condition_message_prefix + RESOURCE_GPU + CONDITION_MESSAGE_SEPARATOR
+		return pod
+	}
+
+	runningOn := func(node string) corev1.Pod {
+		pod := pendingOn(product_1_5)
+		pod.Name = "running"
+		pod.Spec.NodeName = node
+		pod.Spec.Containers[0].Resources.Limits[RESOURCE_GPU] = _test_quantity_2
+		pod.Spec.Containers[0].Resources.Requests[RESOURCE_GPU] = _test_quantity_2
+		pod.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+		return *pod
+	}
+
+	BeforeEach(func() {
+		adapter = &Adapter{
+			rules:  NewMemoryRuleStore(),
+			policy: DefaultPolicy(),
+		}
+	})
+
+	Context("For a product", func() {
+		It("should split the GPU model and the mig", func() {
+			model, mig := parseProductMIG(product_2_10)
+			Expect(model).To(Equal("A100-SXM4-40GB"))
+			Expect(mig.String()).To(Equal(_test_mig_Identifier_string_2_10))
+			Expect(productOf(model, mig)).To(Equal(product_2_10))
+		})
+
+		It("should not find a mig in a whole GPU", func() {
+			_, mig := parseProductMIG("A100-SXM4-40GB")
+			Expect(mig).To(BeNil())
+		})
+	})
+
+	Context("For a single strategy node", func() {
+		It("should read the migs from the product", func() {
+			node := singleNode("single", product_1_5)
+			migsOnNode := adapter.getAllocableMIGsOnNode(&node)
+
+			Expect(migsOnNode.Model).To(Equal("A100-SXM4-40GB"))
+			Expect(migsOnNode.MIGs).To(HaveLen(1))
+			q := migsOnNode.MIGs[migIdentifier{Compute: 1, Memory: 5}]
+			Expect(q.Equal(_test_quantity_2)).To(BeTrue())
+		})
+
+		It("should not read migs from the product of a mixed strategy node", func() {
+			node := singleNode("mixed", product_1_5)
+			node.Labels[LABELKEY_MIG_STRATEGY] = "mixed"
+			Expect(adapter.getAllocableMIGsOnNode(&node).MIGs).To(BeEmpty())
+		})
+
+		It("should count nvidia.com/gpu of running pods as its mig", func() {
+			node := singleNode("single", product_1_5)
+			available := adapter.detectAllAvailableMIGs([]corev1.Node{node}, []corev1.Pod{runningOn("single")})
+
+			q := available["single"].MIGs[migIdentifier{Compute: 1, Memory: 5}]
+			Expect(q.IsZero()).To(BeTrue())
+		})
+	})
+
+	Context("For a pod selecting a product", func() {
+		It("should be pending for the mig of the product", func() {
+			Expect(adapter.PodPendingForMIG(pendingOn(product_1_5))).To(BeEquivalentTo(_test_mig_Identifier_string_1_5))
+
+			pod := pendingOn(product_1_5)
+			pod.Status.Conditions[0].Message = "0/1 nodes are available: 1 node(s) " + PODMESSAGE_NODE_SELECTOR_MISMATCH + "."
+			Expect(adapter.PodPendingForMIG(pod)).To(BeEquivalentTo(_test_mig_Identifier_string_1_5))
+		})
+
+		It("should be steered to a larger product of the same model", func() {
+			nodes := []corev1.Node{
+				singleNode("single-1", product_1_5),
+				singleNode("single-2", product_2_10),
+				singleNode("single-3", "A30-MIG-1g.6gb"),
+				_test_node1,
+			}
+			pod := pendingOn(product_1_5)
+
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, []corev1.Pod{runningOn("single-1")})).To(BeTrue())
+			Expect(pod.Spec.NodeSelector[LABELKEY_GPU_PRODUCT]).To(Equal(product_2_10))
+			Expect(pod.Annotations).To(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL_SELECTOR))
+			Expect(pod.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName(RESOURCE_GPU)))
+		})
+
+		It("should not be steered to another GPU model nor to mixed strategy nodes", func() {
+			nodes := []corev1.Node{
+				singleNode("single-1", product_1_5),
+				singleNode("single-3", "A30-MIG-2g.12gb"),
+				_test_node1,
+			}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pendingOn(product_1_5), nodes, []corev1.Pod{runningOn("single-1")})).To(BeFalse())
+		})
+
+		It("should be steered by the webhook when recreated, with its resources left as they are", func() {
+			nodes := []corev1.Node{
+				singleNode("single-1", product_1_5),
+				singleNode("single-2", product_2_10),
+			}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pendingOn(product_1_5), nodes, []corev1.Pod{runningOn("single-1")})).To(BeTrue())
+
+			recreated := pendingOn(product_1_5)
+			recreated.Spec.NodeSelector = nil
+			recreated.Spec.Affinity = &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+							MatchExpressions: []corev1.NodeSelectorRequirement{{
+								Key:      LABELKEY_GPU_PRODUCT,
+								Operator: corev1.NodeSelectorOpIn,
+								Values:   []string{product_1_5},
+							}},
+						}},
+					},
+				},
+			}
+			adapter.CheckAndUpdatePodWithContext(ctx, recreated)
+
+			expr := recreated.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0]
+			Expect(expr.Values).To(Equal([]string{product_2_10}))
+			Expect(recreated.Spec.NodeSelector).To(HaveKeyWithValue(LABELKEY_GPU_PRODUCT, product_2_10))
+			Expect(recreated.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName(RESOURCE_GPU)))
+			Expect(recreated.Annotations).To(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL))
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(recreated))).To(BeNil())
+
+			again := adapter.genRecreatedPod(recreated)
+			Expect(again.Spec.NodeSelector).To(BeNil())
+			expr = again.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0]
+			Expect(expr.Values).To(Equal([]string{product_1_5}))
+		})
+
+		It("should be restored to the product it selected once there is room", func() {
+			nodes := []corev1.Node{
+				singleNode("single-1", product_1_5),
+				singleNode("single-2", product_2_10),
+			}
+			Expect(adapter.AdaptPodToGPUsWithContext(ctx, pendingOn(product_1_5), nodes, []corev1.Pod{runningOn("single-1")})).To(BeTrue())
+
+			steered := pendingOn(product_1_5)
+			adapter.CheckAndUpdatePodWithContext(ctx, steered)
+			Expect(steered.Spec.NodeSelector[LABELKEY_GPU_PRODUCT]).To(Equal(product_2_10))
+			steered.Spec.NodeName = "single-2"
+			steered.Status = corev1.PodStatus{Phase: corev1.PodRunning}
+
+			By("keeping it while the product it selected is taken")
+			Expect(adapter.CheckAndRestorePodsWithContext(ctx, nodes, []corev1.Pod{*steered, runningOn("single-1")})).To(BeEmpty())
+
+			restored := adapter.CheckAndRestorePodsWithContext(ctx, nodes, []corev1.Pod{*steered})
+			Expect(restored).To(HaveLen(1))
+
+			recreated := adapter.genRecreatedPod(restored[0])
+			Expect(recreated.Spec.NodeSelector[LABELKEY_GPU_PRODUCT]).To(Equal(product_1_5))
+			adapter.CheckAndUpdatePodWithContext(ctx, recreated)
+			Expect(recreated.Spec.NodeSelector[LABELKEY_GPU_PRODUCT]).To(Equal(product_1_5))
+			Expect(recreated.Spec.Containers[0].Resources.Limits).To(HaveKey(corev1.ResourceName(RESOURCE_GPU)))
+			Expect(adapter.getResourceRulesForPod(ctx, adapter.genPodKey(recreated))).To(BeNil())
+		})
+
+		It("should not be given a repartitioned node", func() {
+			adapter.policy.RepartitionEnabled = true
+			Expect(adapter.AdaptGPUsToPodWithContext(ctx, pendingOn(product_1_5), []corev1.Node{_test_node2}, nil)).To(BeNil())
+		})
+	})
+})
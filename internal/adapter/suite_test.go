@@ -89,6 +89,11 @@ var _ = BeforeSuite(func() {
 
 })
 
+// the adapter shared by the specs holds no migs for pods restarted by a previous spec
+var _ = BeforeEach(func() {
+	GetAdapter(cli).reservations = nil
+})
+
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
 	err := testEnv.Stop()
@@ -110,8 +115,11 @@ var (
 	_test_podpending_name = "podpending"
 
 	_test_container1_name = "container1"
+	_test_container2_name = "container2"
 
 	_test_pod_status_condition_message_prefix = "0/2 nodes are available, " + PODMESSAGE_INSUFFICIENT_PREFIX
+
+	_test_controller = true
 )
 
 var (
@@ -169,6 +177,15 @@ var (
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: _test_pod2_genname,
 			Namespace:    _test_namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					APIVersion: "apps/v1",
+					Kind:       "ReplicaSet",
+					Name:       _test_pod2_genname,
+					UID:        "pod2-owner",
+					Controller: &_test_controller,
+				},
+			},
 		},
 		Spec: corev1.PodSpec{
 			NodeName: _test_node2_name,
@@ -196,7 +213,6 @@ var (
 			Name: _test_podpending_name,
 		},
 		Spec: corev1.PodSpec{
-			NodeName: _test_node2_name,
 			Containers: []corev1.Container{
 				{
 					Name: _test_container1_name,
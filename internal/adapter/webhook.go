@@ -28,36 +28,67 @@ var awlog = logf.Log.WithName("adapter controller")
 
 func (a *Adapter) CheckAndUpdatePodWithContext(ctx context.Context, pod *corev1.Pod) {
 
+	// pods are left as they are in dry-run mode, along with their rules
+	if a.IsDryRun() {
+		return
+	}
+	// nor are pods opted out since their rules were stored
+	if !a.IsPodTargetedWithContext(ctx, pod) {
+		return
+	}
+
 	podkey := a.genPodKey(pod)
 
+	rules := a.getResourceRulesForPod(ctx, podkey)
+	if rules == nil {
+		return
+	}
+
+	// pods on single strategy nodes keep asking for nvidia.com/gpu, they are steered to another product instead,
+	// their resources are recorded as they are for the pod to be restored like any other
+	if a.applySingleStrategyRules(pod, rules) {
+		if _, steered := pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL_SELECTOR]; steered {
+			original := make(PodResources)
+			for _, c := range podContainers(&pod.Spec) {
+				if _, exists := rules[c.Name]; exists {
+					original[c.Name] = *c.Resources.DeepCopy()
+				}
+			}
+			setOriginalAnnotation(pod, original)
+		}
+		a.removeResourceRulesForPod(ctx, podkey)
+		return
+	}
+
 	original := make(PodResources)
 
-	for i, c := range pod.Spec.Containers {
-		req, limits, claims := a.getResourceRulesForContainer(podkey, c.Name)
+	for _, c := range podContainers(&pod.Spec) {
+		rule := rules[c.Name]
+		req, limits, claims := rule.Requests, rule.Limits, rule.Claims
 		container_original := corev1.ResourceRequirements{}
 		done := false
 
 		if req != nil {
-			container_original.Requests = pod.Spec.Containers[i].Resources.Requests
+			container_original.Requests = c.Resources.Requests
 			done = true
 
-			pod.Spec.Containers[i].Resources.Requests = req
-			awlog.Info("update pod res requests", "container name", pod.Spec.Containers[0].Name, "update req", req)
+			c.Resources.Requests = req
+			awlog.Info("update pod res requests", "container name", c.Name, "update req", req)
 		}
 
 		if limits != nil {
-			container_original.Limits = pod.Spec.Containers[i].Resources.Limits
+			container_original.Limits = c.Resources.Limits
 			done = true
-			pod.Spec.Containers[i].Resources.Limits = limits
-			awlog.Info("update pod res limits", "container name", pod.Spec.Containers[0].Name, "update limits", limits)
+			c.Resources.Limits = limits
+			awlog.Info("update pod res limits", "container name", c.Name, "update limits", limits)
 		}
 
 		if claims != nil {
 			done = true
-			container_original.Claims = pod.Spec.Containers[i].Resources.Claims
+			container_original.Claims = c.Resources.Claims
 
-			pod.Spec.Containers[i].Resources.Claims = claims
-			awlog.Info("update pod res claims", "container name", pod.Spec.Containers[0].Name, "update claims", claims)
+			c.Resources.Claims = claims
+			awlog.Info("update pod res claims", "container name", c.Name, "update claims", claims)
 		}
 
 		if done {
@@ -65,16 +96,23 @@ func (a *Adapter) CheckAndUpdatePodWithContext(ctx context.Context, pod *corev1.
 		}
 	}
 
-	if len(original) > 0 {
-		if pod.Annotations == nil {
-			pod.Annotations = make(map[string]string)
-		}
+	setOriginalAnnotation(pod, original)
 
-		bytes, err := json.Marshal(original)
-		if err == nil {
-			pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL] = string(bytes)
-		}
+	a.removeResourceRulesForPod(ctx, podkey)
+}
+
+// setOriginalAnnotation records the resources of the containers before they were adapted
+func setOriginalAnnotation(pod *corev1.Pod, original PodResources) {
+
+	if len(original) == 0 {
+		return
+	}
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
 	}
 
-	a.removeResourceRulesForPod(podkey)
+	bytes, err := json.Marshal(original)
+	if err == nil {
+		pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL] = string(bytes)
+	}
 }
@@ -38,6 +38,50 @@ var _ = Describe("API for Adapter", func() {
 		})
 	})
 
+	Context("For given Pod with rules for init containers", func() {
+		It("should patch the init containers", func() {
+			pod := _test_pod1.DeepCopy()
+			pod.Spec.InitContainers = []corev1.Container{
+				*pod.Spec.Containers[0].DeepCopy(),
+			}
+			pod.Spec.InitContainers[0].Name = _test_container2_name
+			podkey := adapter.genPodKey(pod)
+
+			creq := corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			Expect(adapter.storeResourceRulesForContainer(ctx, podkey, _test_container2_name, creq, creq, nil)).To(Succeed())
+			adapter.CheckAndUpdatePodWithContext(ctx, pod)
+
+			Expect(pod.Spec.InitContainers[0].Resources.Requests).To(BeEquivalentTo(creq))
+			Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_pod1.Spec.Containers[0].Resources))
+
+			pr := PodResources{}
+			Expect(json.Unmarshal([]byte(pod.Annotations[ADAPTER_ANNOTATION_PREFIX+ADAPTER_ANNOTATION_ORIGINAL]), &pr)).To(Succeed())
+			Expect(pr).To(HaveKey(_test_container2_name))
+			Expect(pr).NotTo(HaveKey(_test_container1_name))
+		})
+	})
+
+	Context("For given Pod with rules opted out", func() {
+		It("should not be patched", func() {
+			pod := _test_pod1.DeepCopy()
+			pod.Annotations = map[string]string{
+				ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_POLICY: POD_POLICY_NEVER,
+			}
+			podkey := adapter.genPodKey(pod)
+			creq := corev1.ResourceList{
+				_test_mig_Identifier_string_2_10: _test_quantity_1,
+			}
+			Expect(adapter.storeResourceRulesForContainer(ctx, podkey, _test_container1_name, creq, creq, nil)).To(Succeed())
+			defer adapter.removeResourceRulesForPod(ctx, podkey)
+
+			adapter.CheckAndUpdatePodWithContext(ctx, pod)
+			Expect(pod.Spec.Containers[0].Resources).To(BeEquivalentTo(_test_pod1.Spec.Containers[0].Resources))
+			Expect(pod.Annotations).NotTo(HaveKey(ADAPTER_ANNOTATION_PREFIX + ADAPTER_ANNOTATION_ORIGINAL))
+		})
+	})
+
 	Context("For given Pod with rules", func() {
 		It("should be patched correctly", func() {
 			pod := _test_pod1.DeepCopy()
@@ -55,7 +99,7 @@ var _ = Describe("API for Adapter", func() {
 				},
 			}
 
-			adapter.storeResourceRulesForContainer(podkey, _test_container1_name, creq, climit, cclaims)
+			adapter.storeResourceRulesForContainer(ctx, podkey, _test_container1_name, creq, climit, cclaims)
 			adapter.CheckAndUpdatePodWithContext(ctx, pod)
 			Expect(pod.Spec.Containers[0].Resources.Requests).To(BeEquivalentTo(creq))
 			Expect(pod.Spec.Containers[0].Resources.Limits).To(BeEquivalentTo(climit))
@@ -18,45 +18,236 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	gpuv1alpha1 "github.com/IBM/mig-adapter/api/v1alpha1"
+	gpuadapter "github.com/IBM/mig-adapter/internal/adapter"
 )
 
-// NVidiaMIGAdapterReconciler reconciles a NVidiaMIGAdapter object
+const (
+	// how often the adapter statistics are synced into the status
+	STATUS_SYNC_PERIOD = time.Minute
+)
+
+// NVidiaMIGAdapterReconciler reconciles a NVidiaMIGAdapter object.
+// it runs on every replica, the webhooks of all of them follow the policy, but only the leader writes the status
 type NVidiaMIGAdapterReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+
+	Adapter *gpuadapter.Adapter
+	// closed once the replica is the leader, nil if always
+	Elected <-chan struct{}
 }
 
 //+kubebuilder:rbac:groups=gpu.turbonomic.ibm.com,resources=nvidiamigadapters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=gpu.turbonomic.ibm.com,resources=nvidiamigadapters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=gpu.turbonomic.ibm.com,resources=nvidiamigadapters/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the NVidiaMIGAdapter object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile pushes the policy in the spec of the oldest NVidiaMIGAdapter into the adapter and
+// reports the adapter statistics back in its status, the other resources are marked inactive.
+// The default policy is restored once no resource is left. Only the leader writes the status.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.0/pkg/reconcile
 func (r *NVidiaMIGAdapterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
+	logger := log.FromContext(ctx)
+
+	active, err := r.getActiveConfig(ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if active == nil {
+		r.Adapter.SetPolicy(gpuadapter.DefaultPolicy())
+		return ctrl.Result{}, nil
+	}
+
+	policy, err := policyFromSpec(&active.Spec)
+	if err != nil {
+		logger.Error(err, "invalid policy, previous one kept", "name", active.Name)
+		r.Adapter.RecordEvent(active, corev1.EventTypeWarning, gpuadapter.EVENT_REASON_INVALID_POLICY, "invalid policy, previous one kept: %v", err)
+		return ctrl.Result{}, err
+	}
+	r.Adapter.SetPolicy(policy)
 
-	// TODO(user): your logic here
+	// the statistics of the other replicas are only those of their webhooks, checked again until one leads
+	if !r.isLeader() {
+		return ctrl.Result{RequeueAfter: STATUS_SYNC_PERIOD}, nil
+	}
 
-	return ctrl.Result{}, nil
+	config := &gpuv1alpha1.NVidiaMIGAdapter{}
+	if err := r.Get(ctx, req.NamespacedName, config); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if config.Name != active.Name {
+		logger.Info("policy ignored, another resource is in effect", "name", config.Name, "active", active.Name)
+		if config.Status.Active || config.Status.DryRun != nil {
+			config.Status = gpuv1alpha1.NVidiaMIGAdapterStatus{}
+			if err := r.Status().Update(ctx, config); err != nil {
+				logger.Error(err, "update status", "name", config.Name)
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: STATUS_SYNC_PERIOD}, nil
+	}
+
+	// the counts of the adapter start over with the process, only what they grew by is added to the status
+	unreported := r.Adapter.GetUnreportedStatistics()
+	status := gpuv1alpha1.NVidiaMIGAdapterStatus{
+		Active:            true,
+		AdaptedPods:       config.Status.AdaptedPods + unreported.AdaptedPods,
+		DownsizedPods:     config.Status.DownsizedPods + unreported.DownsizedPods,
+		RestoredPods:      config.Status.RestoredPods + unreported.RestoredPods,
+		RepartitionedPods: config.Status.RepartitionedPods + unreported.RepartitionedPods,
+		EvictedPods:       config.Status.EvictedPods + unreported.EvictedPods,
+	}
+	dryRun := gpuv1alpha1.DryRunStatus{}
+	if config.Status.DryRun != nil {
+		dryRun = *config.Status.DryRun
+	}
+	dryRun.Upsizes += unreported.WouldUpsize
+	dryRun.Downsizes += unreported.WouldDownsize
+	dryRun.Restores += unreported.WouldRestore
+	dryRun.Repartitions += unreported.WouldRepartition
+	dryRun.Evictions += unreported.WouldEvict
+	if len(unreported.LastDryRunDecisions) > 0 {
+		dryRun.LastDecisions = unreported.LastDryRunDecisions
+	}
+	if dryRun.Upsizes > 0 || dryRun.Downsizes > 0 || dryRun.Restores > 0 || dryRun.Repartitions > 0 || dryRun.Evictions > 0 {
+		status.DryRun = &dryRun
+	}
+	if !reflect.DeepEqual(config.Status, status) {
+		config.Status = status
+		if err := r.Status().Update(ctx, config); err != nil {
+			logger.Error(err, "update status", "name", req.NamespacedName.String())
+			return ctrl.Result{}, err
+		}
+	}
+	r.Adapter.MarkStatisticsReported(unreported)
+
+	return ctrl.Result{RequeueAfter: STATUS_SYNC_PERIOD}, nil
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// getActiveConfig returns the oldest resource not being deleted, nil if there is none
+func (r *NVidiaMIGAdapterReconciler) getActiveConfig(ctx context.Context) (*gpuv1alpha1.NVidiaMIGAdapter, error) {
+	configs := &gpuv1alpha1.NVidiaMIGAdapterList{}
+	if err := r.List(ctx, configs); err != nil {
+		return nil, err
+	}
+
+	var active *gpuv1alpha1.NVidiaMIGAdapter
+	for i := range configs.Items {
+		config := &configs.Items[i]
+		if config.DeletionTimestamp != nil {
+			continue
+		}
+		if active == nil || config.CreationTimestamp.Before(&active.CreationTimestamp) ||
+			config.CreationTimestamp.Equal(&active.CreationTimestamp) && config.Name < active.Name {
+			active = config
+		}
+	}
+
+	return active, nil
+}
+
+func policyFromSpec(spec *gpuv1alpha1.NVidiaMIGAdapterSpec) (gpuadapter.Policy, error) {
+	policy := gpuadapter.DefaultPolicy()
+
+	policy.Namespaces = spec.Namespaces
+	if spec.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector)
+		if err != nil {
+			return policy, err
+		}
+		policy.NamespaceSelector = selector
+	}
+	if spec.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(spec.PodSelector)
+		if err != nil {
+			return policy, err
+		}
+		policy.PodSelector = selector
+	}
+	policy.AllowedProfiles = spec.AllowedProfiles
+	if spec.MaxUpsizeRatio != nil {
+		ratio := spec.MaxUpsizeRatio.AsApproximateFloat64()
+		if ratio != 0 && ratio < 1 {
+			return policy, fmt.Errorf("max upsize ratio %s is neither 0 nor at least 1", spec.MaxUpsizeRatio.String())
+		}
+		policy.MaxUpsizeRatio = ratio
+	}
+	if spec.EnableRestore != nil {
+		policy.RestoreEnabled = *spec.EnableRestore
+	}
+	if spec.RestoreMinRuntime != nil {
+		policy.RestoreMinRuntime = spec.RestoreMinRuntime.Duration
+	}
+	if spec.RestoreCooldown != nil {
+		policy.RestoreCooldown = spec.RestoreCooldown.Duration
+	}
+	if spec.MaxRestoreCycles != nil {
+		policy.MaxRestoreCycles = int(*spec.MaxRestoreCycles)
+	}
+	if spec.RestorePolicy != nil {
+		if spec.RestorePolicy.Mode != "" {
+			policy.RestorePolicy = spec.RestorePolicy.Mode
+		}
+		for _, w := range spec.RestorePolicy.MaintenanceWindows {
+			window, err := gpuadapter.ParseMaintenanceWindow(w.Schedule, w.Duration.Duration)
+			if err != nil {
+				return policy, err
+			}
+			policy.MaintenanceWindows = append(policy.MaintenanceWindows, window)
+		}
+	}
+	if spec.EnableRepartition != nil {
+		policy.RepartitionEnabled = *spec.EnableRepartition
+	}
+	if spec.FullGPUFallback != nil {
+		policy.FullGPUFallback = &gpuadapter.FullGPUFallback{
+			Profiles: spec.FullGPUFallback.Profiles,
+			MIGToGPU: spec.FullGPUFallback.MIGToGPU,
+			GPUToMIG: spec.FullGPUFallback.GPUToMIG,
+		}
+	}
+	policy.DryRun = spec.DryRun
+
+	return policy, nil
+}
+
+func (r *NVidiaMIGAdapterReconciler) isLeader() bool {
+	if r.Elected == nil {
+		return true
+	}
+
+	select {
+	case <-r.Elected:
+		return true
+	default:
+		return false
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager, without leader election
+// so the policy is loaded on every replica
 func (r *NVidiaMIGAdapterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	needLeaderElection := false
+	if r.Elected == nil {
+		r.Elected = mgr.Elected()
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&gpuv1alpha1.NVidiaMIGAdapter{}).
+		WithOptions(controller.Options{NeedLeaderElection: &needLeaderElection}).
 		Complete(r)
 }
@@ -18,16 +18,21 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	gpuv1alpha1 "github.com/IBM/mig-adapter/api/v1alpha1"
+	gpuadapter "github.com/IBM/mig-adapter/internal/adapter"
 )
 
 var _ = Describe("NVidiaMIGAdapter Controller", func() {
@@ -37,10 +42,12 @@ var _ = Describe("NVidiaMIGAdapter Controller", func() {
 		ctx := context.Background()
 
 		typeNamespacedName := types.NamespacedName{
-			Name:      resourceName,
-			Namespace: "default", // TODO(user):Modify as needed
+			Name: resourceName,
 		}
 		nvidiamigadapter := &gpuv1alpha1.NVidiaMIGAdapter{}
+		disabled := false
+		cycles := int32(1)
+		ratio := resource.MustParse("1.5")
 
 		BeforeEach(func() {
 			By("creating the custom resource for the Kind NVidiaMIGAdapter")
@@ -48,10 +55,22 @@ var _ = Describe("NVidiaMIGAdapter Controller", func() {
 			if err != nil && errors.IsNotFound(err) {
 				resource := &gpuv1alpha1.NVidiaMIGAdapter{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:      resourceName,
-						Namespace: "default",
+						Name: resourceName,
+					},
+					Spec: gpuv1alpha1.NVidiaMIGAdapterSpec{
+						Namespaces:      []string{"default"},
+						AllowedProfiles: []string{"2g.10gb", "3g.20gb"},
+						MaxUpsizeRatio:  &ratio,
+						FullGPUFallback: &gpuv1alpha1.FullGPUFallback{
+							Profiles: []string{"7g.40gb"},
+							MIGToGPU: true,
+						},
+						EnableRestore:    &disabled,
+						MaxRestoreCycles: &cycles,
+						RestorePolicy: &gpuv1alpha1.RestorePolicy{
+							Mode: gpuadapter.RESTORE_POLICY_CHECKPOINT_SAFE,
+						},
 					},
-					// TODO(user): Specify other spec details if needed.
 				}
 				Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 			}
@@ -68,17 +87,283 @@ var _ = Describe("NVidiaMIGAdapter Controller", func() {
 		})
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			counted := resource.Status.AdaptedPods
+			adapter.RecordAdaptedPod()
+			unreported := adapter.GetUnreportedStatistics().AdaptedPods
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			policy := adapter.GetPolicy()
+			Expect(policy.Namespaces).To(Equal([]string{"default"}))
+			Expect(policy.AllowedProfiles).To(Equal([]string{"2g.10gb", "3g.20gb"}))
+			Expect(policy.RestoreEnabled).To(BeFalse())
+			Expect(policy.RepartitionEnabled).To(BeTrue())
+			Expect(policy.MaxUpsizeRatio).To(Equal(1.5))
+			Expect(policy.MaxRestoreCycles).To(Equal(1))
+			Expect(policy.RestorePolicy).To(Equal(gpuadapter.RESTORE_POLICY_CHECKPOINT_SAFE))
+			Expect(policy.FullGPUFallback).To(Equal(&gpuadapter.FullGPUFallback{
+				Profiles: []string{"7g.40gb"},
+				MIGToGPU: true,
+			}))
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.AdaptedPods).To(Equal(counted + unreported))
+			Expect(adapter.GetUnreportedStatistics().AdaptedPods).To(BeZero())
+		})
+
+		It("should add the pods counted since to the status rather than replace it", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("counting pods in the status before the controller restarted")
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Status.RestoredPods = 10
+			Expect(k8sClient.Status().Update(ctx, resource)).To(Succeed())
+
+			adapter.RecordRestoredPod()
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.RestoredPods).To(Equal(int64(11)))
+
+			By("reconciling again with no pod counted since")
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.RestoredPods).To(Equal(int64(11)))
+		})
+
+		It("should load the policy but leave the status to the leader", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+				Elected: make(chan struct{}),
+			}
+			adapter.SetPolicy(gpuadapter.DefaultPolicy())
+			adapter.RecordAdaptedPod()
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetPolicy().Namespaces).To(Equal([]string{"default"}))
+
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Active).To(BeFalse())
+
+			adapter.SetPolicy(gpuadapter.DefaultPolicy())
+		})
+
+		It("should select pods and namespaces by labels", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.NamespaceSelector = &metav1.LabelSelector{
+				MatchLabels: map[string]string{"mig-adapter": "enabled"},
+			}
+			resource.Spec.PodSelector = &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "latency-critical", Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			}
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			policy := adapter.GetPolicy()
+			Expect(policy.NamespaceSelector).NotTo(BeNil())
+			Expect(policy.NamespaceSelector.Matches(labels.Set{"mig-adapter": "enabled"})).To(BeTrue())
+			Expect(policy.PodSelector).NotTo(BeNil())
+			Expect(policy.PodSelector.Matches(labels.Set{"latency-critical": "true"})).To(BeFalse())
+
+			adapter.SetPolicy(gpuadapter.DefaultPolicy())
+		})
+
+		It("should switch to dry run at runtime", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
 			controllerReconciler := &NVidiaMIGAdapterReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
 			}
 
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DryRun = true
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
 				NamespacedName: typeNamespacedName,
 			})
 			Expect(err).NotTo(HaveOccurred())
-			// TODO(user): Add more specific assertions depending on your controller's reconciliation logic.
-			// Example: If you expect a certain status condition after reconciliation, verify it here.
+			Expect(adapter.IsDryRun()).To(BeTrue())
+
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			resource.Spec.DryRun = false
+			Expect(k8sClient.Update(ctx, resource)).To(Succeed())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: typeNamespacedName,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.IsDryRun()).To(BeFalse())
+		})
+
+		It("should only apply the policy of the oldest resource", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+
+			newer := &gpuv1alpha1.NVidiaMIGAdapter{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-newer"},
+				Spec:       gpuv1alpha1.NVidiaMIGAdapterSpec{DryRun: true},
+			}
+			Expect(k8sClient.Create(ctx, newer)).To(Succeed())
+			newerName := types.NamespacedName{Name: newer.Name}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: newerName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.IsDryRun()).To(BeFalse())
+			Expect(adapter.GetPolicy().RestoreEnabled).To(BeFalse())
+			Expect(k8sClient.Get(ctx, newerName, newer)).To(Succeed())
+			Expect(newer.Status.Active).To(BeFalse())
+
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(resource.Status.Active).To(BeTrue())
+
+			By("deleting the newer resource, the policy of the oldest one is kept")
+			Expect(k8sClient.Delete(ctx, newer)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: newerName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetPolicy()).NotTo(Equal(gpuadapter.DefaultPolicy()))
+			Expect(adapter.GetPolicy().RestoreEnabled).To(BeFalse())
+		})
+
+		It("should reject the schedules without 5 fields and report those the adapter cannot parse", func() {
+			for _, w := range []gpuv1alpha1.MaintenanceWindow{
+				{Schedule: "0 2 * *", Duration: metav1.Duration{Duration: time.Hour}},
+				{Schedule: "0 2 * * 6", Duration: metav1.Duration{Duration: 8 * 24 * time.Hour}},
+				{Schedule: "0 2 * * 6"},
+			} {
+				invalid := &gpuv1alpha1.NVidiaMIGAdapter{
+					ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-invalid"},
+					Spec: gpuv1alpha1.NVidiaMIGAdapterSpec{
+						RestorePolicy: &gpuv1alpha1.RestorePolicy{
+							Mode:               gpuadapter.RESTORE_POLICY_MAINTENANCE_WINDOW,
+							MaintenanceWindows: []gpuv1alpha1.MaintenanceWindow{w},
+						},
+					},
+				}
+				Expect(errors.IsInvalid(k8sClient.Create(ctx, invalid))).To(BeTrue(), w.Schedule)
+			}
+
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			recorder := record.NewFakeRecorder(10)
+			adapter.Recorder = recorder
+			defer func() { adapter.Recorder = nil }()
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, schedule := range []string{"0 24 * * 6", "0 5-2 * * 6", "*/0 2 * * 6"} {
+				_, err := gpuadapter.ParseMaintenanceWindow(schedule, time.Hour)
+				Expect(err).To(HaveOccurred())
+
+				resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+				Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+				resource.Spec.RestorePolicy = &gpuv1alpha1.RestorePolicy{
+					Mode: gpuadapter.RESTORE_POLICY_MAINTENANCE_WINDOW,
+					MaintenanceWindows: []gpuv1alpha1.MaintenanceWindow{
+						{Schedule: schedule, Duration: metav1.Duration{Duration: time.Hour}},
+					},
+				}
+				Expect(k8sClient.Update(ctx, resource)).To(Succeed(), schedule)
+
+				_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+				Expect(err).To(HaveOccurred())
+				Expect(adapter.GetPolicy().RestorePolicy).To(Equal(gpuadapter.RESTORE_POLICY_CHECKPOINT_SAFE))
+				Expect(recorder.Events).To(Receive(ContainSubstring(gpuadapter.EVENT_REASON_INVALID_POLICY)))
+			}
+
+			valid := &gpuv1alpha1.NVidiaMIGAdapter{
+				ObjectMeta: metav1.ObjectMeta{Name: resourceName + "-valid"},
+				Spec: gpuv1alpha1.NVidiaMIGAdapterSpec{
+					RestorePolicy: &gpuv1alpha1.RestorePolicy{
+						Mode: gpuadapter.RESTORE_POLICY_MAINTENANCE_WINDOW,
+						MaintenanceWindows: []gpuv1alpha1.MaintenanceWindow{
+							{Schedule: "*/15 0-6,22-23 1-7 */2 1-5", Duration: metav1.Duration{Duration: 7 * 24 * time.Hour}},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, valid)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, valid)).To(Succeed())
+		})
+
+		It("should restore the default policy once the resources are gone", func() {
+			adapter := gpuadapter.GetAdapter(k8sClient)
+			controllerReconciler := &NVidiaMIGAdapterReconciler{
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: adapter,
+			}
+
+			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: "missing"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetPolicy()).NotTo(Equal(gpuadapter.DefaultPolicy()))
+
+			resource := &gpuv1alpha1.NVidiaMIGAdapter{}
+			Expect(k8sClient.Get(ctx, typeNamespacedName, resource)).To(Succeed())
+			Expect(k8sClient.Delete(ctx, resource)).To(Succeed())
+			_, err = controllerReconciler.Reconcile(ctx, reconcile.Request{NamespacedName: typeNamespacedName})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(adapter.GetPolicy()).To(Equal(gpuadapter.DefaultPolicy()))
+
+			By("recreating the resource for the cleanup")
+			resource.ResourceVersion = ""
+			Expect(k8sClient.Create(ctx, resource)).To(Succeed())
 		})
 	})
 })
@@ -18,10 +18,14 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -47,16 +51,24 @@ func (r *PodReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		Complete(r)
 }
 
-//+kubebuilder:rbac:resources=pods,verbs=get;list;watch;create;update;patch
+//+kubebuilder:rbac:resources=pods,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:resources=pods/status,verbs=get;update;patch
 //+kubebuilder:rbac:resources=pods/finalizers,verbs=update
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the NVidiaMIGAdapter object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+//+kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=nvidia.com,resources=clusterpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups=scheduling.k8s.io,resources=priorityclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=gpu.turbonomic.ibm.com,resources=migadaptationrules,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile adapts a pod pending for migs, along with all other pods pending for them, to the migs available,
+// or repartitions a node for it. Once a pod is deleted, the bare pod kept for it is recreated and the adapted
+// pods which fit their original migs again are restored, under the policy of the NVidiaMIGAdapter.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.17.0/pkg/reconcile
@@ -68,35 +80,162 @@ func (r *PodReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Deleted
+			if err := r.recreateBarePod(ctx, req.NamespacedName); err != nil {
+				return ctrl.Result{}, err
+			}
+
 			nodes, pods := r.GetAllNodesAndPodsWithContext(ctx)
 			podsToRestore := r.Adapter.CheckAndRestorePodsWithContext(ctx, nodes, pods)
+			requeue := time.Duration(0)
 			for _, pod := range podsToRestore {
-				r.Delete(ctx, pod)
+				if err := r.restartPod(ctx, pod); err != nil {
+					clog.Error(err, "restore pod", "name", pod.Name, "namespace", pod.Namespace)
+					requeue = sooner(requeue, r.handleRestartFailure(ctx, pod, err, "restore its migs"))
+					continue
+				}
+				r.Adapter.RecordRestoredPod()
+				r.Adapter.RecordRestoreWithContext(ctx, pod)
 			}
+			// the pods held back by their cooldown or restore policy are checked again once due
+			requeue = sooner(requeue, r.Adapter.NextRestoreCheck())
 			clog.Info("reconciler", "deleted", req.NamespacedName.String())
 
-			return ctrl.Result{}, nil
+			return ctrl.Result{RequeueAfter: requeue}, nil
 		}
 		return ctrl.Result{}, err
 	}
 
-	if r.Adapter.IsPodPendingForMIGs(pod) {
+	// pods opted out are never resized, nor is a node repartitioned for them
+	if r.Adapter.IsPodPendingForMIGs(pod) && r.Adapter.IsPodTargetedWithContext(ctx, pod) {
 		nodes, pods := r.GetAllNodesAndPodsWithContext(ctx)
-		restart := r.Adapter.AdaptPodToGPUsWithContext(ctx, pod, nodes, pods)
+		// all pods pending for migs are planned together, the ones resized are restarted at once along
+		// with the pods of lower priority evicted for them. the node is only repartitioned for a pod neither
+		// restarted nor made room for
+		handled, preempted := false, true
+		requeue := time.Duration(0)
+		var failed error
+		for _, adaptation := range r.Adapter.AdaptPendingPodsWithContext(ctx, pod, nodes, pods) {
+			adapted := adaptation.Pod
+			trigger := adapted.Namespace == pod.Namespace && adapted.Name == pod.Name
+			handled = handled || trigger || adaptation.Evicted
+			if adaptation.Deferred {
+				requeue = sooner(requeue, gpuadapter.DEFERRED_RETRY_PERIOD)
+				continue
+			}
+			if r.Adapter.IsDryRun() {
+				// the decision is recorded by the adapter, the pod is left pending
+				continue
+			}
+			// the evicted pods come last but for the pod they make room for, which is not restarted unless they all are
+			if !preempted {
+				r.Adapter.CancelRestartWithContext(ctx, adapted)
+				continue
+			}
+			if err := r.restartPod(ctx, adapted); err != nil {
+				delay := r.handleRestartFailure(ctx, adapted, err, "resize its migs")
+				if adaptation.Evicted {
+					preempted = false
+				}
+				if trigger && delay == 0 {
+					failed = err
+				}
+				requeue = sooner(requeue, delay)
+				continue
+			}
+			switch {
+			case adaptation.Evicted:
+				r.Adapter.RecordEvictedPod()
+				continue
+			case adaptation.Downsized:
+				r.Adapter.RecordDownsizedPod()
+			default:
+				r.Adapter.RecordAdaptedPod()
+			}
+			r.Adapter.RecordAdaptationLatency(adapted)
+		}
+		if failed != nil {
+			return ctrl.Result{}, failed
+		}
 
-		if restart {
-			r.Delete(ctx, pod)
-		} else {
+		if !handled {
 			node := r.Adapter.AdaptGPUsToPodWithContext(ctx, pod, nodes, pods)
 			if node != nil {
-				r.Update(ctx, node, &client.UpdateOptions{})
+				if err := r.Update(ctx, node, &client.UpdateOptions{}); err != nil {
+					r.Adapter.RecordEvent(node, corev1.EventTypeWarning, gpuadapter.EVENT_REASON_REPARTITION_FAILED, "failed to relabel node: %v", err)
+					return ctrl.Result{}, err
+				}
+				r.Adapter.RecordRepartitionedPod()
 			}
 		}
+
+		return ctrl.Result{RequeueAfter: requeue}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// restartPod evicts the pod to be recreated by its owner, so that its PodDisruptionBudget holds,
+// bare pods are kept to be recreated by recreateBarePod
+func (r *PodReconciler) restartPod(ctx context.Context, pod *corev1.Pod) error {
+	if err := r.Adapter.StorePodForRecreationWithContext(ctx, pod); err != nil {
+		return err
+	}
+
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+	}
+	if err := r.SubResource("eviction").Create(ctx, pod, eviction); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	r.Adapter.ResetRestartBackoff(pod)
+
+	return nil
+}
+
+// handleRestartFailure undoes what was planned for a pod which could not be restarted, and returns how long to wait
+// before trying again if its PodDisruptionBudget blocked it, 0 otherwise
+func (r *PodReconciler) handleRestartFailure(ctx context.Context, pod *corev1.Pod, err error, purpose string) time.Duration {
+	if errors.IsTooManyRequests(err) {
+		delay := r.Adapter.BackOffRestartWithContext(ctx, pod)
+		r.Adapter.RecordEvent(pod, corev1.EventTypeWarning, gpuadapter.EVENT_REASON_RESTART_BLOCKED, "eviction to %s blocked by a PodDisruptionBudget, retry in %s", purpose, delay)
+		return delay
+	}
+
+	r.Adapter.CancelRestartWithContext(ctx, pod)
+	r.Adapter.RecordEvent(pod, corev1.EventTypeWarning, gpuadapter.EVENT_REASON_RESTART_FAILED, "failed to restart pod to %s: %v", purpose, err)
+	return 0
+}
+
+// sooner returns the shortest of two requeue delays, 0 being none
+func sooner(current, delay time.Duration) time.Duration {
+	if current == 0 || delay != 0 && delay < current {
+		return delay
+	}
+
+	return current
+}
+
+func (r *PodReconciler) recreateBarePod(ctx context.Context, podkey types.NamespacedName) error {
+	pod := r.Adapter.GetPodForRecreationWithContext(ctx, podkey)
+	if pod == nil {
+		return nil
+	}
+
+	err := r.Create(ctx, pod)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		clog.Error(err, "recreate bare pod", "pod", podkey.String())
+		r.Adapter.RecordEvent(pod, corev1.EventTypeWarning, gpuadapter.EVENT_REASON_RECREATE_FAILED, "failed to recreate pod: %v", err)
+		return err
+	}
+
+	r.Adapter.RemovePodForRecreationWithContext(ctx, podkey)
+	clog.Info("reconciler", "recreated", podkey.String())
+	return nil
+}
+
 func (r *PodReconciler) GetAllNodesAndPodsWithContext(ctx context.Context) ([]corev1.Node, []corev1.Pod) {
 	nodelist := &corev1.NodeList{}
 	err := r.List(ctx, nodelist)
@@ -28,6 +28,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	gpuv1alpha1 "github.com/IBM/mig-adapter/api/v1alpha1"
+	gpuadapter "github.com/IBM/mig-adapter/internal/adapter"
 )
 
 var _ = Describe("NVidiaMIGAdapter Controller", func() {
@@ -69,8 +70,9 @@ var _ = Describe("NVidiaMIGAdapter Controller", func() {
 		It("should successfully reconcile the resource", func() {
 			By("Reconciling the created resource")
 			controllerReconciler := &NVidiaMIGAdapterReconciler{
-				Client: k8sClient,
-				Scheme: k8sClient.Scheme(),
+				Client:  k8sClient,
+				Scheme:  k8sClient.Scheme(),
+				Adapter: gpuadapter.GetAdapter(k8sClient),
 			}
 
 			_, err := controllerReconciler.Reconcile(ctx, reconcile.Request{
@@ -48,7 +48,7 @@ func (pd *PodDefaulter) Default(ctx context.Context, obj runtime.Object) error {
 	pod := obj.(*corev1.Pod)
 
 	whlog.Info("defaulter", "pod name", pod.Name, "pod genname", pod.GenerateName)
-	pd.Adapter.CheckAndUpdatePodWithContext(context.TODO(), pod)
+	pd.Adapter.CheckAndUpdatePodWithContext(ctx, pod)
 
 	return nil
 }